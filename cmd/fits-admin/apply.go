@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// item is an entry in the registry that can be added or updated in the DB.
+type item interface {
+	// key identifies the item e.g., type t1.
+	key() string
+	// text is the rest of the item.  Items are changed if the text is different.
+	text() string
+	upsert(tx *sql.Tx) error
+}
+
+func (u unit) key() string { return "unit " + u.Symbol }
+func (u unit) text() string {
+	return withDeprecated(u.Name, u.Deprecated)
+}
+func (u unit) upsert(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO fits.unit (symbol, name, deprecated) VALUES ($1, $2, $3)
+		ON CONFLICT (symbol) DO UPDATE SET name = EXCLUDED.name, deprecated = EXCLUDED.deprecated`,
+		u.Symbol, u.Name, u.Deprecated)
+	return err
+}
+
+func (c conversion) key() string  { return "conversion " + c.From + " to " + c.To }
+func (c conversion) text() string { return fmt.Sprintf("factor %s, shift %s", c.Factor, c.Shift) }
+func (c conversion) upsert(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO fits.unit_conversion (fromunitpk, tounitpk, factor, shift)
+		SELECT f.unitpk, t.unitpk, $3::numeric, $4::numeric FROM fits.unit AS f, fits.unit AS t WHERE f.symbol = $1 AND t.symbol = $2
+		ON CONFLICT (fromunitpk, tounitpk) DO UPDATE SET factor = EXCLUDED.factor, shift = EXCLUDED.shift`,
+		c.From, c.To, string(c.Factor), string(c.Shift))
+	return err
+}
+
+func (t dataType) key() string { return "type " + t.TypeID }
+func (t dataType) text() string {
+	return withDeprecated(strings.Join([]string{t.Name, t.Description, t.Unit}, ", "), t.Deprecated)
+}
+
+// upsert adds or updates t.  The unit can't be changed once there are observations for the type.
+func (t dataType) upsert(tx *sql.Tx) error {
+	var used bool
+
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM fits.type JOIN fits.unit USING (unitpk) JOIN fits.observation USING (typepk)
+		WHERE typeid = $1 AND symbol <> $2)`, t.TypeID, t.Unit).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used {
+		return errors.New("can't change the unit for a type that has observations")
+	}
+
+	_, err = tx.Exec(`INSERT INTO fits.type (typeid, name, description, unitpk, deprecated)
+		SELECT $1, $2, $3, unitpk, $5 FROM fits.unit WHERE symbol = $4
+		ON CONFLICT (typeid) DO UPDATE SET name = EXCLUDED.name, description = EXCLUDED.description,
+		unitpk = EXCLUDED.unitpk, deprecated = EXCLUDED.deprecated`,
+		t.TypeID, t.Name, t.Description, t.Unit, t.Deprecated)
+	return err
+}
+
+func (m method) key() string { return "method " + m.MethodID }
+func (m method) text() string {
+	return withDeprecated(strings.Join([]string{m.Name, m.Description, m.Reference}, ", "), m.Deprecated)
+}
+func (m method) upsert(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO fits.method (methodid, name, description, reference, deprecated) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (methodid) DO UPDATE SET name = EXCLUDED.name, description = EXCLUDED.description,
+		reference = EXCLUDED.reference, deprecated = EXCLUDED.deprecated`,
+		m.MethodID, m.Name, m.Description, m.Reference, m.Deprecated)
+	return err
+}
+
+func (l link) key() string { return "link " + l.TypeID + " " + l.MethodID }
+func (l link) text() string {
+	if l.Deprecated {
+		return "deprecated"
+	}
+	return "not deprecated"
+}
+func (l link) upsert(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO fits.type_method (typepk, methodpk, deprecated)
+		SELECT typepk, methodpk, $3 FROM fits.type, fits.method WHERE typeid = $1 AND methodid = $2
+		ON CONFLICT (typepk, methodpk) DO UPDATE SET deprecated = EXCLUDED.deprecated`,
+		l.TypeID, l.MethodID, l.Deprecated)
+	return err
+}
+
+func (s system) key() string  { return "system " + s.SystemID }
+func (s system) text() string { return withDeprecated(s.Description, s.Deprecated) }
+func (s system) upsert(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO fits.system (systemid, description, deprecated) VALUES ($1, $2, $3)
+		ON CONFLICT (systemid) DO UPDATE SET description = EXCLUDED.description, deprecated = EXCLUDED.deprecated`,
+		s.SystemID, s.Description, s.Deprecated)
+	return err
+}
+
+func withDeprecated(s string, deprecated bool) string {
+	switch {
+	case !deprecated:
+		return s
+	case s == "":
+		return "deprecated"
+	default:
+		return s + ", deprecated"
+	}
+}
+
+func describe(key, text string) string {
+	if text == "" {
+		return key
+	}
+
+	return key + ": " + text
+}
+
+// apply adds or updates the items in to that are new or different to the items in from.
+// The changes are printed to out as inserts (+) and updates (~).  It returns the number of changes.
+func apply(tx *sql.Tx, from, to registry, out io.Writer) (int, error) {
+	var n int
+
+	// the order matters, items are added before the items that use them.
+	for _, f := range []func() (int, error){
+		func() (int, error) { return applyItems(tx, from.Units, to.Units, out) },
+		func() (int, error) { return applyItems(tx, from.Conversions, to.Conversions, out) },
+		func() (int, error) { return applyItems(tx, from.Types, to.Types, out) },
+		func() (int, error) { return applyItems(tx, from.Methods, to.Methods, out) },
+		func() (int, error) { return applyItems(tx, from.TypeMethods, to.TypeMethods, out) },
+		func() (int, error) { return applyItems(tx, from.Systems, to.Systems, out) },
+	} {
+		c, err := f()
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func applyItems[T item](tx *sql.Tx, from, to []T, out io.Writer) (int, error) {
+	var n int
+
+	was := make(map[string]string)
+	for _, v := range from {
+		was[v.key()] = v.text()
+	}
+
+	for _, v := range to {
+		w, ok := was[v.key()]
+		switch {
+		case !ok:
+			fmt.Fprintln(out, "+", describe(v.key(), v.text()))
+		case w == v.text():
+			continue
+		default:
+			fmt.Fprintln(out, "~", describe(v.key(), v.text()), "was", w)
+		}
+
+		if err := v.upsert(tx); err != nil {
+			return n, fmt.Errorf("%s: %w", v.key(), err)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+/*
+edit loads the registry, changes it with f, and then validates it and applies the changes in
+one transaction.  The changes are printed to out.  For a dry run the transaction is rolled back.
+It returns the number of changes.
+*/
+func edit(db *sql.DB, dryRun bool, out io.Writer, f func(r *registry) error) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	from, err := load(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	to := from.clone()
+
+	if err = f(&to); err != nil {
+		return 0, err
+	}
+
+	if errs := to.validate(); len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+
+	n, err := apply(tx, from, to, out)
+	if err != nil {
+		return 0, err
+	}
+
+	if dryRun {
+		log.Printf("%d changes, dry run, nothing was written", n)
+		return n, nil
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("%d changes", n)
+
+	return n, nil
+}
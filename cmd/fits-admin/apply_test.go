@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// Test editing the registry in the test DB.  Changes are made with dry runs so the DB is not changed.
+func TestEdit(t *testing.T) {
+	db, err := sql.Open("postgres", "host=localhost connect_timeout=5 user=fits_w password=test dbname=fits sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// exporting and then importing the registry changes nothing.
+	r, err := load(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+
+	if err = write(&b, r); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := read(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Reset()
+
+	n, err := edit(db, true, &b, func(r *registry) error {
+		if errs := r.merge(in); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 || b.Len() != 0 {
+		t.Errorf("expected no changes got %d:\n%s", n, b.String())
+	}
+
+	n, err = edit(db, true, &b, func(r *registry) error {
+		r.Types = append(r.Types, dataType{TypeID: "t3", Name: "Type 3", Description: "Test data type 3", Unit: "mm"})
+		r.TypeMethods = append(r.TypeMethods, link{TypeID: "t3", MethodID: "m1"})
+		r.findMethod("m2").Name = "Method two"
+		r.deprecateType(r.findType("t2"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `~ type t2: Type 1, Test data type 2, K, deprecated was Type 1, Test data type 2, K
++ type t3: Type 3, Test data type 3, mm
+~ method m2: Method two, Test data method 2, a link to more information about method 2 was Method 2, Test data method 2, a link to more information about method 2
+~ link t2 m1: deprecated was not deprecated
++ link t3 m1: not deprecated
+`
+	if n != 5 || b.String() != expected {
+		t.Errorf("expected 5 changes:\n%s\ngot %d:\n%s", expected, n, b.String())
+	}
+
+	if r, err = load(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.findType("t3") != nil || r.findType("t2").Deprecated {
+		t.Error("expected no changes for a dry run")
+	}
+
+	// t1 has observations so its unit can't be changed.
+	_, err = edit(db, true, &b, func(r *registry) error {
+		r.findType("t1").Unit = "mm"
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "has observations") {
+		t.Errorf("expected an error changing the unit for t1 got %v", err)
+	}
+
+	// K is used by t2.
+	_, err = edit(db, true, &b, func(r *registry) error {
+		r.findUnit("K").Deprecated = true
+		return nil
+	})
+	if err == nil {
+		t.Error("expected an error deprecating a unit that is used")
+	}
+}
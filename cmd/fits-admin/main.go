@@ -0,0 +1,408 @@
+/*
+fits-admin manages the FITS registry: units, unit conversions, types, methods, the methods that can be used
+for each type (links), and systems.
+
+	fits-admin unit add|update|deprecate|restore [-dry-run] -symbol s [-name n]
+	fits-admin conversion add|update [-dry-run] -from s -to s -factor f [-shift s]
+	fits-admin type add|update|deprecate|restore [-dry-run] -typeID id [-name n] [-description d] [-unit s]
+	fits-admin method add|update|deprecate|restore [-dry-run] -methodID id [-name n] [-description d] [-reference r]
+	fits-admin link add|deprecate|restore [-dry-run] -typeID id -methodID id
+	fits-admin system add|update|deprecate|restore [-dry-run] -systemID id [-description d]
+	fits-admin export [-o file]
+	fits-admin import [-dry-run] file
+
+update only changes the values that are specified.  Deprecated items are kept for the existing observations
+but can't be used for new observations.  Deprecating a type or a method also deprecates its links.  restore
+undoes deprecate.  Items can't be deleted.
+
+After each change the whole registry is checked before anything is written.  Types must use a unit
+that exists and is not deprecated, links must use a type and method that exist and are not deprecated,
+and the unit for a type can't be changed once there are observations for the type.
+
+export writes the registry as a versioned YAML file, to stdout by default.  import adds or updates the
+items in a YAML file in one transaction.  Items that are not in the file are not changed.
+
+The inserts (+) and updates (~) are printed.  With -dry-run they are rolled back.
+
+The DB connection is configured from the environment in the same way as fits-api.  The user
+must be able to write to the fits schema.
+*/
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+
+	_ "github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `usage:
+	fits-admin unit|type|method|system add|update|deprecate|restore [flags]
+	fits-admin conversion add|update [flags]
+	fits-admin link add|deprecate|restore [flags]
+	fits-admin export [-o file]
+	fits-admin import [-dry-run] file
+Use -h after the command for the flags.`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s connect_timeout=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_CONN_TIMEOUT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWD"),
+		os.Getenv("DB_NAME"),
+		os.Getenv("DB_SSLMODE")))
+	if err != nil {
+		log.Fatalf("ERROR: problem with DB config: %s", err)
+	}
+	defer db.Close()
+
+	cmd, args := os.Args[1], os.Args[2:]
+
+	switch cmd {
+	case "export":
+		err = exportCmd(db, args)
+	case "import":
+		err = importCmd(db, args)
+	default:
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+
+		fs := flag.NewFlagSet(cmd+" "+args[0], flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "print the changes without making them")
+
+		var f func(*registry) error
+
+		f, err = command(cmd, args[0], fs, args[1:])
+		if err == nil {
+			_, err = edit(db, *dryRun, os.Stdout, f)
+		}
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func exportCmd(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	o := fs.String("o", "", "the file to write the registry to, stdout by default")
+	fs.Parse(args)
+
+	r, err := load(db)
+	if err != nil {
+		return err
+	}
+
+	if *o == "" {
+		return write(os.Stdout, r)
+	}
+
+	f, err := os.Create(*o)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = write(f, r); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func importCmd(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the changes without making them")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("import needs one file")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	in, err := read(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fs.Arg(0), err)
+	}
+
+	_, err = edit(db, *dryRun, os.Stdout, func(r *registry) error {
+		return errors.Join(r.merge(in)...)
+	})
+
+	return err
+}
+
+// write writes r as YAML.
+func write(w io.Writer, r registry) error {
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+
+	if err := e.Encode(r); err != nil {
+		return err
+	}
+
+	return e.Close()
+}
+
+// read reads a registry from YAML.  Unknown fields and versions are errors.
+func read(r io.Reader) (registry, error) {
+	var reg registry
+
+	d := yaml.NewDecoder(r)
+	d.KnownFields(true)
+
+	if err := d.Decode(&reg); err != nil {
+		return reg, err
+	}
+
+	if reg.Version != version {
+		return reg, fmt.Errorf("unsupported version %d, expected %d", reg.Version, version)
+	}
+
+	return reg, nil
+}
+
+/*
+command parses the flags for the action on an item and returns a function to make the change.
+fs has the flags that are common to all commands.
+*/
+func command(cmd, action string, fs *flag.FlagSet, args []string) (func(*registry) error, error) {
+	var f func(r *registry) error
+
+	// set has the names of the flags that were set.  It is filled when the flags are parsed.
+	set := make(map[string]bool)
+
+	switch cmd {
+	case "unit":
+		symbol := fs.String("symbol", "", "the unit symbol e.g., mm")
+		name := fs.String("name", "", "the unit name e.g., millimetre")
+
+		f = func(r *registry) error {
+			u := r.findUnit(*symbol)
+
+			switch {
+			case action == "add" && u != nil:
+				return fmt.Errorf("unit %s exists", *symbol)
+			case action == "add":
+				r.Units = append(r.Units, unit{Symbol: *symbol, Name: *name})
+				return nil
+			case u == nil:
+				return fmt.Errorf("unknown unit %q", *symbol)
+			}
+
+			switch action {
+			case "update":
+				if set["name"] {
+					u.Name = *name
+				}
+			case "deprecate":
+				u.Deprecated = true
+			case "restore":
+				u.Deprecated = false
+			}
+
+			return nil
+		}
+	case "conversion":
+		from := fs.String("from", "", "the unit symbol to convert from")
+		to := fs.String("to", "", "the unit symbol to convert to")
+		factor := fs.String("factor", "", "multiply the value by factor")
+		shift := fs.String("shift", "0", "then add shift")
+
+		f = func(r *registry) error {
+			c := r.findConversion(*from, *to)
+
+			switch {
+			case action == "add" && c != nil:
+				return fmt.Errorf("conversion %s to %s exists", *from, *to)
+			case action == "add":
+				r.Conversions = append(r.Conversions, conversion{From: *from, To: *to, Factor: number(*factor), Shift: number(*shift)})
+				return nil
+			case c == nil:
+				return fmt.Errorf("unknown conversion %s to %s", *from, *to)
+			}
+
+			if set["factor"] {
+				c.Factor = number(*factor)
+			}
+			if set["shift"] {
+				c.Shift = number(*shift)
+			}
+
+			return nil
+		}
+	case "type":
+		typeID := fs.String("typeID", "", "the typeID e.g., e")
+		name := fs.String("name", "", "the type name e.g., east")
+		description := fs.String("description", "", "a description of the type")
+		unitSymbol := fs.String("unit", "", "the unit symbol for the type e.g., mm")
+
+		f = func(r *registry) error {
+			t := r.findType(*typeID)
+
+			switch {
+			case action == "add" && t != nil:
+				return fmt.Errorf("type %s exists", *typeID)
+			case action == "add":
+				r.Types = append(r.Types, dataType{TypeID: *typeID, Name: *name, Description: *description, Unit: *unitSymbol})
+				return nil
+			case t == nil:
+				return fmt.Errorf("unknown typeID %q", *typeID)
+			}
+
+			switch action {
+			case "update":
+				if set["name"] {
+					t.Name = *name
+				}
+				if set["description"] {
+					t.Description = *description
+				}
+				if set["unit"] {
+					t.Unit = *unitSymbol
+				}
+			case "deprecate":
+				r.deprecateType(t)
+			case "restore":
+				t.Deprecated = false
+			}
+
+			return nil
+		}
+	case "method":
+		methodID := fs.String("methodID", "", "the methodID e.g., bernese5")
+		name := fs.String("name", "", "the method name")
+		description := fs.String("description", "", "a description of the method")
+		reference := fs.String("reference", "", "a reference for the method e.g., a URL")
+
+		f = func(r *registry) error {
+			m := r.findMethod(*methodID)
+
+			switch {
+			case action == "add" && m != nil:
+				return fmt.Errorf("method %s exists", *methodID)
+			case action == "add":
+				r.Methods = append(r.Methods, method{MethodID: *methodID, Name: *name, Description: *description, Reference: *reference})
+				return nil
+			case m == nil:
+				return fmt.Errorf("unknown methodID %q", *methodID)
+			}
+
+			switch action {
+			case "update":
+				if set["name"] {
+					m.Name = *name
+				}
+				if set["description"] {
+					m.Description = *description
+				}
+				if set["reference"] {
+					m.Reference = *reference
+				}
+			case "deprecate":
+				r.deprecateMethod(m)
+			case "restore":
+				m.Deprecated = false
+			}
+
+			return nil
+		}
+	case "link":
+		typeID := fs.String("typeID", "", "the typeID")
+		methodID := fs.String("methodID", "", "the methodID that can be used for the type")
+
+		f = func(r *registry) error {
+			l := r.findLink(*typeID, *methodID)
+
+			switch {
+			case action == "add" && l != nil:
+				return fmt.Errorf("link %s %s exists", *typeID, *methodID)
+			case action == "add":
+				r.TypeMethods = append(r.TypeMethods, link{TypeID: *typeID, MethodID: *methodID})
+				return nil
+			case l == nil:
+				return fmt.Errorf("unknown link %s %s", *typeID, *methodID)
+			}
+
+			l.Deprecated = action == "deprecate"
+
+			return nil
+		}
+	case "system":
+		systemID := fs.String("systemID", "", "the systemID")
+		description := fs.String("description", "", "a description of the system")
+
+		f = func(r *registry) error {
+			s := r.findSystem(*systemID)
+
+			switch {
+			case action == "add" && s != nil:
+				return fmt.Errorf("system %s exists", *systemID)
+			case action == "add":
+				r.Systems = append(r.Systems, system{SystemID: *systemID, Description: *description})
+				return nil
+			case s == nil:
+				return fmt.Errorf("unknown systemID %q", *systemID)
+			}
+
+			switch action {
+			case "update":
+				if set["description"] {
+					s.Description = *description
+				}
+			case "deprecate":
+				s.Deprecated = true
+			case "restore":
+				s.Deprecated = false
+			}
+
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown command %q\n%s", cmd, usage)
+	}
+
+	actions := map[string][]string{
+		"conversion": {"add", "update"},
+		"link":       {"add", "deprecate", "restore"},
+	}
+	allowed, ok := actions[cmd]
+	if !ok {
+		allowed = []string{"add", "update", "deprecate", "restore"}
+	}
+
+	if !slices.Contains(allowed, action) {
+		return nil, fmt.Errorf("unknown action %q for %s, expected one of %v", action, cmd, allowed)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	return f, nil
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/GeoNet/fits/internal/ingest"
+	"github.com/GeoNet/fits/internal/valid"
+	"gopkg.in/yaml.v3"
+)
+
+// version is the version of the registry file format.
+const version = 1
+
+// registry is the units, unit conversions, types, methods, type method links, and systems
+// in FITS.  It is exported and imported as YAML.
+type registry struct {
+	Version     int          `yaml:"version"`
+	Units       []unit       `yaml:"units"`
+	Conversions []conversion `yaml:"conversions"`
+	Types       []dataType   `yaml:"types"`
+	Methods     []method     `yaml:"methods"`
+	TypeMethods []link       `yaml:"typeMethods"`
+	Systems     []system     `yaml:"systems"`
+}
+
+type unit struct {
+	Symbol     string `yaml:"symbol"`
+	Name       string `yaml:"name"`
+	Deprecated bool   `yaml:"deprecated,omitempty"`
+}
+
+// conversion converts values from one unit to another as value * factor + shift.
+type conversion struct {
+	From   string `yaml:"from"`
+	To     string `yaml:"to"`
+	Factor number `yaml:"factor"`
+	Shift  number `yaml:"shift"`
+}
+
+type dataType struct {
+	TypeID      string `yaml:"typeID"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Unit        string `yaml:"unit"`
+	Deprecated  bool   `yaml:"deprecated,omitempty"`
+}
+
+type method struct {
+	MethodID    string `yaml:"methodID"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Reference   string `yaml:"reference"`
+	Deprecated  bool   `yaml:"deprecated,omitempty"`
+}
+
+// link is a method that can be used for a type.
+type link struct {
+	TypeID     string `yaml:"typeID"`
+	MethodID   string `yaml:"methodID"`
+	Deprecated bool   `yaml:"deprecated,omitempty"`
+}
+
+type system struct {
+	SystemID    string `yaml:"systemID"`
+	Description string `yaml:"description"`
+	Deprecated  bool   `yaml:"deprecated,omitempty"`
+}
+
+// number is a decimal that is kept as the text that was read so it can be stored exactly.
+// It is written to YAML as a number.
+type number string
+
+func (n number) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: string(n)}, nil
+}
+
+func (n *number) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a number", value.Line)
+	}
+
+	*n = number(value.Value)
+
+	return nil
+}
+
+// load reads the registry from the DB.
+func load(db ingest.Querier) (registry, error) {
+	r := registry{Version: version}
+
+	err := ingest.Query(db, `SELECT symbol, name, deprecated FROM fits.unit ORDER BY symbol`, func(rows *sql.Rows) error {
+		var u unit
+		err := rows.Scan(&u.Symbol, &u.Name, &u.Deprecated)
+		r.Units = append(r.Units, u)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT f.symbol, t.symbol, factor::text, shift::text FROM fits.unit_conversion
+		JOIN fits.unit AS f ON (fromunitpk = f.unitpk) JOIN fits.unit AS t ON (tounitpk = t.unitpk)
+		ORDER BY f.symbol, t.symbol`, func(rows *sql.Rows) error {
+		var c conversion
+		err := rows.Scan(&c.From, &c.To, &c.Factor, &c.Shift)
+		r.Conversions = append(r.Conversions, c)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT typeid, t.name, description, symbol, t.deprecated FROM fits.type AS t
+		JOIN fits.unit USING (unitpk) ORDER BY typeid`, func(rows *sql.Rows) error {
+		var t dataType
+		err := rows.Scan(&t.TypeID, &t.Name, &t.Description, &t.Unit, &t.Deprecated)
+		r.Types = append(r.Types, t)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT methodid, name, description, reference, deprecated FROM fits.method ORDER BY methodid`,
+		func(rows *sql.Rows) error {
+			var m method
+			err := rows.Scan(&m.MethodID, &m.Name, &m.Description, &m.Reference, &m.Deprecated)
+			r.Methods = append(r.Methods, m)
+			return err
+		})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT typeid, methodid, tm.deprecated FROM fits.type_method AS tm JOIN fits.type USING (typepk)
+		JOIN fits.method USING (methodpk) ORDER BY typeid, methodid`, func(rows *sql.Rows) error {
+		var l link
+		err := rows.Scan(&l.TypeID, &l.MethodID, &l.Deprecated)
+		r.TypeMethods = append(r.TypeMethods, l)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT systemid, description, deprecated FROM fits.system ORDER BY systemid`, func(rows *sql.Rows) error {
+		var s system
+		err := rows.Scan(&s.SystemID, &s.Description, &s.Deprecated)
+		r.Systems = append(r.Systems, s)
+		return err
+	})
+
+	return r, err
+}
+
+// clone returns a copy of r that can be changed without changing r.
+func (r registry) clone() registry {
+	r.Units = slices.Clone(r.Units)
+	r.Conversions = slices.Clone(r.Conversions)
+	r.Types = slices.Clone(r.Types)
+	r.Methods = slices.Clone(r.Methods)
+	r.TypeMethods = slices.Clone(r.TypeMethods)
+	r.Systems = slices.Clone(r.Systems)
+
+	return r
+}
+
+func (r *registry) findUnit(symbol string) *unit {
+	for i := range r.Units {
+		if r.Units[i].Symbol == symbol {
+			return &r.Units[i]
+		}
+	}
+	return nil
+}
+
+func (r *registry) findConversion(from, to string) *conversion {
+	for i := range r.Conversions {
+		if r.Conversions[i].From == from && r.Conversions[i].To == to {
+			return &r.Conversions[i]
+		}
+	}
+	return nil
+}
+
+func (r *registry) findType(typeID string) *dataType {
+	for i := range r.Types {
+		if r.Types[i].TypeID == typeID {
+			return &r.Types[i]
+		}
+	}
+	return nil
+}
+
+func (r *registry) findMethod(methodID string) *method {
+	for i := range r.Methods {
+		if r.Methods[i].MethodID == methodID {
+			return &r.Methods[i]
+		}
+	}
+	return nil
+}
+
+func (r *registry) findLink(typeID, methodID string) *link {
+	for i := range r.TypeMethods {
+		if r.TypeMethods[i].TypeID == typeID && r.TypeMethods[i].MethodID == methodID {
+			return &r.TypeMethods[i]
+		}
+	}
+	return nil
+}
+
+func (r *registry) findSystem(systemID string) *system {
+	for i := range r.Systems {
+		if r.Systems[i].SystemID == systemID {
+			return &r.Systems[i]
+		}
+	}
+	return nil
+}
+
+// merge adds the items in o to r or replaces the items in r with the same key.
+// Items that are only in r are kept.  It returns an error for each item that is repeated in o.
+func (r *registry) merge(o registry) []error {
+	var errs []error
+
+	seen := make(map[string]bool)
+
+	// repeated returns true if k has already been seen in o.
+	repeated := func(k string) bool {
+		if seen[k] {
+			errs = append(errs, fmt.Errorf("%s is repeated", k))
+			return true
+		}
+		seen[k] = true
+		return false
+	}
+
+	for _, v := range o.Units {
+		switch p := r.findUnit(v.Symbol); {
+		case repeated("unit " + v.Symbol):
+		case p != nil:
+			*p = v
+		default:
+			r.Units = append(r.Units, v)
+		}
+	}
+
+	for _, v := range o.Conversions {
+		switch p := r.findConversion(v.From, v.To); {
+		case repeated("conversion " + v.From + " to " + v.To):
+		case p != nil:
+			*p = v
+		default:
+			r.Conversions = append(r.Conversions, v)
+		}
+	}
+
+	for _, v := range o.Types {
+		switch p := r.findType(v.TypeID); {
+		case repeated("type " + v.TypeID):
+		case p != nil:
+			*p = v
+		default:
+			r.Types = append(r.Types, v)
+		}
+	}
+
+	for _, v := range o.Methods {
+		switch p := r.findMethod(v.MethodID); {
+		case repeated("method " + v.MethodID):
+		case p != nil:
+			*p = v
+		default:
+			r.Methods = append(r.Methods, v)
+		}
+	}
+
+	for _, v := range o.TypeMethods {
+		switch p := r.findLink(v.TypeID, v.MethodID); {
+		case repeated("link " + v.TypeID + " " + v.MethodID):
+		case p != nil:
+			*p = v
+		default:
+			r.TypeMethods = append(r.TypeMethods, v)
+		}
+	}
+
+	for _, v := range o.Systems {
+		switch p := r.findSystem(v.SystemID); {
+		case repeated("system " + v.SystemID):
+		case p != nil:
+			*p = v
+		default:
+			r.Systems = append(r.Systems, v)
+		}
+	}
+
+	return errs
+}
+
+// deprecateType deprecates a type and the links to its methods.
+func (r *registry) deprecateType(t *dataType) {
+	t.Deprecated = true
+
+	for i := range r.TypeMethods {
+		if r.TypeMethods[i].TypeID == t.TypeID {
+			r.TypeMethods[i].Deprecated = true
+		}
+	}
+}
+
+// deprecateMethod deprecates a method and the links to it from types.
+func (r *registry) deprecateMethod(m *method) {
+	m.Deprecated = true
+
+	for i := range r.TypeMethods {
+		if r.TypeMethods[i].MethodID == m.MethodID {
+			r.TypeMethods[i].Deprecated = true
+		}
+	}
+}
+
+/*
+validate checks the items in r and the references between them.  Items must have valid IDs and
+can't be repeated.  Types must use a unit that exists and links must use a type and a method that exist.
+Types and links that are not deprecated can't use a deprecated unit, type, or method.  Conversions
+must be between units that exist and can only be added in one direction.
+*/
+func (r registry) validate() []error {
+	var errs []error
+
+	bad := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	seen := make(map[string]bool)
+
+	// repeated returns true if k has already been seen.
+	repeated := func(k string) bool {
+		if seen[k] {
+			bad("%s is repeated", k)
+			return true
+		}
+		seen[k] = true
+		return false
+	}
+
+	for _, u := range r.Units {
+		if repeated("unit " + u.Symbol) {
+			continue
+		}
+		if err := valid.Parameter("unit", u.Symbol); err != nil {
+			bad("unit %q: %s", u.Symbol, err)
+		}
+		if strings.TrimSpace(u.Name) == "" {
+			bad("unit %s: missing name", u.Symbol)
+		}
+	}
+
+	for _, c := range r.Conversions {
+		k := "conversion " + c.From + " to " + c.To
+		if repeated(k) {
+			continue
+		}
+		if r.findUnit(c.From) == nil {
+			bad("%s: unknown unit %q", k, c.From)
+		}
+		if r.findUnit(c.To) == nil {
+			bad("%s: unknown unit %q", k, c.To)
+		}
+		if c.From == c.To {
+			bad("%s: can't convert a unit to itself", k)
+		}
+		if r.findConversion(c.To, c.From) != nil {
+			bad("%s: the inverse has also been added, add only one direction", k)
+		}
+		if f, err := parseNumber(c.Factor); err != nil || f <= 0 {
+			bad("%s: factor must be a number greater than 0: %q", k, c.Factor)
+		}
+		if _, err := parseNumber(c.Shift); err != nil {
+			bad("%s: invalid shift: %q", k, c.Shift)
+		}
+	}
+
+	for _, t := range r.Types {
+		if repeated("type " + t.TypeID) {
+			continue
+		}
+		if err := valid.Parameter("typeID", t.TypeID); err != nil {
+			bad("type %q: %s", t.TypeID, err)
+		}
+		if strings.TrimSpace(t.Name) == "" {
+			bad("type %s: missing name", t.TypeID)
+		}
+		u := r.findUnit(t.Unit)
+		switch {
+		case u == nil:
+			bad("type %s: unknown unit %q", t.TypeID, t.Unit)
+		case u.Deprecated && !t.Deprecated:
+			bad("type %s: unit %s is deprecated", t.TypeID, t.Unit)
+		}
+	}
+
+	for _, m := range r.Methods {
+		if repeated("method " + m.MethodID) {
+			continue
+		}
+		if err := valid.Parameter("methodID", m.MethodID); err != nil {
+			bad("method %q: %s", m.MethodID, err)
+		}
+		if strings.TrimSpace(m.Name) == "" {
+			bad("method %s: missing name", m.MethodID)
+		}
+	}
+
+	for _, l := range r.TypeMethods {
+		k := "link " + l.TypeID + " " + l.MethodID
+		if repeated(k) {
+			continue
+		}
+		t := r.findType(l.TypeID)
+		switch {
+		case t == nil:
+			bad("%s: unknown typeID %q", k, l.TypeID)
+		case t.Deprecated && !l.Deprecated:
+			bad("%s: type %s is deprecated", k, l.TypeID)
+		}
+		m := r.findMethod(l.MethodID)
+		switch {
+		case m == nil:
+			bad("%s: unknown methodID %q", k, l.MethodID)
+		case m.Deprecated && !l.Deprecated:
+			bad("%s: method %s is deprecated", k, l.MethodID)
+		}
+	}
+
+	for _, s := range r.Systems {
+		if repeated("system " + s.SystemID) {
+			continue
+		}
+		if err := valid.Parameter("systemID", s.SystemID); err != nil {
+			bad("system %q: %s", s.SystemID, err)
+		}
+	}
+
+	return errs
+}
+
+func parseNumber(n number) (float64, error) {
+	if !ingest.Decimal(string(n)) {
+		return 0, errors.New("invalid number")
+	}
+
+	return strconv.ParseFloat(string(n), 64)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testRegistry() registry {
+	return registry{
+		Version:     version,
+		Units:       []unit{{Symbol: "m", Name: "metre"}, {Symbol: "mm", Name: "millimetre"}, {Symbol: "K", Name: "kelvin"}},
+		Conversions: []conversion{{From: "m", To: "mm", Factor: "1000", Shift: "0"}},
+		Types:       []dataType{{TypeID: "t1", Name: "Type 1", Unit: "m"}, {TypeID: "t2", Name: "Type 2", Unit: "K"}},
+		Methods:     []method{{MethodID: "m1", Name: "Method 1"}, {MethodID: "m2", Name: "Method 2"}},
+		TypeMethods: []link{{TypeID: "t1", MethodID: "m1"}, {TypeID: "t1", MethodID: "m2"}, {TypeID: "t2", MethodID: "m1"}},
+		Systems:     []system{{SystemID: "none", Description: "No external system reference"}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	in := []struct {
+		f   func(r *registry)
+		err bool
+		id  string
+	}{
+		{f: func(r *registry) {}, id: loc()},
+		{f: func(r *registry) {
+			r.Units[2].Deprecated = true
+			r.Types[1].Deprecated = true
+			r.TypeMethods[2].Deprecated = true
+		}, id: loc()},
+		{f: func(r *registry) { r.Conversions[0].Factor = "1e3" }, id: loc()},
+		{f: func(r *registry) { r.Units = append(r.Units, unit{Symbol: "°C", Name: "degrees Celsius"}) }, id: loc()},
+		{f: func(r *registry) { r.Units = append(r.Units, unit{Symbol: "m", Name: "metre"}) }, err: true, id: loc()},
+		{f: func(r *registry) { r.Units = append(r.Units, unit{Symbol: "m m", Name: "metre"}) }, err: true, id: loc()},
+		{f: func(r *registry) { r.Units[0].Name = " " }, err: true, id: loc()},
+		{f: func(r *registry) { r.Units[2].Deprecated = true }, err: true, id: loc()},
+		{f: func(r *registry) { r.Conversions[0].Factor = "0" }, err: true, id: loc()},
+		{f: func(r *registry) { r.Conversions[0].Factor = "a" }, err: true, id: loc()},
+		{f: func(r *registry) { r.Conversions[0].Shift = "" }, err: true, id: loc()},
+		{f: func(r *registry) { r.Conversions[0].To = "m" }, err: true, id: loc()},
+		{f: func(r *registry) { r.Conversions[0].To = "cm" }, err: true, id: loc()},
+		{f: func(r *registry) {
+			r.Conversions = append(r.Conversions, conversion{From: "mm", To: "m", Factor: "0.001", Shift: "0"})
+		}, err: true, id: loc()},
+		{f: func(r *registry) { r.Types[0].TypeID = "t 1" }, err: true, id: loc()},
+		{f: func(r *registry) { r.Types[0].Unit = "cm" }, err: true, id: loc()},
+		{f: func(r *registry) { r.Types[1].Deprecated = true }, err: true, id: loc()},
+		{f: func(r *registry) { r.Methods[1].Deprecated = true }, err: true, id: loc()},
+		{f: func(r *registry) { r.Methods[0].Name = "" }, err: true, id: loc()},
+		{f: func(r *registry) { r.TypeMethods = append(r.TypeMethods, link{TypeID: "t3", MethodID: "m1"}) }, err: true, id: loc()},
+		{f: func(r *registry) { r.TypeMethods = append(r.TypeMethods, link{TypeID: "t2", MethodID: "m3"}) }, err: true, id: loc()},
+		{f: func(r *registry) { r.Systems[0].SystemID = "" }, err: true, id: loc()},
+	}
+
+	for _, v := range in {
+		r := testRegistry()
+		v.f(&r)
+
+		errs := r.validate()
+		if (len(errs) > 0) != v.err {
+			t.Errorf("%s unexpected errors: %v", v.id, errs)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	in := []struct {
+		cmd  string
+		args []string
+		err  bool
+		f    func(r registry) bool
+		id   string
+	}{
+		{cmd: "unit", args: []string{"add", "-symbol", "cm", "-name", "centimetre"}, id: loc(),
+			f: func(r registry) bool { return r.findUnit("cm").Name == "centimetre" }},
+		{cmd: "unit", args: []string{"update", "-symbol", "mm", "-name", "millimetres"}, id: loc(),
+			f: func(r registry) bool { return r.findUnit("mm").Name == "millimetres" }},
+		{cmd: "unit", args: []string{"add", "-symbol", "mm", "-name", "millimetre"}, err: true, id: loc()},
+		{cmd: "unit", args: []string{"update", "-symbol", "cm", "-name", "centimetre"}, err: true, id: loc()},
+		{cmd: "conversion", args: []string{"add", "-from", "K", "-to", "mm", "-factor", "2"}, id: loc(),
+			f: func(r registry) bool { c := r.findConversion("K", "mm"); return c.Factor == "2" && c.Shift == "0" }},
+		{cmd: "conversion", args: []string{"update", "-from", "m", "-to", "mm", "-shift", "1"}, id: loc(),
+			f: func(r registry) bool { c := r.findConversion("m", "mm"); return c.Factor == "1000" && c.Shift == "1" }},
+		{cmd: "conversion", args: []string{"deprecate", "-from", "m", "-to", "mm"}, err: true, id: loc()},
+		// update only changes the flags that are set.
+		{cmd: "type", args: []string{"update", "-typeID", "t1", "-description", "Test type 1"}, id: loc(),
+			f: func(r registry) bool {
+				t := r.findType("t1")
+				return t.Name == "Type 1" && t.Description == "Test type 1" && t.Unit == "m"
+			}},
+		// deprecating a type deprecates its links.
+		{cmd: "type", args: []string{"deprecate", "-typeID", "t1"}, id: loc(),
+			f: func(r registry) bool {
+				return r.findType("t1").Deprecated && r.findLink("t1", "m1").Deprecated && r.findLink("t1", "m2").Deprecated &&
+					!r.findLink("t2", "m1").Deprecated
+			}},
+		{cmd: "method", args: []string{"deprecate", "-methodID", "m1"}, id: loc(),
+			f: func(r registry) bool {
+				return r.findMethod("m1").Deprecated && r.findLink("t1", "m1").Deprecated && r.findLink("t2", "m1").Deprecated &&
+					!r.findLink("t1", "m2").Deprecated
+			}},
+		{cmd: "method", args: []string{"add", "-methodID", "m3", "-name", "Method 3", "-reference", "a reference"}, id: loc(),
+			f: func(r registry) bool { return r.findMethod("m3").Reference == "a reference" }},
+		{cmd: "link", args: []string{"add", "-typeID", "t2", "-methodID", "m2"}, id: loc(),
+			f: func(r registry) bool { return r.findLink("t2", "m2") != nil }},
+		{cmd: "link", args: []string{"deprecate", "-typeID", "t1", "-methodID", "m2"}, id: loc(),
+			f: func(r registry) bool { return r.findLink("t1", "m2").Deprecated }},
+		{cmd: "link", args: []string{"update", "-typeID", "t1", "-methodID", "m2"}, err: true, id: loc()},
+		{cmd: "system", args: []string{"add", "-systemID", "lab", "-description", "A lab"}, id: loc(),
+			f: func(r registry) bool { return r.findSystem("lab").Description == "A lab" }},
+		{cmd: "system", args: []string{"deprecate", "-systemID", "none"}, id: loc(),
+			f: func(r registry) bool { return r.findSystem("none").Deprecated }},
+		{cmd: "system", args: []string{"remove", "-systemID", "none"}, err: true, id: loc()},
+		{cmd: "sample", args: []string{"add"}, err: true, id: loc()},
+		{cmd: "unit", args: []string{"add", "-symbol", "cm", "extra"}, err: true, id: loc()},
+	}
+
+	for _, v := range in {
+		fs := flag.NewFlagSet(v.cmd, flag.ContinueOnError)
+
+		f, err := command(v.cmd, v.args[0], fs, v.args[1:])
+		if err == nil {
+			r := testRegistry()
+			if err = f(&r); err == nil && !v.f(r) {
+				t.Errorf("%s unexpected registry %+v", v.id, r)
+			}
+		}
+
+		if (err != nil) != v.err {
+			t.Errorf("%s unexpected error: %v", v.id, err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r := testRegistry()
+
+	errs := r.merge(registry{
+		Units:       []unit{{Symbol: "mm", Name: "millimetres"}, {Symbol: "cm", Name: "centimetre"}},
+		TypeMethods: []link{{TypeID: "t2", MethodID: "m2"}, {TypeID: "t2", MethodID: "m2"}},
+	})
+
+	if len(errs) != 1 || errs[0].Error() != "link t2 m2 is repeated" {
+		t.Errorf("expected an error for the repeated link got %v", errs)
+	}
+
+	if len(r.Units) != 4 || r.findUnit("mm").Name != "millimetres" || r.findUnit("cm") == nil || r.findUnit("m") == nil {
+		t.Errorf("unexpected units %+v", r.Units)
+	}
+
+	if len(r.TypeMethods) != 4 {
+		t.Errorf("unexpected links %+v", r.TypeMethods)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	var b bytes.Buffer
+
+	if err := write(&b, testRegistry()); err != nil {
+		t.Fatal(err)
+	}
+
+	// numbers are written without quotes.
+	if !strings.Contains(b.String(), "    factor: 1000\n    shift: 0\n") {
+		t.Errorf("unexpected YAML:\n%s", b.String())
+	}
+
+	r, err := read(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := r.validate(); len(errs) > 0 {
+		t.Error(errs)
+	}
+
+	if r.findConversion("m", "mm").Factor != "1000" || len(r.TypeMethods) != 3 {
+		t.Errorf("unexpected registry %+v", r)
+	}
+
+	for _, s := range []string{"version: 2\n", "version: 1\ncolours: []\n", "version: 1\nconversions:\n  - factor: [1]\n"} {
+		if _, err := read(strings.NewReader(s)); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
@@ -284,10 +284,27 @@ var ldrChartClient = {
             }
             //show listbox
             $('#divSites').removeClass("d-none");
+            $('#divSiteSearch').removeClass("d-none");
         }
     },
 
 
+    /***
+	 * search for sites with the selected param by name or id and show them in the search list
+	 * ***/
+    searchSites: function (text) {
+        var url = `${this.baseURL}/site/search?q=${encodeURIComponent(text)}&typeID=${this.selectedParam}`;
+        jQuery.getJSON( url, function (data) {
+            $('#siteSearchList').children().remove();
+            if(data && data.features){
+                for (var i = 0, len = data.features.length; i < len; i++) {
+                    var p = data.features[i].properties;
+                    $('#siteSearchList').append($('<option>').attr('value', p.siteID + " - " + p.name));
+                }
+            }
+        });
+    },
+
     /***
 	 * query observation results for selected param and sites from http
 	 * ***/
@@ -320,6 +337,23 @@ var ldrChartClient = {
         }
         );
 
+        $('#siteSearch').on('input', function () {
+            var text = $(this).val().trim();
+            //a site picked from the search list or typed in full
+            var siteId = text.split(' - ')[0];
+            if($('#selSites option').filter(function () { return this.value === siteId; }).length > 0){
+                $('#selSites').val([siteId]).trigger('change');
+                return;
+            }
+            clearTimeout(ldrChartClient.siteSearchTimer);
+            if(text.length > 1){
+                ldrChartClient.siteSearchTimer = setTimeout(function () {
+                    ldrChartClient.searchSites(text);
+                }, 250);
+            }
+        }
+        );
+
         $('#selSites').change(function () {
             $('#divChart').removeClass("d-none");
             //open site popup            
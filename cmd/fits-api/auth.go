@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/GeoNet/kit/weft"
+)
+
+// writers are the clients allowed to write observations.  Set from WRITE_TOKENS at start up.
+var writers []writer
+
+// writer is a client allowed to write observations.  Only the SHA-256 hash of the token is kept
+// so the tokens themselves are not in the config.
+type writer struct {
+	name string
+	hash []byte
+}
+
+/*
+parseWriters parses writers from s.  s is a comma separated list of name:hash where hash is the
+hex encoded SHA-256 hash of the token for name e.g.,
+
+	printf '%s' token | sha256sum
+
+The name is used for logging.
+*/
+func parseWriters(s string) ([]writer, error) {
+	var w []writer
+
+	if strings.TrimSpace(s) == "" {
+		return w, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		name, h, ok := strings.Cut(strings.TrimSpace(v), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected name:hash for a write token got %q", v)
+		}
+
+		hash, err := hex.DecodeString(h)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 hash for write token %s", name)
+		}
+
+		w = append(w, writer{name: name, hash: hash})
+	}
+
+	return w, nil
+}
+
+// authorise returns the name of the writer for the bearer token in the Authorization header for r.
+func authorise(r *http.Request, h http.Header) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		h.Set("WWW-Authenticate", `Bearer realm="fits"`)
+		return "", weft.StatusError{Code: http.StatusUnauthorized, Err: errors.New("a bearer token is required")}
+	}
+
+	sum := sha256.Sum256([]byte(token))
+
+	for _, w := range writers {
+		if subtle.ConstantTimeCompare(sum[:], w.hash) == 1 {
+			return w.name, nil
+		}
+	}
+
+	h.Set("WWW-Authenticate", `Bearer realm="fits", error="invalid_token"`)
+	return "", weft.StatusError{Code: http.StatusUnauthorized, Err: errors.New("invalid bearer token")}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressible are the content types (without parameters) that are compressed.
+var compressible = map[string]bool{
+	"application/vnd.geo+json":      true,
+	"application/json":              true,
+	"application/prs.coverage+json": true,
+	"text/csv":                      true,
+	"text/plain":                    true,
+	"text/html":                     true,
+	"image/svg+xml":                 true,
+}
+
+// acceptEncoding returns the content coding to use for a request with the Accept-Encoding header
+// h.  gzip is preferred to deflate.  Returns an empty string for no compression.
+func acceptEncoding(h string) string {
+	var gz, df bool
+
+	for _, e := range strings.Split(h, ",") {
+		c, p, _ := strings.Cut(e, ";")
+
+		// q=0 means not acceptable.
+		if _, v, ok := strings.Cut(p, "="); ok {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && q == 0 {
+				continue
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(c)) {
+		case "gzip":
+			gz = true
+		case "deflate":
+			df = true
+		}
+	}
+
+	switch {
+	case gz:
+		return "gzip"
+	case df:
+		return "deflate"
+	}
+
+	return ""
+}
+
+/*
+responseWriter wraps an http.ResponseWriter to set validators and compress the body.
+The decision is made when the header is written so it applies to streamed responses as well
+as buffered ones.  Only 200 responses are changed.  A response that is already encoded
+(weft gzips buffered responses) is passed through.
+
+Call close after the handler returns to complete the compressed body.
+*/
+type responseWriter struct {
+	http.ResponseWriter
+	v           *version
+	encoding    string
+	w           io.Writer
+	c           io.WriteCloser
+	wroteHeader bool
+}
+
+// newResponseWriter returns a responseWriter for r.  v is the version of the data in the
+// response and can be nil.
+func newResponseWriter(w http.ResponseWriter, r *http.Request, v *version) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		v:              v,
+		encoding:       acceptEncoding(r.Header.Get("Accept-Encoding")),
+		w:              w,
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.wroteHeader = true
+
+	h := w.Header()
+
+	if code == http.StatusOK {
+		if w.v != nil {
+			w.v.setHeaders(h)
+		}
+
+		c := h.Get("Content-Type")
+		if i := strings.Index(c, ";"); i > 0 {
+			c = c[:i]
+		}
+
+		if compressible[strings.TrimSpace(c)] && h.Get("Content-Encoding") == "" {
+			if !strings.Contains(strings.Join(h.Values("Vary"), ","), "Accept-Encoding") {
+				h.Add("Vary", "Accept-Encoding")
+			}
+
+			switch w.encoding {
+			case "gzip":
+				w.c = gzip.NewWriter(w.ResponseWriter)
+			case "deflate":
+				// the level is valid so there is no error.
+				w.c, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+			}
+
+			if w.c != nil {
+				h.Set("Content-Encoding", w.encoding)
+				h.Del("Content-Length")
+				w.w = w.c
+			}
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.w.Write(p)
+}
+
+// Flush sends any compressed data to the client.  It is used when streaming.
+func (w *responseWriter) Flush() {
+	if f, ok := w.c.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close completes the compressed body.  Don't call it for an aborted response as
+// that would make a truncated body look complete.
+func (w *responseWriter) close() error {
+	if w.c != nil {
+		return w.c.Close()
+	}
+
+	return nil
+}
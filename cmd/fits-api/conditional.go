@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// conditionalPaths are the routes that answer conditional GET requests.  The other routes
+// don't read observations or are static.
+var conditionalPaths = map[string]bool{
+	"/spark":               true,
+	"/map/site":            true,
+	"/observation_results": true,
+	"/observation/stats":   true,
+	"/observation/multi":   true,
+	"/observation/trend":   true,
+	"/observation/gaps":    true,
+	"/observation/history": true,
+	"/inventory":           true,
+	"/type":                true,
+	"/method":              true,
+	"/plot":                true,
+	"/observation":         true,
+	"/site":                true,
+	"/site/search":         true,
+	"/sample":              true,
+	"/sample/observation":  true,
+	"/visual_observation":  true,
+}
+
+// version identifies the data for a response.  tag changes whenever the data changes.
+type version struct {
+	modified time.Time
+	tag      string
+}
+
+/*
+dataVersion returns the version of the data for r.  The siteID, sites, and typeID query parameters
+restrict the observations that are considered.  Any change to the sites, registry, samples,
+or visual observations changes the version for all requests.
+
+The versions come from tables maintained by triggers so this is much cheaper than the request.
+*/
+func dataVersion(r *http.Request) (version, error) {
+	q := r.URL.Query()
+
+	var sites, types []string
+
+	if q.Get("siteID") != "" {
+		sites = []string{q.Get("siteID")}
+	}
+
+	// sites can include the optional and ignored network code e.g., NZ.TAUP
+	if q.Get("sites") != "" {
+		for _, s := range strings.Split(q.Get("sites"), ",") {
+			sites = append(sites, s[strings.LastIndex(s, ".")+1:])
+		}
+	}
+
+	if q.Get("typeID") != "" {
+		types = strings.Split(q.Get("typeID"), ",")
+	}
+
+	var v version
+	var tags string
+
+	err := db.QueryRow(`SELECT greatest(m.modified, o.modified), m.modified::text || o.tags
+		FROM fits.modified AS m,
+		(SELECT max(modified) AS modified, COALESCE(string_agg(modified::text, ',' ORDER BY sitepk, typepk), '') AS tags
+		FROM fits.observation_modified
+		WHERE ($1::text[] IS NULL OR sitepk IN (SELECT sitepk FROM fits.site WHERE siteid = ANY($1)))
+		AND ($2::text[] IS NULL OR typepk IN (SELECT typepk FROM fits.type WHERE typeid = ANY($2)))) AS o`,
+		pq.Array(sites), pq.Array(types)).Scan(&v.modified, &tags)
+	if err != nil {
+		return v, err
+	}
+
+	// the representation depends on Accept as well as the data.  The tag is weak
+	// as the content encoding can vary.
+	h := fnv.New64a()
+	h.Write([]byte(tags))
+	h.Write([]byte(r.Header.Get("Accept")))
+
+	v.tag = fmt.Sprintf(`W/"%x"`, h.Sum64())
+
+	return v, nil
+}
+
+// setHeaders sets the validators for v.
+func (v version) setHeaders(h http.Header) {
+	h.Set("ETag", v.tag)
+	if !v.modified.IsZero() {
+		h.Set("Last-Modified", v.modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified returns true if the client already has the data for v.  If-None-Match
+// is used in preference to If-Modified-Since.
+func (v version) notModified(r *http.Request) bool {
+	if m := r.Header.Get("If-None-Match"); m != "" {
+		for _, t := range strings.Split(m, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(v.tag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if m := r.Header.Get("If-Modified-Since"); m != "" && !v.modified.IsZero() {
+		t, err := http.ParseTime(m)
+		if err != nil {
+			return false
+		}
+		return !v.modified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// relative returns true if the response for the path and query depends on when the request
+// is made as well as the data e.g., the last 7 days of observations.  These responses can't
+// be validated.
+func relative(path string, q url.Values) bool {
+	switch {
+	case q.Get("days") != "" && q.Get("start") == "" && q.Get("end") == "":
+		return true
+	case path == "/observation/gaps":
+		// the window ends now unless end is given.
+		return q.Get("end") == ""
+	case path == "/plot", path == "/spark":
+		// the x axis ends now for a window with no end.
+		return q.Get("end") == "" && (q.Get("start") != "" || q.Get("days") != "")
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/GeoNet/kit/weft"
+)
+
+/*
+conversion converts observation values to another unit as value * factor + shift.
+Errors are scaled by factor.  The factor is always positive so the order of values
+is not changed by a conversion.
+
+The zero value does not convert.
+*/
+type conversion struct {
+	unit          string // the symbol of the unit converted to.
+	factor, shift float64
+}
+
+func (c conversion) enabled() bool {
+	return c.factor != 0
+}
+
+// inverse returns the conversion back to the unit from.
+func (c conversion) inverse(from string) conversion {
+	return conversion{unit: from, factor: 1 / c.factor, shift: -c.shift / c.factor}
+}
+
+// symbol returns the unit for values after the conversion.  unit is the stored unit.
+func (c conversion) symbol(unit string) string {
+	if !c.enabled() {
+		return unit
+	}
+
+	return c.unit
+}
+
+// valueSQL returns SQL for the converted value of the column col.
+func (c conversion) valueSQL(col string) string {
+	if !c.enabled() {
+		return col
+	}
+
+	return `(` + col + ` * ` + numeric(c.factor) + ` + ` + numeric(c.shift) + `)`
+}
+
+// errorSQL returns SQL for the converted error of the column col.
+func (c conversion) errorSQL(col string) string {
+	if !c.enabled() {
+		return col
+	}
+
+	return `(` + col + ` * ` + numeric(c.factor) + `)`
+}
+
+// numeric formats f as an SQL numeric literal.
+func numeric(f float64) string {
+	return `(` + strconv.FormatFloat(f, 'f', -1, 64) + `)::numeric`
+}
+
+// parseUnit returns the conversion from the stored unit for a type to the optional unit
+// query parameter.  There is no conversion if unit is not set or is the stored unit.
+func parseUnit(q url.Values, stored string) (conversion, error) {
+	u := q.Get("unit")
+
+	if u == "" || u == stored {
+		return conversion{}, nil
+	}
+
+	m, err := meta.get()
+	if err != nil {
+		return conversion{}, err
+	}
+
+	if _, ok := m.units[u]; !ok {
+		return conversion{}, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("unknown unit: " + u)}
+	}
+
+	c, ok := m.conversions[[2]string{stored, u}]
+	if !ok {
+		return conversion{}, weft.StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("can't convert %s to %s", stored, u)}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/GeoNet/fits/internal/transform"
+)
+
+// CoverageJSON point series documents.  Only the parts of the spec
+// needed for a single site time series are implemented.
+// See https://covjson.org/spec/
+
+type coverage struct {
+	Type       string                  `json:"type"`
+	Domain     covDomain               `json:"domain"`
+	Parameters map[string]covParameter `json:"parameters"`
+	Ranges     map[string]covNdArray   `json:"ranges"`
+}
+
+type covDomain struct {
+	Type        string             `json:"type"`
+	DomainType  string             `json:"domainType"`
+	Axes        map[string]covAxis `json:"axes"`
+	Referencing []covReferencing   `json:"referencing"`
+}
+
+type covAxis struct {
+	Values []interface{} `json:"values"`
+}
+
+type covReferencing struct {
+	Coordinates []string  `json:"coordinates"`
+	System      covSystem `json:"system"`
+}
+
+type covSystem struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"`
+	Calendar string `json:"calendar,omitempty"`
+}
+
+type covParameter struct {
+	Type             string         `json:"type"`
+	Description      covI18n        `json:"description,omitempty"`
+	Unit             *covUnit       `json:"unit,omitempty"`
+	ObservedProperty covObserved    `json:"observedProperty"`
+	CategoryEncoding map[string]int `json:"categoryEncoding,omitempty"`
+}
+
+type covUnit struct {
+	Symbol string `json:"symbol"`
+}
+
+type covObserved struct {
+	Label       covI18n       `json:"label"`
+	Description covI18n       `json:"description,omitempty"`
+	Categories  []covCategory `json:"categories,omitempty"`
+}
+
+type covCategory struct {
+	ID          string  `json:"id"`
+	Label       covI18n `json:"label"`
+	Description covI18n `json:"description,omitempty"`
+}
+
+type covI18n map[string]string
+
+type covNdArray struct {
+	Type      string        `json:"type"`
+	DataType  string        `json:"dataType"`
+	AxisNames []string      `json:"axisNames"`
+	Shape     []int         `json:"shape"`
+	Values    []interface{} `json:"values"`
+}
+
+type siteLocation struct {
+	longitude, latitude float64
+}
+
+func getSiteLocation(siteID string) (siteLocation, error) {
+	s, err := getSite(siteID)
+	return s.siteLocation, err
+}
+
+// observationJSON streams the observations to w as a JSON array of values.
+// If outlier detection is enabled each value also has an Outlier flag.
+func observationJSON(f obsFilter, rs resample, tr transform.Transform, o outliers, w http.ResponseWriter) (int64, error) {
+	if err := validSite(f.siteID); err != nil {
+		return 0, err
+	}
+
+	if tr.Enabled() || o.enabled() {
+		return observationValuesJSON(f, rs, tr, o, w)
+	}
+
+	rows, err := queryObs(f, rs)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	st := newStream(w)
+
+	st.WriteString("[")
+	for i := 0; rows.Next(); i++ {
+		var v value
+
+		err = v.scan(rows)
+		if err != nil {
+			return st.Fail(err)
+		}
+
+		by, err := json.Marshal(v)
+		if err != nil {
+			return st.Fail(err)
+		}
+
+		if i > 0 {
+			st.WriteString(",")
+		}
+		st.Write(by)
+	}
+	if err = rows.Err(); err != nil {
+		return st.Fail(err)
+	}
+	st.WriteString("]")
+
+	return st.Close()
+}
+
+// observationValuesJSON writes the observations to w as a JSON array of values after applying
+// the transform and outlier detection.  These need all the values so they are read before any are written.
+func observationValuesJSON(f obsFilter, rs resample, tr transform.Transform, o outliers, w http.ResponseWriter) (int64, error) {
+	values, err := loadObs(f, rs)
+	if err != nil {
+		return 0, err
+	}
+
+	values, err = transformValues(values, tr)
+	if err != nil {
+		return 0, err
+	}
+
+	var flags []bool
+	if o.enabled() {
+		flags, err = o.flagValues(values)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	st := newStream(w)
+
+	st.WriteString("[")
+	for i := range values {
+		v := struct {
+			value
+			Outlier *bool `json:",omitempty"`
+		}{value: values[i]}
+
+		if flags != nil {
+			v.Outlier = &flags[i]
+		}
+
+		by, err := json.Marshal(v)
+		if err != nil {
+			return st.Fail(err)
+		}
+
+		if i > 0 {
+			st.WriteString(",")
+		}
+		st.Write(by)
+	}
+	st.WriteString("]")
+
+	return st.Close()
+}
+
+// observationCoverage writes the observations as a CoverageJSON PointSeries document.
+// The value, error, and method for each observation are separate parameters keyed on
+// typeID, typeID_error, and typeID_method so that they can't collide.
+// The method is a categorical parameter encoded using the methods valid for the type.
+// The method is null for resampled values with observations from more than one method.
+func observationCoverage(f obsFilter, rs resample, b *bytes.Buffer) error {
+	t, err := getType(f.typeID)
+	if err != nil {
+		return err
+	}
+	t.unit = f.unit.symbol(t.unit)
+
+	l, err := getSiteLocation(f.siteID)
+	if err != nil {
+		return err
+	}
+
+	values, err := loadObs(f, rs)
+	if err != nil {
+		return err
+	}
+
+	m, err := meta.get()
+	if err != nil {
+		return err
+	}
+
+	encoding := make(map[string]int)
+	var categories []covCategory
+
+	for _, methodID := range m.typeMethods[f.typeID] {
+		d := m.methods[methodID]
+
+		c := covCategory{
+			ID:          d.methodID,
+			Label:       covI18n{"en": d.name},
+			Description: covI18n{"en": d.description},
+		}
+
+		encoding[c.ID] = len(categories)
+		categories = append(categories, c)
+	}
+
+	times := make([]interface{}, len(values))
+	vals := make([]interface{}, len(values))
+	errs := make([]interface{}, len(values))
+	methods := make([]interface{}, len(values))
+
+	for i, v := range values {
+		times[i] = v.T.Format(time.RFC3339Nano)
+		vals[i] = v.V
+		errs[i] = v.E
+		// a resampled value can have observations from more than one method.
+		if m, ok := encoding[v.methodID]; ok {
+			methods[i] = m
+		}
+	}
+
+	ndArray := func(dataType string, values []interface{}) covNdArray {
+		return covNdArray{
+			Type:      "NdArray",
+			DataType:  dataType,
+			AxisNames: []string{"t"},
+			Shape:     []int{len(values)},
+			Values:    values,
+		}
+	}
+
+	c := coverage{
+		Type: "Coverage",
+		Domain: covDomain{
+			Type:       "Domain",
+			DomainType: "PointSeries",
+			Axes: map[string]covAxis{
+				"x": {Values: []interface{}{l.longitude}},
+				"y": {Values: []interface{}{l.latitude}},
+				"t": {Values: times},
+			},
+			Referencing: []covReferencing{
+				{
+					Coordinates: []string{"x", "y"},
+					System:      covSystem{Type: "GeographicCRS", ID: "http://www.opengis.net/def/crs/OGC/1.3/CRS84"},
+				},
+				{
+					Coordinates: []string{"t"},
+					System:      covSystem{Type: "TemporalRS", Calendar: "Gregorian"},
+				},
+			},
+		},
+		Parameters: map[string]covParameter{
+			f.typeID: {
+				Type:             "Parameter",
+				Description:      covI18n{"en": t.description},
+				Unit:             &covUnit{Symbol: t.unit},
+				ObservedProperty: covObserved{Label: covI18n{"en": t.name}},
+			},
+			f.typeID + "_error": {
+				Type:             "Parameter",
+				Description:      covI18n{"en": "The observation error. 0 is used for an unknown error."},
+				Unit:             &covUnit{Symbol: t.unit},
+				ObservedProperty: covObserved{Label: covI18n{"en": t.name + " error"}},
+			},
+			f.typeID + "_method": {
+				Type:             "Parameter",
+				ObservedProperty: covObserved{Label: covI18n{"en": "Method"}, Categories: categories},
+				CategoryEncoding: encoding,
+			},
+		},
+		Ranges: map[string]covNdArray{
+			f.typeID:             ndArray("float", vals),
+			f.typeID + "_error":  ndArray("float", errs),
+			f.typeID + "_method": ndArray("integer", methods),
+		},
+	}
+
+	by, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	b.Write(by)
+
+	return nil
+}
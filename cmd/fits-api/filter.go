@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+	"github.com/lib/pq"
+)
+
+// obsFilter composes the optional constraints for queries on fits.observation.
+// Zero values are not used to restrict the query.
+type obsFilter struct {
+	siteID, typeID, methodID string
+	systemID, sampleID       string     // the external system and the sample in it.
+	start, end               time.Time  // the query window, inclusive.
+	within                   string     // a WKT polygon (EPSG:4326) that the site must be within.
+	near                     near       // the sites near a point.
+	unit                     conversion // converts the values selected to another unit.
+	asOf                     time.Time  // select the observations as they were at asOf from fits.observation_revision.
+	quality                  []string   // select observations with any of these qualities.
+}
+
+// where returns an SQL WHERE clause for the filter and the arguments for it.
+// Arguments are numbered from $1.  Column names are not qualified so the query
+// can join other tables with USING.
+func (f obsFilter) where() (string, []interface{}) {
+	var c []string
+	var args []interface{}
+
+	arg := func(a interface{}) string {
+		args = append(args, a)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	add := func(clause string, a interface{}) {
+		c = append(c, fmt.Sprintf(clause, arg(a)))
+	}
+
+	if f.siteID != "" {
+		add(`sitepk = (SELECT sitepk FROM fits.site WHERE siteid = %s)`, f.siteID)
+	}
+	if f.typeID != "" {
+		add(`typepk = (SELECT typepk FROM fits.type WHERE typeid = %s)`, f.typeID)
+	}
+	if f.methodID != "" {
+		add(`methodpk = (SELECT methodpk FROM fits.method WHERE methodid = %s)`, f.methodID)
+	}
+	if f.systemID != "" {
+		add(`samplepk IN (SELECT samplepk FROM fits.sample JOIN fits.system USING (systempk) WHERE systemid = %s)`, f.systemID)
+	}
+	if f.sampleID != "" {
+		add(`samplepk IN (SELECT samplepk FROM fits.sample WHERE sampleid = %s)`, f.sampleID)
+	}
+	if f.within != "" {
+		add(`sitepk IN (SELECT sitepk FROM fits.site WHERE ST_Within(ST_ShiftLongitude(location::geometry), ST_ShiftLongitude(ST_GeomFromText(%s, 4326))))`, f.within)
+	}
+	if f.near.enabled() {
+		// the nearest sites are counted from the sites with observations of the type
+		// that are within the polygon.
+		var t []string
+		if f.typeID != "" {
+			t = append(t, `typepk = (SELECT typepk FROM fits.type WHERE typeid = `+arg(f.typeID)+`)`)
+		}
+		if f.methodID != "" {
+			t = append(t, `methodpk = (SELECT methodpk FROM fits.method WHERE methodid = `+arg(f.methodID)+`)`)
+		}
+		var cond []string
+		if len(t) > 0 {
+			cond = append(cond, `sitepk IN (SELECT DISTINCT sitepk FROM fits.observation WHERE `+strings.Join(t, " AND ")+`)`)
+		}
+		if f.within != "" {
+			cond = append(cond, `ST_Within(ST_ShiftLongitude(location::geometry), ST_ShiftLongitude(ST_GeomFromText(`+arg(f.within)+`, 4326)))`)
+		}
+		c = append(c, `sitepk IN (`+f.near.sites(arg, cond...)+`)`)
+	}
+	if !f.start.IsZero() {
+		add(`time >= %s`, f.start)
+	}
+	if !f.end.IsZero() {
+		add(`time <= %s`, f.end)
+	}
+	if len(f.quality) > 0 {
+		add(`quality = ANY(%s)`, pq.Array(f.quality))
+	}
+
+	if len(c) == 0 {
+		return "", args
+	}
+
+	return " WHERE " + strings.Join(c, " AND ") + " ", args
+}
+
+// revisions returns an SQL table expression, aliased as observation, for the observations selected by f
+// as they were at f.asOf, and the arguments for it.  The quality can change between revisions so it is
+// selected from the latest revisions.
+func (f obsFilter) revisions() (string, []interface{}) {
+	quality := f.quality
+	f.quality = nil
+
+	where, args := f.where()
+
+	arg := func(a interface{}) string {
+		args = append(args, a)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if where == "" {
+		where = " WHERE "
+	} else {
+		where += "AND "
+	}
+
+	where += `modified <= ` + arg(f.asOf)
+
+	latest := ` WHERE value IS NOT NULL`
+	if len(quality) > 0 {
+		latest += ` AND quality = ANY(` + arg(pq.Array(quality)) + `)`
+	}
+
+	return `(SELECT sitepk, typepk, methodpk, samplepk, time, value, error, quality, qualifier, detection_limit FROM
+		(SELECT DISTINCT ON (sitepk, typepk, methodpk, samplepk, time) sitepk, typepk, methodpk, samplepk, time, value, error, quality,
+		qualifier, detection_limit
+		FROM fits.observation_revision` + where + `
+		ORDER BY sitepk, typepk, methodpk, samplepk, time, modified DESC, revisionpk DESC) AS r` + latest + `) AS observation`, args
+}
+
+/*
+parseWindow returns the start and end of the query window from the optional
+days, start, and end query parameters.  Zero times mean the window is open.
+
+	days           - the days before now, not including the start.
+	start          - all data after start.
+	end            - all data before end.
+	start and end  - a fixed window.
+	start and days - days after start.
+	end and days   - days before end.
+
+It is an error to specify days, start, and end together or for start to be after end.
+*/
+func parseWindow(q url.Values) (start, end time.Time, err error) {
+	days, err := valid.ParseDays(q.Get("days"))
+	if err != nil {
+		return
+	}
+
+	start, err = valid.ParseStart(q.Get("start"))
+	if err != nil {
+		return
+	}
+
+	end, err = valid.ParseEnd(q.Get("end"))
+	if err != nil {
+		return
+	}
+
+	d := time.Duration(days) * time.Hour * 24
+
+	switch {
+	case days == 0:
+	case !start.IsZero() && !end.IsZero():
+		err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("specify at most two of days, start, and end")}
+		return
+	case !start.IsZero():
+		end = start.Add(d)
+	case !end.IsZero():
+		start = end.Add(d * -1)
+	default:
+		// the days before now don't include the start.  Observations are stored to the microsecond
+		// so the next microsecond is the first time in the window.
+		start = time.Now().UTC().Add(d*-1 + time.Microsecond)
+	}
+
+	if !start.IsZero() && !end.IsZero() && start.After(end) {
+		err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("start must be before end")}
+	}
+
+	return
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	// days before now doesn't include the start.
+	before := time.Now().UTC().Add(-24 * time.Hour)
+
+	start, end, err := parseWindow(url.Values{"days": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !start.After(before) || !end.IsZero() {
+		t.Errorf("expected the window to start after %s and be open got %s to %s", before, start, end)
+	}
+
+	// a start or end is included in the window.
+	s := time.Date(2000, 1, 6, 12, 0, 0, 0, time.UTC)
+
+	start, end, err = parseWindow(url.Values{"start": {"2000-01-06T12:00:00Z"}, "days": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !start.Equal(s) || !end.Equal(s.Add(48*time.Hour)) {
+		t.Errorf("expected %s to %s got %s to %s", s, s.Add(48*time.Hour), start, end)
+	}
+
+	start, end, err = parseWindow(url.Values{"end": {"2000-01-08T12:00:00Z"}, "days": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !start.Equal(s) || !end.Equal(s.Add(48*time.Hour)) {
+		t.Errorf("expected %s to %s got %s to %s", s, s.Add(48*time.Hour), start, end)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/GeoNet/fits/internal/gaps"
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// gapIntervals are the cadences for the interval query parameter.
+var gapIntervals = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": time.Duration(365.25 / 12 * 24 * float64(time.Hour)),
+	"year":  time.Duration(365.25 * 24 * float64(time.Hour)),
+}
+
+type gapJSON struct {
+	Start, End      time.Time
+	DurationSeconds float64
+}
+
+type gapsJSON struct {
+	Start, End      time.Time
+	CadenceSeconds  float64
+	CadenceInferred bool // true if the cadence was inferred from the observations.
+	Expected        int
+	Observed        int
+	Completeness    float64
+	Gaps            []gapJSON
+}
+
+type siteGapsJSON struct {
+	SiteID            string
+	CadenceSeconds    float64 // zero if the cadence could not be inferred.
+	Expected          int
+	Observed          int
+	Completeness      float64
+	GapCount          int
+	LongestGapSeconds float64
+}
+
+type networkGapsJSON struct {
+	Start, End time.Time
+	Sites      []siteGapsJSON
+}
+
+// observationGaps reports missing observations and the completeness of the observations at a site.
+// The cadence is inferred from the observations unless interval is given.
+func observationGaps(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "start", "end", "methodID", "interval", "quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	h.Set("Content-Type", v1JSON)
+
+	f, cadence, err := parseGaps(q)
+	if err != nil {
+		return err
+	}
+
+	f.siteID = q.Get("siteID")
+
+	err = validSite(f.siteID)
+	if err != nil {
+		return err
+	}
+
+	values, err := loadObs(f, resample{})
+	if err != nil {
+		return err
+	}
+
+	t := make([]time.Time, len(values))
+	for i := range values {
+		t[i] = values[i].T
+	}
+
+	start, end := f.start, f.end
+	if start.IsZero() {
+		if len(t) == 0 {
+			return weft.StatusError{Code: http.StatusNotFound, Err: errors.New("no observations")}
+		}
+		start = t[0]
+	}
+
+	g := gapsJSON{CadenceInferred: cadence == 0}
+
+	if cadence == 0 {
+		cadence, err = gaps.Cadence(t)
+		if err != nil {
+			return weft.StatusError{Code: http.StatusNotFound, Err: err}
+		}
+	}
+
+	rp, err := gaps.Find(t, start, end, cadence)
+	if err != nil {
+		return weft.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	g.Start, g.End = rp.Start, rp.End
+	g.CadenceSeconds = rp.Cadence.Seconds()
+	g.Expected, g.Observed, g.Completeness = rp.Expected, rp.Observed, rp.Completeness
+	g.Gaps = make([]gapJSON, len(rp.Gaps))
+	for i, x := range rp.Gaps {
+		g.Gaps[i] = gapJSON{Start: x.Start, End: x.End, DurationSeconds: x.Duration().Seconds()}
+	}
+
+	by, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	b.Write(by)
+
+	return nil
+}
+
+// observationGapsNetwork reports the completeness of the observations of a type at all
+// sites that have observations of the type, least complete first.  The time window must start.
+func observationGapsNetwork(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"typeID"}, []string{"days", "start", "end", "methodID", "interval", "quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	h.Set("Content-Type", v1JSON)
+
+	f, cadence, err := parseGaps(q)
+	if err != nil {
+		return err
+	}
+
+	if f.start.IsZero() {
+		return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("days or start must be specified")}
+	}
+
+	// all sites with observations of the type, including those with none in the window.
+	all := obsFilter{typeID: f.typeID, methodID: f.methodID, quality: f.quality}
+	where, args := all.where()
+
+	rows, err := db.Query(`SELECT DISTINCT siteid FROM fits.observation JOIN fits.site USING (sitepk)`+where+`ORDER BY siteid`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var sites []string
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return err
+		}
+		sites = append(sites, s)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	where, args = f.where()
+
+	rows, err = db.Query(`SELECT siteid, time FROM fits.observation JOIN fits.site USING (sitepk)`+where+`ORDER BY siteid, time`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	t := make(map[string][]time.Time)
+	for rows.Next() {
+		var s string
+		var x time.Time
+		err = rows.Scan(&s, &x)
+		if err != nil {
+			return err
+		}
+		t[s] = append(t[s], x)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	n := networkGapsJSON{Start: f.start, End: f.end, Sites: make([]siteGapsJSON, 0, len(sites))}
+
+	for _, s := range sites {
+		sg := siteGapsJSON{SiteID: s}
+
+		c := cadence
+		if c == 0 {
+			c, err = gaps.Cadence(t[s])
+			if err == gaps.ErrNoCadence {
+				// not enough observations in the window, this site needs attention.
+				n.Sites = append(n.Sites, sg)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		rp, err := gaps.Find(t[s], f.start, f.end, c)
+		if err != nil {
+			return weft.StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		sg.CadenceSeconds = rp.Cadence.Seconds()
+		sg.Expected, sg.Observed, sg.Completeness = rp.Expected, rp.Observed, rp.Completeness
+		sg.GapCount = len(rp.Gaps)
+		sg.LongestGapSeconds = rp.Longest().Duration().Seconds()
+
+		n.Sites = append(n.Sites, sg)
+	}
+
+	sort.SliceStable(n.Sites, func(i, j int) bool {
+		return n.Sites[i].Completeness < n.Sites[j].Completeness
+	})
+
+	by, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	b.Write(by)
+
+	return nil
+}
+
+// parseGaps returns the filter and the cadence (zero to infer it) for the gaps query parameters.
+// The window ends now unless end is given.
+func parseGaps(q url.Values) (f obsFilter, cadence time.Duration, err error) {
+	f.typeID = q.Get("typeID")
+
+	err = validType(f.typeID)
+	if err != nil {
+		return
+	}
+
+	if q.Get("methodID") != "" {
+		f.methodID = q.Get("methodID")
+		err = validTypeMethod(f.typeID, f.methodID)
+		if err != nil {
+			return
+		}
+	}
+
+	f.start, f.end, err = parseWindow(q)
+	if err != nil {
+		return
+	}
+
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return
+	}
+
+	if f.end.IsZero() {
+		f.end = time.Now().UTC()
+	}
+
+	if !f.start.IsZero() && f.start.After(f.end) {
+		err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("start must be before end")}
+		return
+	}
+
+	if q.Get("interval") != "" {
+		cadence = gapIntervals[q.Get("interval")]
+	}
+
+	return
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// revision is a change to an observation.  The old fields are null when the observation was added and
+// the value, error, quality, and qualifier are null when it was deleted.  The detection limits can also be null.
+type revision struct {
+	DateTime          time.Time
+	MethodID          string
+	SystemID          string
+	SampleID          string
+	Modified          time.Time
+	OldValue          *float64
+	OldError          *float64
+	Value             *float64
+	Error             *float64
+	OldQuality        *string
+	Quality           *string
+	OldQualifier      *string
+	Qualifier         *string
+	OldDetectionLimit *float64
+	DetectionLimit    *float64
+	Submitter         string
+	Reason            string
+}
+
+// observationHistory returns the revisions of the observations for a site and type, optionally
+// for a single time.  Revisions are in the unit for the type and are ordered by time and then
+// when they were made.
+func observationHistory(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"time", "methodID", "systemID", "sampleID"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	typeID := q.Get("typeID")
+
+	t, err := getType(typeID)
+	if err != nil {
+		return err
+	}
+
+	f := obsFilter{
+		siteID: q.Get("siteID"),
+		typeID: typeID,
+	}
+
+	if err = validSite(f.siteID); err != nil {
+		return err
+	}
+
+	f.systemID, f.sampleID, err = parseSample(q)
+	if err != nil {
+		return err
+	}
+
+	if q.Get("methodID") != "" {
+		f.methodID = q.Get("methodID")
+		err = validTypeMethod(typeID, f.methodID)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.start, err = valid.ParseTime(q.Get("time"))
+	if err != nil {
+		return err
+	}
+	f.end = f.start
+
+	where, args := f.where()
+
+	rows, err := db.Query(`SELECT time, methodid, systemid, sampleid, modified, old_value, old_error, value, error, old_quality, quality,
+		old_qualifier, qualifier, old_detection_limit, detection_limit, submitter, reason
+		FROM fits.observation_revision JOIN fits.method USING (methodpk)
+		JOIN fits.sample USING (samplepk) JOIN fits.system USING (systempk)`+where+` ORDER BY time, revisionpk`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	revisions := []revision{}
+
+	for rows.Next() {
+		var v revision
+
+		err = rows.Scan(&v.DateTime, &v.MethodID, &v.SystemID, &v.SampleID, &v.Modified, &v.OldValue, &v.OldError,
+			&v.Value, &v.Error, &v.OldQuality, &v.Quality,
+			&v.OldQualifier, &v.Qualifier, &v.OldDetectionLimit, &v.DetectionLimit, &v.Submitter, &v.Reason)
+		if err != nil {
+			return err
+		}
+
+		revisions = append(revisions, v)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	switch r.Header.Get("Accept") {
+	case v1JSON:
+		h.Set("Content-Type", v1JSON)
+
+		by, err := json.Marshal(revisions)
+		if err != nil {
+			return err
+		}
+
+		b.Write(by)
+
+		return nil
+	}
+
+	h.Set("Content-Type", v1CSV)
+	h.Set("Content-Disposition", `attachment; filename="FITS-`+f.siteID+`-`+typeID+`-history.csv"`)
+
+	b.WriteString("date-time, methodID, systemID, sampleID, modified, old value (" + t.unit + "), old error (" + t.unit +
+		"), value (" + t.unit + "), error (" + t.unit + "), old quality, quality, old qualifier, qualifier, old detection limit (" +
+		t.unit + "), detection limit (" + t.unit + "), submitter, reason\n")
+
+	c := csv.NewWriter(b)
+
+	for _, v := range revisions {
+		err = c.Write([]string{v.DateTime.UTC().Format(time.RFC3339Nano), v.MethodID, v.SystemID, v.SampleID,
+			v.Modified.UTC().Format(time.RFC3339Nano), formatNullable(v.OldValue), formatNullable(v.OldError),
+			formatNullable(v.Value), formatNullable(v.Error), stringNull(v.OldQuality), stringNull(v.Quality),
+			stringNull(v.OldQualifier), stringNull(v.Qualifier), formatNullable(v.OldDetectionLimit),
+			formatNullable(v.DetectionLimit), v.Submitter, v.Reason})
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Flush()
+
+	return c.Error()
+}
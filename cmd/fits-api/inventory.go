@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+	"github.com/lib/pq"
+)
+
+// inventorySQL summarises observations for each site and type.  Complete it with a
+// WHERE clause on fits.observation (see obsFilter) and inventoryGroup.
+const inventorySQL = `SELECT siteid AS "siteID", typeid AS "typeID", symbol AS unit,
+	array_agg(DISTINCT methodid ORDER BY methodid) AS methods,
+	to_char(min(time), 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') AS first,
+	to_char(max(time), 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') AS last,
+	count(*) AS count
+	FROM fits.observation JOIN fits.site USING (sitepk) JOIN fits.type USING (typepk)
+	JOIN fits.unit USING (unitpk) JOIN fits.method USING (methodpk)`
+
+const inventoryGroup = ` GROUP BY siteid, typeid, symbol ORDER BY siteid, typeid`
+
+// inventoryItem summarises the observations of a type at a site.
+type inventoryItem struct {
+	SiteID  string   `json:"siteID"`
+	TypeID  string   `json:"typeID"`
+	Unit    string   `json:"unit"`
+	Methods []string `json:"methods"`
+	First   string   `json:"first"`
+	Last    string   `json:"last"`
+	Count   int      `json:"count"`
+}
+
+// inventory returns the types, methods, time range, and number of observations for
+// each site and type combination in fits.observation.  Observations can be selected by quality.
+func inventory(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{}, []string{"siteID", "typeID", "methodID", "quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	f := obsFilter{siteID: q.Get("siteID"), typeID: q.Get("typeID"), methodID: q.Get("methodID")}
+
+	if f.methodID != "" && f.typeID == "" {
+		return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("typeID must be specified when methodID is specified")}
+	}
+
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return err
+	}
+
+	if f.siteID != "" {
+		err = validSite(f.siteID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.typeID != "" {
+		err = validType(f.typeID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if f.methodID != "" {
+		err = validTypeMethod(f.typeID, f.methodID)
+		if err != nil {
+			return err
+		}
+	}
+
+	items, err := loadInventory(f)
+	if err != nil {
+		return err
+	}
+
+	switch r.Header.Get("Accept") {
+	case v1JSON:
+		h.Set("Content-Type", v1JSON)
+
+		by, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+
+		b.Write(by)
+	default:
+		h.Set("Content-Type", v1CSV)
+		h.Set("Content-Disposition", `attachment; filename="FITS-inventory.csv"`)
+
+		b.WriteString("siteID, typeID, unit, methods, first, last, count")
+		b.Write(eol)
+
+		for _, i := range items {
+			b.WriteString(i.SiteID + "," + i.TypeID + "," + i.Unit + "," + strings.Join(i.Methods, " ") + "," +
+				i.First + "," + i.Last + "," + strconv.Itoa(i.Count))
+			b.Write(eol)
+		}
+	}
+
+	return nil
+}
+
+// loadInventory returns the inventory for the observations selected by f.
+// Returns a zero length list if there are no observations.
+func loadInventory(f obsFilter) ([]inventoryItem, error) {
+	where, args := f.where()
+
+	rows, err := db.Query(inventorySQL+where+inventoryGroup, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]inventoryItem, 0)
+
+	for rows.Next() {
+		var i inventoryItem
+
+		err = rows.Scan(&i.SiteID, &i.TypeID, &i.Unit, pq.Array(&i.Methods), &i.First, &i.Last, &i.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, i)
+	}
+
+	return items, rows.Err()
+}
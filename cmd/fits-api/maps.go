@@ -69,7 +69,7 @@ func siteMap(r *http.Request, h http.Header, b *bytes.Buffer) error {
 			return err
 		}
 
-		g, err := geoJSONSite(site.siteID)
+		g, err := geoJSONSite(site.siteID, false, nil)
 		if err != nil {
 			return err
 		}
@@ -154,7 +154,7 @@ func siteTypeMap(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		}
 	}
 
-	g, err := geoJSONSites(typeID, methodID, within)
+	g, err := geoJSONSites(typeID, methodID, within, near{}, false, nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/GeoNet/fits/internal/ingest"
+	"github.com/lib/pq"
+)
+
+// metadataTTL is how long the metadata is cached for.  Changes are usually seen sooner
+// via notifications on metadataChannel.
+const metadataTTL = 5 * time.Minute
+
+// metadataChannel is notified by triggers when the sites, types, methods, units, unit conversions, or systems change.
+const metadataChannel = "fits_metadata"
+
+// meta caches the sites, types, methods, and units.  These are needed to validate
+// most requests and change rarely.
+var meta = &metadataCache{ttl: metadataTTL, load: loadMetadata}
+
+type methodQ struct {
+	methodID, name, description string
+}
+
+// metadata is a snapshot of the site, type, method, and unit tables.  Don't modify it.
+type metadata struct {
+	sites   map[string]siteQ
+	types   map[string]typeQ
+	methods map[string]methodQ
+	// typeMethods are the methodIDs for each typeID sorted by methodID.
+	typeMethods map[string][]string
+	// units are the unit names keyed by symbol.
+	units map[string]string
+	// conversions are keyed by the from and to unit symbols.
+	conversions map[[2]string]conversion
+	// deprecated is keyed by the rows from ingest.DeprecatedQuery.
+	deprecated map[string]bool
+	loaded     time.Time
+}
+
+// metadataCache holds metadata until it is older than ttl or invalidated.
+type metadataCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	load func() (*metadata, error)
+	m    *metadata
+}
+
+// get returns the cached metadata, loading it from the DB if it is missing or too old.
+// Concurrent callers wait for a single load.
+func (c *metadataCache) get() (*metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.m != nil && time.Since(c.m.loaded) < c.ttl {
+		return c.m, nil
+	}
+
+	m, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.m = m
+
+	return m, nil
+}
+
+// invalidate drops the cached metadata.  The next get loads it from the DB.
+func (c *metadataCache) invalidate() {
+	c.mu.Lock()
+	c.m = nil
+	c.mu.Unlock()
+}
+
+// refresh loads the metadata from the DB now.  See refreshMetadataOnHUP.
+func (c *metadataCache) refresh() error {
+	c.invalidate()
+	_, err := c.get()
+	return err
+}
+
+func loadMetadata() (*metadata, error) {
+	m := &metadata{
+		sites:       make(map[string]siteQ),
+		types:       make(map[string]typeQ),
+		methods:     make(map[string]methodQ),
+		typeMethods: make(map[string][]string),
+		units:       make(map[string]string),
+		conversions: make(map[[2]string]conversion),
+		deprecated:  make(map[string]bool),
+		loaded:      time.Now(),
+	}
+
+	rows, err := db.Query(`SELECT siteid, name, ST_X(location::geometry), ST_Y(location::geometry) FROM fits.site`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s siteQ
+		err = rows.Scan(&s.siteID, &s.name, &s.longitude, &s.latitude)
+		if err != nil {
+			return nil, err
+		}
+		m.sites[s.siteID] = s
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT typeid, type.name, description, symbol FROM fits.type JOIN fits.unit USING (unitpk)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t typeQ
+		err = rows.Scan(&t.typeID, &t.name, &t.description, &t.unit)
+		if err != nil {
+			return nil, err
+		}
+		m.types[t.typeID] = t
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT methodid, name, description FROM fits.method`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d methodQ
+		err = rows.Scan(&d.methodID, &d.name, &d.description)
+		if err != nil {
+			return nil, err
+		}
+		m.methods[d.methodID] = d
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT typeid, methodid FROM fits.type JOIN fits.type_method USING (typepk)
+		JOIN fits.method USING (methodpk) ORDER BY typeid, methodid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var typeID, methodID string
+		err = rows.Scan(&typeID, &methodID)
+		if err != nil {
+			return nil, err
+		}
+		m.typeMethods[typeID] = append(m.typeMethods[typeID], methodID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT symbol, name FROM fits.unit`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var symbol, name string
+		err = rows.Scan(&symbol, &name)
+		if err != nil {
+			return nil, err
+		}
+		m.units[symbol] = name
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(ingest.DeprecatedQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		m.deprecated[k] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT f.symbol, t.symbol, factor::float8, shift::float8 FROM fits.unit_conversion
+		JOIN fits.unit AS f ON (fromunitpk = f.unitpk) JOIN fits.unit AS t ON (tounitpk = t.unitpk)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inverse []conversion
+	var from []string
+
+	for rows.Next() {
+		var f string
+		var c conversion
+		err = rows.Scan(&f, &c.unit, &c.factor, &c.shift)
+		if err != nil {
+			return nil, err
+		}
+		m.conversions[[2]string{f, c.unit}] = c
+		inverse = append(inverse, c)
+		from = append(from, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// an inverse is only used if the conversion has not been added in both directions.
+	for i, c := range inverse {
+		k := [2]string{c.unit, from[i]}
+		if _, ok := m.conversions[k]; !ok {
+			m.conversions[k] = c.inverse(from[i])
+		}
+	}
+
+	return m, nil
+}
+
+/*
+listenMetadata invalidates meta when there is a notification on metadataChannel.
+The listener reconnects if the connection is lost.  Notifications can be missed while
+it is disconnected so meta is also invalidated when it reconnects.  Run in a go routine.
+*/
+func listenMetadata(dsn string) {
+	l := pq.NewListener(dsn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("metadata listener: %s", err)
+		}
+	})
+
+	if err := l.Listen(metadataChannel); err != nil {
+		log.Printf("metadata listener: %s, metadata will be cached for %s", err, metadataTTL)
+	}
+
+	for {
+		select {
+		case <-l.Notify:
+			// a nil notification means the connection was re-established.
+			meta.invalidate()
+		case <-time.After(90 * time.Second):
+			// check the connection while there are no notifications.
+			go func() {
+				if err := l.Ping(); err != nil {
+					log.Printf("metadata listener: %s", err)
+				}
+			}()
+		}
+	}
+}
+
+// refreshMetadataOnHUP reloads meta from the DB each time the process gets a SIGHUP e.g., after
+// changing the metadata in a DB that can't send notifications.  Run in a go routine.
+func refreshMetadataOnHUP() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		if err := meta.refresh(); err != nil {
+			log.Printf("refreshing metadata: %s", err)
+			continue
+		}
+		log.Print("refreshed metadata")
+	}
+}
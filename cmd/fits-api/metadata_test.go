@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetadataCache(t *testing.T) {
+	var loads int
+	var fail bool
+
+	c := &metadataCache{ttl: time.Hour, load: func() (*metadata, error) {
+		if fail {
+			return nil, errors.New("load failed")
+		}
+		loads++
+		return &metadata{loaded: time.Now()}, nil
+	}}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.get(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("expected 1 load for concurrent gets got %d", loads)
+	}
+
+	c.invalidate()
+
+	if _, err := c.get(); err != nil {
+		t.Error(err)
+	}
+
+	if loads != 2 {
+		t.Errorf("expected a load after invalidate got %d loads", loads)
+	}
+
+	if err := c.refresh(); err != nil {
+		t.Error(err)
+	}
+
+	if loads != 3 {
+		t.Errorf("expected a load for refresh got %d loads", loads)
+	}
+
+	// the metadata is loaded again once it is older than the ttl.
+	c.m.loaded = time.Now().Add(-2 * time.Hour)
+
+	if _, err := c.get(); err != nil {
+		t.Error(err)
+	}
+
+	if loads != 4 {
+		t.Errorf("expected a load after the ttl got %d loads", loads)
+	}
+
+	// a failed load is not cached.
+	c.invalidate()
+	fail = true
+
+	if _, err := c.get(); err == nil {
+		t.Error("expected an error for a failed load")
+	}
+
+	fail = false
+
+	if _, err := c.get(); err != nil {
+		t.Error(err)
+	}
+
+	if loads != 5 {
+		t.Errorf("expected a load after a failed load got %d loads", loads)
+	}
+}
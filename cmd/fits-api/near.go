@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// near selects sites by distance from a point.  The zero value selects all sites.
+type near struct {
+	lon, lat float64
+	radius   float64 // km, zero for no limit on the distance.
+	limit    int     // the nearest limit sites, zero for no limit on the number of sites.
+}
+
+// parseNear returns the search from the optional near, radius, and limit query parameters.
+// radius or limit (or both) must be given with near.
+func parseNear(q url.Values) (n near, err error) {
+	n.radius, err = valid.ParseRadius(q.Get("radius"))
+	if err != nil {
+		return
+	}
+
+	n.limit, err = valid.ParseLimit(q.Get("limit"))
+	if err != nil {
+		return
+	}
+
+	if q.Get("near") == "" {
+		if n.radius != 0 || n.limit != 0 {
+			err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("near must be specified when radius or limit is specified")}
+		}
+		return
+	}
+
+	if n.radius == 0 && n.limit == 0 {
+		err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("radius or limit must be specified when near is specified")}
+		return
+	}
+
+	n.lon, n.lat, err = valid.ParseNear(q.Get("near"))
+
+	return
+}
+
+func (n near) enabled() bool {
+	return n.radius != 0 || n.limit != 0
+}
+
+// point returns SQL for the search point as a geography.  arg adds an argument to the query
+// and returns its placeholder.
+func (n near) point(arg func(interface{}) string) string {
+	return fmt.Sprintf(`ST_SetSRID(ST_MakePoint(%s::float8, %s::float8), 4326)::geography`, arg(n.lon), arg(n.lat))
+}
+
+// distance returns SQL for the distance (km) from the search point to the site location.
+func (n near) distance(arg func(interface{}) string) string {
+	return `ST_Distance(location, ` + n.point(arg) + `) / 1000.0`
+}
+
+/*
+sites returns an SQL query for the sitepk of the sites selected by n.  cond are SQL
+conditions on fits.site that sites must also meet, e.g., having observations of a type,
+so that the nearest sites are counted after they are applied.
+*/
+func (n near) sites(arg func(interface{}) string, cond ...string) string {
+	p := n.point(arg)
+
+	s := `SELECT sitepk FROM fits.site WHERE TRUE`
+
+	for _, c := range cond {
+		s += ` AND ` + c
+	}
+	if n.radius != 0 {
+		s += ` AND ST_DWithin(location, ` + p + `, ` + arg(n.radius*1000.0) + `::float8)`
+	}
+	if n.limit != 0 {
+		s += ` ORDER BY location <-> ` + p + ` LIMIT ` + arg(n.limit)
+	}
+
+	return s
+}
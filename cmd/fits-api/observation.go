@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/GeoNet/fits/internal/stats"
+	"github.com/GeoNet/fits/internal/transform"
 	"github.com/GeoNet/fits/internal/valid"
 	"github.com/GeoNet/kit/weft"
 )
@@ -20,134 +23,193 @@ func init() {
 	eol = []byte("\n")
 }
 
-//nolint:gosec //There doesn't seem to be a good way to get the days in the sql queries as a parameter, days is strongly typed to an integer, should be safe.
-func observation(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"networkID", "days", "methodID"}, valid.Query)
+// observation writes observations for a single site.  CSV and JSON are streamed to the client.
+func observation(r *http.Request, w http.ResponseWriter) (int64, error) {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"networkID", "days", "start", "end", "methodID", "systemID", "sampleID", "interval", "aggregate", "outliers", "threshold", "transform", "epoch", "unit", "asOf", "quality"}, valid.Query)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	h.Set("Content-Type", "text/csv;version=1")
+	tr, err := parseTransform(q)
+	if err != nil {
+		return 0, err
+	}
+
+	o, err := parseOutliers(q)
+	if err != nil {
+		return 0, err
+	}
 
 	typeID := q.Get("typeID")
 
-	err = validType(typeID)
+	t, err := getType(typeID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	siteID := q.Get("siteID")
+	c, err := parseUnit(q, t.unit)
+	if err != nil {
+		return 0, err
+	}
 
-	days, err := valid.ParseDays(q.Get("days"))
+	rs, err := parseResample(q)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	f := obsFilter{
+		siteID: q.Get("siteID"),
+		typeID: typeID,
+		unit:   c,
+	}
+
+	f.start, f.end, err = parseWindow(q)
+	if err != nil {
+		return 0, err
+	}
+
+	f.systemID, f.sampleID, err = parseSample(q)
+	if err != nil {
+		return 0, err
 	}
 
-	var methodID string
+	f.asOf, err = valid.ParseAsOf(q.Get("asOf"))
+	if err != nil {
+		return 0, err
+	}
+
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return 0, err
+	}
 
 	if q.Get("methodID") != "" {
-		methodID = q.Get("methodID")
-		err = validTypeMethod(typeID, methodID)
+		f.methodID = q.Get("methodID")
+		err = validTypeMethod(typeID, f.methodID)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	// Find the unit for the CSV header
-	var unit string
-	if err = db.QueryRow("select symbol FROM fits.type join fits.unit using (unitPK) where typeID = $1",
-		typeID).Scan(&unit); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
+	h := w.Header()
+
+	switch r.Header.Get("Accept") {
+	case v1JSON:
+		h.Set("Content-Type", v1JSON)
+		return observationJSON(f, rs, tr, o, w)
+	case covJSON:
+		h.Set("Content-Type", covJSON)
+		if o.enabled() || tr.Enabled() {
+			return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("outliers and transform are not available for CoverageJSON")}
 		}
-		return err
+		var b bytes.Buffer
+		err = observationCoverage(f, rs, &b)
+		if err != nil {
+			return 0, err
+		}
+		return b.WriteTo(w)
 	}
 
-	var d string
-	var rows *sql.Rows
+	h.Set("Content-Type", v1CSV)
 
-	switch {
-	case days == 0 && methodID == "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s', to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) as csv FROM fits.observation
-                           WHERE 
-                               sitepk = (
-                                              SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-                                            )
-                               AND typepk = (
-                                                        SELECT typepk FROM fits.type WHERE typeid = $2
-                                                       ) 
-                                 ORDER BY time ASC;`, siteID, typeID)
-	case days != 0 && methodID == "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s', to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) as csv FROM fits.observation 
-                           WHERE 
-                               sitepk = (
-                                              SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-                                            )
-                               AND typepk = (
-                                                        SELECT typepk FROM fits.type WHERE typeid = $2
-                                                       ) 
-                                AND time > (now() - interval '`+strconv.Itoa(days)+` days')
-                  		ORDER BY time ASC;`, siteID, typeID)
-	case days == 0 && methodID != "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s', to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) as csv FROM fits.observation 
-                           WHERE 
-                               sitepk = (
-                                              SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-                                            )
-                               AND typepk = (
-                                                         SELECT typepk FROM fits.type WHERE typeid = $2
-                                                       ) 
-			AND methodpk = (
-					SELECT methodpk FROM fits.method WHERE methodid = $3
-				)
-                                 ORDER BY time ASC;`, siteID, typeID, methodID)
-	case days != 0 && methodID != "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s', to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) as csv FROM fits.observation 
-                           WHERE 
-                               sitepk = (
-                                              SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-                                            )
-                               AND typepk = (
-                                                         SELECT typepk FROM fits.type WHERE typeid = $2
-                                                       ) 
-		AND methodpk = (
-					SELECT methodpk FROM fits.method WHERE methodid = $3
-				)
-                                AND time > (now() - interval '`+strconv.Itoa(days)+` days')
-                  		ORDER BY time ASC;`, siteID, typeID, methodID)
+	// the unit for the CSV header
+	unit := c.symbol(t.unit)
+
+	disposition := `attachment; filename="FITS-` + f.siteID + `-` + typeID + `.csv"`
+	if f.methodID != "" {
+		disposition = `attachment; filename="FITS-` + f.siteID + `-` + typeID + `-` + f.methodID + `.csv"`
 	}
+
+	unit = tr.Unit(unit)
+	header := "date-time, " + typeID + " (" + unit + "), error (" + unit + "), systemID, sampleID, quality, qualifier, detection limit (" + unit + ")"
+
+	if tr.Enabled() || o.enabled() {
+		return observationValuesCSV(f, rs, tr, o, header, disposition, w)
+	}
+
+	var d string
+
+	obs, args := rs.query(f)
+
+	rows, err := db.Query(
+		`SELECT format('%s,%s,%s,%s,%s,%s,%s,%s', to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error, systemid, sampleid, quality,
+		qualifier, detection_limit) as csv FROM (`+
+			obs+`) AS o ORDER BY time ASC;`, args...)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer rows.Close()
 
-	b.Write([]byte("date-time, " + typeID + " (" + unit + "), error (" + unit + ")"))
-	b.Write(eol)
+	h.Set("Content-Disposition", disposition)
+
+	st := newStream(w)
+
+	st.WriteString(header)
+	st.Write(eol)
 	for rows.Next() {
 		err := rows.Scan(&d)
 		if err != nil {
-			return err
+			return st.Fail(err)
 		}
-		b.Write([]byte(d))
-		b.Write(eol)
+		st.WriteString(d)
+		st.Write(eol)
 	}
-	rows.Close()
+	if err = rows.Err(); err != nil {
+		return st.Fail(err)
+	}
+
+	return st.Close()
+}
 
-	if methodID != "" {
-		h.Set("Content-Disposition", `attachment; filename="FITS-`+siteID+`-`+typeID+`-`+methodID+`.csv"`)
-	} else {
-		h.Set("Content-Disposition", `attachment; filename="FITS-`+siteID+`-`+typeID+`.csv"`)
+// observationValuesCSV writes the observations as CSV after applying the transform and
+// outlier detection.  These need all the values so they are read before any are written.
+func observationValuesCSV(f obsFilter, rs resample, tr transform.Transform, o outliers, header, disposition string, w http.ResponseWriter) (int64, error) {
+	values, err := loadObs(f, rs)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	values, err = transformValues(values, tr)
+	if err != nil {
+		return 0, err
+	}
+
+	var flags []bool
+	if o.enabled() {
+		flags, err = o.flagValues(values)
+		if err != nil {
+			return 0, err
+		}
+		header += ", outlier"
+	}
+
+	w.Header().Set("Content-Disposition", disposition)
+
+	st := newStream(w)
+
+	st.WriteString(header)
+	st.Write(eol)
+	for i, v := range values {
+		st.WriteString(v.T.UTC().Format("2006-01-02T15:04:05.000Z") + "," + strconv.FormatFloat(v.V, 'f', -1, 64) + "," +
+			strconv.FormatFloat(v.E, 'f', -1, 64) + "," + v.systemID + "," + v.sampleID + "," + v.Q + "," + v.Qual + "," + formatNullable(v.DL))
+		if flags != nil {
+			st.WriteString("," + strconv.FormatBool(flags[i]))
+		}
+		st.Write(eol)
+	}
+
+	return st.Close()
 }
 
+// observationStats returns statistics for the observations at a site.  All the
+// statistics are for the observations in the same time window.
 func observationStats(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"networkID", "days", "methodID"}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"networkID", "days", "start", "end", "methodID", "percentiles", "unit", "quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	percentiles, err := valid.ParsePercentiles(q.Get("percentiles"))
 	if err != nil {
 		return err
 	}
@@ -156,65 +218,99 @@ func observationStats(r *http.Request, h http.Header, b *bytes.Buffer) error {
 
 	typeID := q.Get("typeID")
 
-	err = validType(typeID)
+	t, err := getType(typeID)
+	if err != nil {
+		return err
+	}
+
+	c, err := parseUnit(q, t.unit)
 	if err != nil {
 		return err
 	}
 
-	var days int
-	var tmin, tmax time.Time
+	f := obsFilter{
+		siteID: q.Get("siteID"),
+		typeID: typeID,
+		unit:   c,
+	}
 
-	if q.Get("days") != "" {
-		days, err = valid.ParseDays(q.Get("days"))
-		if err != nil {
-			return err
-		}
-		tmax = time.Now().UTC()
-		tmin = tmax.Add(time.Duration(days*-1) * time.Hour * 24)
+	f.start, f.end, err = parseWindow(q)
+	if err != nil {
+		return err
 	}
 
-	var methodID string
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return err
+	}
 
 	if q.Get("methodID") != "" {
-		methodID = q.Get("methodID")
-		err = validTypeMethod(typeID, methodID)
+		f.methodID = q.Get("methodID")
+		err = validTypeMethod(typeID, f.methodID)
 		if err != nil {
 			return err
 		}
 	}
 
-	var unit string
-	if err := db.QueryRow("select symbol FROM fits.type join fits.unit using (unitPK) where typeID = $1",
-		typeID).Scan(&unit); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
-		}
-		return err
-	}
+	unit := c.symbol(t.unit)
 
-	siteID := q.Get("siteID")
-
-	values, err := loadObs(siteID, typeID, methodID, tmin)
+	values, err := loadObs(f, resample{})
 	if err != nil {
 		return err
 	}
 
-	mean, stdDev, err := stddevPop(siteID, typeID, methodID, tmin)
-	if err != nil {
-		return err
+	if len(values) == 0 {
+		return weft.StatusError{Code: http.StatusNotFound, Err: errors.New("no observations")}
 	}
-	stats := obstats{Unit: unit,
-		Mean:             mean,
-		StddevPopulation: stdDev}
 
-	stats.First = values[0]
-	stats.Last = values[len(values)-1]
+	// values below the detection limit or above the range are censored and are not used for the statistics.
+	values, censored := uncensored(values)
+
+	if len(values) == 0 {
+		return weft.StatusError{Code: http.StatusNotFound, Err: errors.New("all the observations are censored")}
+	}
+
+	s := obstats{Unit: unit,
+		Count:    len(values),
+		Censored: censored}
+
+	s.First = values[0]
+	s.Last = values[len(values)-1]
 
 	iMin, iMax, _ := extremes(values)
-	stats.Minimum = values[iMin]
-	stats.Maximum = values[iMax]
+	s.Minimum = values[iMin]
+	s.Maximum = values[iMax]
+
+	v := make([]float64, len(values))
+	e := make([]float64, len(values))
+	for i := range values {
+		v[i] = values[i].V
+		e[i] = values[i].E
+	}
+
+	s.Mean, s.StddevPopulation = stats.MeanStddevPop(v)
+
+	sorted := stats.Sorted(v)
+	s.Median = stats.Median(sorted)
+
+	if len(percentiles) > 0 {
+		s.Percentiles = make(map[string]float64)
+		for _, p := range percentiles {
+			s.Percentiles[strconv.FormatFloat(p, 'f', -1, 64)] = stats.Percentile(sorted, p)
+		}
+	}
+
+	s.SpanSeconds = s.Last.T.Sub(s.First.T).Seconds()
+	if s.Count > 1 {
+		s.MeanIntervalSeconds = s.SpanSeconds / float64(s.Count-1)
+	}
+
+	if m, me, ok := stats.WeightedMean(v, e); ok {
+		s.WeightedMean = &m
+		s.WeightedMeanError = &me
+	}
 
-	by, err := json.Marshal(stats)
+	by, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
@@ -226,17 +322,27 @@ func observationStats(r *http.Request, h http.Header, b *bytes.Buffer) error {
 
 /**
  * query end point for observation results for charts
- * for single site, return the actual observation results
- * for multiple sites, return the daily average values
+ * for single site, return the actual observation results or the resampled results if interval is set
+ * for multiple sites, return the resampled values, the default is the daily mean
  */
 func observationResults(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"interval", "aggregate", "quality"}, valid.Query)
 	if err != nil {
 		return err
 	}
 
 	h.Set("Content-Type", "application/json;version=1")
 
+	rs, err := parseResample(q)
+	if err != nil {
+		return err
+	}
+
+	quality, err := valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return err
+	}
+
 	typeID := q.Get("typeID")
 	siteID := q.Get("siteID")
 	siteIDs := strings.Split(siteID, ",")
@@ -250,6 +356,11 @@ func observationResults(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	}
 	queryWhereClause += ")"
 
+	// the qualities have been validated so are safe to use in the query.
+	if len(quality) > 0 {
+		queryWhereClause += " and obs.quality in ('" + strings.Join(quality, "','") + "')"
+	}
+
 	b.WriteString("{\"param\":\"" + typeID + "\",")
 	b.WriteString("\"sites\":[")
 	for index, siteId := range siteIDs {
@@ -266,10 +377,18 @@ func observationResults(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	if len(siteIDs) == 1 {
 		//single site
 		//4.1 query results values
-		rows, err := db.Query(
-			`select  to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as date, site.siteid, value, error, site.name as sitename from fits.observation obs
+		var rows *sql.Rows
+		if rs.enabled() {
+			obs, args := rs.query(obsFilter{siteID: siteID, typeID: typeID, quality: quality})
+			rows, err = db.Query(
+				`select to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as date, site.siteid, value, error, site.name as sitename from (`+obs+`) obs
+       cross join fits.site site where site.siteid = $`+strconv.Itoa(len(args)+1)+` order by date;`, append(args, siteID)...)
+		} else {
+			rows, err = db.Query(
+				`select  to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as date, site.siteid, value, error, site.name as sitename from fits.observation obs
        left outer join fits.type type on obs.typepk = type.typepk
        left outer join fits.site site on obs.sitepk = site.sitepk ` + queryWhereClause + ` order by date;`)
+		}
 		if err != nil {
 			return err
 		}
@@ -304,10 +423,14 @@ func observationResults(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		rows.Close()
 
 	} else if len(siteIDs) > 1 {
-		//multiple site, aggregate results on daily average
+		//multiple site, aggregate results, the default is the daily average
+		if !rs.enabled() {
+			rs.interval = "day"
+		}
+
 		//4.1. Find dates
 		rows, err := db.Query(
-			`select  distinct to_char(time, 'YYYY-MM-DD') as date from fits.observation obs
+			`select  distinct to_char(` + rs.timeSQL() + `, 'YYYY-MM-DD') as date from fits.observation obs
      left outer join fits.type type on obs.typepk = type.typepk
      left outer join fits.site site on obs.sitepk = site.sitepk ` + queryWhereClause + ` order by date;`)
 
@@ -333,7 +456,7 @@ func observationResults(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		//4.2. query results
 		rows, err = db.Query(
 			`select agt.*, site1.name as sitename from (
-       select  to_char(time, 'YYYY-MM-DD') as date, site.siteid, avg(value) as value, avg(error) as error  from fits.observation obs
+       select  to_char(` + rs.timeSQL() + `, 'YYYY-MM-DD') as date, site.siteid, ` + rs.valueSQL() + ` as value, ` + rs.errorSQL() + ` as error  from fits.observation obs
        left outer join fits.type type on obs.typepk = type.typepk
        left outer join fits.site site on obs.sitepk = site.sitepk ` + queryWhereClause + ` group by date, siteid) agt
        left outer join fits.site site1 on agt.siteid = site1.siteid
@@ -407,136 +530,51 @@ func observationResults(r *http.Request, h http.Header, b *bytes.Buffer) error {
 }
 
 type obstats struct {
-	Maximum          value
-	Minimum          value
-	First            value
-	Last             value
-	Mean             float64
-	StddevPopulation float64
-	Unit             string
+	Maximum             value
+	Minimum             value
+	First               value
+	Last                value
+	Mean                float64
+	StddevPopulation    float64
+	Unit                string
+	Count               int
+	Censored            int // the number of censored observations left out of the statistics.
+	Median              float64
+	Percentiles         map[string]float64 `json:",omitempty"`
+	SpanSeconds         float64            // the time from the first to the last observation.
+	MeanIntervalSeconds float64            // the mean time between observations.
+	WeightedMean        *float64           `json:",omitempty"` // weighted by 1/error^2.  Only observations with an error are used.
+	WeightedMeanError   *float64           `json:",omitempty"`
 }
 
 /*
-stddevPop finds the mean and population stddev for the siteID, and typeID query.
-The start of data range can be restricted using the start parameter.  To query all data pass
-a zero value uninitialized Time.
+stddevPop finds the mean and population stddev for the observations selected by f.
+Censored observations are not used.
 */
-func stddevPop(siteID, typeID string, methodID string, start time.Time) (m, d float64, err error) {
-	tZero := time.Time{}
-
-	switch {
-	case start == tZero && methodID == "":
-		err = db.QueryRow(
-			`SELECT avg(value), stddev_pop(value) FROM fits.observation
-         WHERE
-         sitepk = (SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1)
-         AND typepk = ( SELECT typepk FROM fits.type WHERE typeid = $2 )`,
-			siteID, typeID).Scan(&m, &d)
-
-	case start != tZero && methodID == "":
-		err = db.QueryRow(
-			`SELECT avg(value), stddev_pop(value) FROM fits.observation
-          WHERE
-          sitepk = (SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1)
-	      AND typepk = (SELECT typepk FROM fits.type WHERE typeid = $2 )
-	      AND time > $3`,
-			siteID, typeID, start).Scan(&m, &d)
-
-	case start == tZero && methodID != "":
-		err = db.QueryRow(
-			`SELECT avg(value), stddev_pop(value) FROM fits.observation
-         WHERE
-         sitepk = (SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1)
-	      AND typepk = ( SELECT typepk FROM fits.type WHERE typeid = $2)
-	      AND methodpk = (SELECT methodpk FROM fits.method WHERE methodid = $3 )`,
-			siteID, typeID, methodID).Scan(&m, &d)
-
-	case start != tZero && methodID != "":
-		err = db.QueryRow(
-			`SELECT avg(value), stddev_pop(value) FROM fits.observation
-         WHERE
-         sitepk = ( SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1)
-         AND typepk = ( SELECT typepk FROM fits.type WHERE typeid = $2 )
-         AND methodpk = ( SELECT methodpk FROM fits.method WHERE methodid = $4 )
-         AND time > $3`,
-			siteID, typeID, start, methodID).Scan(&m, &d)
-	}
+func stddevPop(f obsFilter) (m, d float64, err error) {
+	where, args := f.where()
+
+	v := f.unit.valueSQL(`value`)
+	measured := ` FILTER (WHERE qualifier NOT IN ('<', '>'))`
+
+	err = db.QueryRow(`SELECT COALESCE(avg(`+v+`)`+measured+`, 0), COALESCE(stddev_pop(`+v+`)`+measured+`, 0) FROM fits.observation`+where, args...).Scan(&m, &d)
 
 	return
 }
 
 /*
-loadObs returns observation values for the siteID, and typeID query.
-The start of data range can be restricted using the start parameter.  To query all data pass
-a zero value uninitialized Time.
-Passing a non zero methodID will further restrict the result set.
+loadObs returns observation values selected by f and resampled using rs.
 []values is ordered so the latest value will always be values[len(values) -1]
 */
-func loadObs(siteID, typeID, methodID string, start time.Time) (values []value, err error) {
-	var rows *sql.Rows
-	tZero := time.Time{}
-
-	switch {
-	case start == tZero && methodID == "":
-		rows, err = db.Query(
-			`SELECT time, value, error FROM fits.observation 
-		WHERE 
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	ORDER BY time ASC;`, siteID, typeID)
-	case start != tZero && methodID == "":
-		rows, err = db.Query(
-			`SELECT time, value, error FROM fits.observation 
-		WHERE 
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		) 
-	AND time > $3
-	ORDER BY time ASC;`, siteID, typeID, start)
-	case start == tZero && methodID != "":
-		rows, err = db.Query(
-			`SELECT time, value, error FROM fits.observation 
-		WHERE 
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	AND methodpk = (
-			SELECT methodpk FROM fits.method WHERE methodid = $3
-			)	
-	ORDER BY time ASC;`, siteID, typeID, methodID)
-	case start != tZero && methodID != "":
-		rows, err = db.Query(
-			`SELECT time, value, error FROM fits.observation 
-		WHERE 
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		) 
-	AND methodpk = (
-		SELECT methodpk FROM fits.method WHERE methodid = $4
-		)	
-	AND time > $3
-	ORDER BY time ASC;`, siteID, typeID, start, methodID)
-	}
+func loadObs(f obsFilter, rs resample) (values []value, err error) {
+	rows, err := queryObs(f, rs)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
 		v := value{}
-		err = rows.Scan(&v.T, &v.V, &v.E)
+		err = v.scan(rows)
 		if err != nil {
 			return
 		}
@@ -548,6 +586,14 @@ func loadObs(siteID, typeID, methodID string, start time.Time) (values []value,
 	return
 }
 
+// queryObs returns rows for the observations selected by f and resampled using rs ordered by time.
+// Use value.scan to read the rows.
+func queryObs(f obsFilter, rs resample) (*sql.Rows, error) {
+	obs, args := rs.query(f)
+
+	return db.Query(`SELECT time, value, error, methodid, systemid, sampleid, quality, qualifier, detection_limit FROM (`+obs+`) AS o ORDER BY time ASC;`, args...)
+}
+
 /*
 extremes returns the indexes for the min and max values.  hasErrors will be true
 if any of the values have a non zero measurement error.
@@ -574,7 +620,39 @@ func extremes(values []value) (min, max int, hasErrors bool) {
 }
 
 type value struct {
-	T time.Time `json:"DateTime"`
-	V float64   `json:"Value"`
-	E float64   `json:"Error"`
+	T        time.Time `json:"DateTime"`
+	V        float64   `json:"Value"`
+	E        float64   `json:"Error"`
+	Q        string    `json:"Quality,omitempty"`        // empty for a resampled value with observations of more than one quality.
+	Qual     string    `json:"Qualifier,omitempty"`      // <, >, or ~ for a value below the detection limit, above the range, or approximate.
+	DL       *float64  `json:"DetectionLimit,omitempty"` // nil if there is no detection limit.
+	methodID string
+	systemID string
+	sampleID string
+}
+
+// censored returns true if v is below the detection limit or above the range.
+func (v value) censored() bool {
+	return v.Qual == "<" || v.Qual == ">"
+}
+
+// uncensored returns the values that are not censored and the number that are.
+// values is reused for the result.
+func uncensored(values []value) ([]value, int) {
+	var censored int
+	measured := values[:0]
+	for _, v := range values {
+		if v.censored() {
+			censored++
+			continue
+		}
+		measured = append(measured, v)
+	}
+
+	return measured, censored
+}
+
+// scan reads a row from queryObs.
+func (v *value) scan(rows *sql.Rows) error {
+	return rows.Scan(&v.T, &v.V, &v.E, &v.methodID, &v.systemID, &v.sampleID, &v.Q, &v.Qual, &v.DL)
 }
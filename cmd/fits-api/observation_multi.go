@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+type multiObs struct {
+	SiteID       string       `json:"siteID"`
+	Columns      []wideColumn `json:"columns"`
+	Observations []wideRow    `json:"observations"`
+}
+
+// observationMulti returns observations for more than one type at a site joined on time.
+// There is a column for each type in the order requested, or for each type and method if showMethod is true.
+// If interval is set the observations are resampled before they are joined.
+func observationMulti(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "start", "end", "showMethod", "interval", "aggregate", "quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	showMethod, err := valid.ParseShowMethod(q.Get("showMethod"))
+	if err != nil {
+		return err
+	}
+
+	rs, err := parseResample(q)
+	if err != nil {
+		return err
+	}
+
+	m := multiObs{SiteID: q.Get("siteID")}
+
+	err = validSite(m.SiteID)
+	if err != nil {
+		return err
+	}
+
+	var types []typeQ
+	seen := make(map[string]bool)
+
+	for _, typeID := range strings.Split(q.Get("typeID"), ",") {
+		if seen[typeID] {
+			return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("duplicate typeID: " + typeID)}
+		}
+		seen[typeID] = true
+
+		t, err := getType(typeID)
+		if err != nil {
+			return err
+		}
+
+		types = append(types, t)
+	}
+
+	f := obsFilter{siteID: m.SiteID}
+
+	f.start, f.end, err = parseWindow(q)
+	if err != nil {
+		return err
+	}
+
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return err
+	}
+
+	var o []wideObs
+
+	for _, t := range types {
+		f.typeID = t.typeID
+
+		methods := []string{""}
+		if showMethod {
+			methods, err = obsMethods(f)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, methodID := range methods {
+			f.methodID = methodID
+			c := wideColumn{TypeID: t.typeID, MethodID: methodID, Unit: t.unit}
+			m.Columns = append(m.Columns, c)
+
+			values, err := loadObs(f, rs)
+			if err != nil {
+				return err
+			}
+
+			for _, v := range values {
+				o = append(o, wideObs{siteID: m.SiteID, t: v.T, c: c, v: v.V, e: v.E, qual: v.Qual, dl: v.DL})
+			}
+		}
+	}
+
+	m.Observations = wideRows(o, m.Columns)
+
+	// the site is the same for every row.
+	for i := range m.Observations {
+		m.Observations[i].SiteID = ""
+	}
+
+	switch r.Header.Get("Accept") {
+	case v1JSON:
+		h.Set("Content-Type", v1JSON)
+
+		by, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		b.Write(by)
+	default:
+		h.Set("Content-Type", v1CSV)
+		h.Set("Content-Disposition", `attachment; filename="FITS-`+m.SiteID+`-`+strings.ReplaceAll(q.Get("typeID"), ",", "-")+`.csv"`)
+
+		writeWideCSV(b, m.Columns, m.Observations, false)
+	}
+
+	return nil
+}
+
+// obsMethods returns the methodIDs for the observations selected by f.
+func obsMethods(f obsFilter) ([]string, error) {
+	where, args := f.where()
+
+	rows, err := db.Query(`SELECT DISTINCT methodid FROM fits.observation JOIN fits.method USING (methodpk)`+where+
+		`ORDER BY methodid ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var methods []string
+
+	for rows.Next() {
+		var m string
+
+		err = rows.Scan(&m)
+		if err != nil {
+			return nil, err
+		}
+
+		methods = append(methods, m)
+	}
+
+	return methods, rows.Err()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/GeoNet/fits/internal/ingest"
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// maxPostBytes limits the size of a batch of observations.
+const maxPostBytes = 10 << 20
+
+type postResult struct {
+	Line   int
+	Result string // accepted, updated, unchanged, or rejected.
+	Error  string `json:",omitempty"`
+}
+
+type postReport struct {
+	Accepted, Updated, Unchanged, Rejected int
+	Lines                                  []postResult
+}
+
+/*
+observationPost adds or updates a batch of observations sent as CSV or JSON.  The request must
+have a bearer token for one of the writers.  Rows are checked against the sites, types,
+methods, and samples, and rows that use deprecated metadata are rejected.  The rows that are
+valid are added with fits.add_observation in one transaction and the rest are rejected.  Rows
+that are the same as the stored observation are unchanged.  The changes are recorded in the
+revision history with the name of the writer and the optional reason query parameter.  The
+response reports the result for each line.
+*/
+func observationPost(r *http.Request, w http.ResponseWriter) (int64, error) {
+	q, err := weft.CheckQueryValid(r, []string{"POST"}, []string{}, []string{"reason"}, valid.Query)
+	if err != nil {
+		return 0, err
+	}
+
+	if dbw == nil {
+		return 0, weft.StatusError{Code: http.StatusMethodNotAllowed, Err: errors.New("writes are not enabled")}
+	}
+
+	h := w.Header()
+
+	name, err := authorise(r, h)
+	if err != nil {
+		return 0, err
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxPostBytes)
+
+	var rows []ingest.Observation
+
+	switch r.Header.Get("Content-Type") {
+	case v1CSV:
+		rows, err = ingest.ReadObservationsCSV(body)
+	case v1JSON:
+		rows, err = ingest.ReadObservationsJSON(body)
+	default:
+		return 0, weft.StatusError{Code: http.StatusUnsupportedMediaType,
+			Err: fmt.Errorf("Content-Type must be %s or %s", v1CSV, v1JSON)}
+	}
+	if err != nil {
+		var mb *http.MaxBytesError
+		if errors.As(err, &mb) {
+			return 0, weft.StatusError{Code: http.StatusRequestEntityTooLarge, Err: err}
+		}
+		return 0, weft.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if len(rows) == 0 {
+		return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("no observations")}
+	}
+
+	rep, err := addObservations(rows, name, q.Get("reason"))
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("observations from %s: %d accepted, %d updated, %d unchanged, %d rejected", name, rep.Accepted, rep.Updated,
+		rep.Unchanged, rep.Rejected)
+
+	by, err := json.Marshal(rep)
+	if err != nil {
+		return 0, err
+	}
+
+	h.Set("Content-Type", v1JSON)
+	h.Set("Cache-Control", "no-store")
+
+	return bytes.NewBuffer(by).WriteTo(w)
+}
+
+// check validates o against the metadata m and returns the conversion for the unit of o to the
+// unit for the type.  Samples are checked when o is added.
+func check(o *ingest.Observation, m *metadata) (conversion, error) {
+	if err := o.Check(); err != nil {
+		return conversion{}, err
+	}
+
+	if _, ok := m.sites[o.SiteID]; !ok {
+		return conversion{}, fmt.Errorf("unknown siteID: %q", o.SiteID)
+	}
+
+	t, ok := m.types[o.TypeID]
+	if !ok {
+		return conversion{}, fmt.Errorf("unknown typeID: %q", o.TypeID)
+	}
+
+	var found bool
+	for _, v := range m.typeMethods[o.TypeID] {
+		if v == o.MethodID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return conversion{}, fmt.Errorf("invalid methodID %q for typeID %s", o.MethodID, o.TypeID)
+	}
+
+	if err := o.CheckDeprecated(m.deprecated); err != nil {
+		return conversion{}, err
+	}
+
+	if o.Unit == "" || o.Unit == t.unit {
+		return conversion{}, nil
+	}
+
+	c, ok := m.conversions[[2]string{o.Unit, t.unit}]
+	if !ok {
+		return conversion{}, fmt.Errorf("can't convert %s to %s for typeID %s", o.Unit, t.unit, o.TypeID)
+	}
+
+	return c, nil
+}
+
+// addObservations adds or updates the valid rows in a single transaction and reports the result for each row.
+// The changes are recorded in the revision history with the submitter and reason.  An error means none of the
+// rows were added.
+func addObservations(rows []ingest.Observation, submitter, reason string) (postReport, error) {
+	var rep postReport
+
+	m, err := meta.get()
+	if err != nil {
+		return rep, err
+	}
+
+	tx, err := dbw.Begin()
+	if err != nil {
+		return rep, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(ingest.RevisionQuery, submitter, reason)
+	if err != nil {
+		return rep, err
+	}
+
+	sample, err := tx.Prepare(`SELECT EXISTS (SELECT 1 FROM fits.sample JOIN fits.system USING (systempk)
+		WHERE systemid = $1 AND sampleid = $2)`)
+	if err != nil {
+		return rep, err
+	}
+	defer sample.Close()
+
+	// observations in another unit are converted to the unit for the type as value * $9 + $10.
+	// An empty detection limit ($13) is NULL.
+	const converted = `$7::numeric * $9::numeric + $10::numeric, $8::numeric * $9::numeric`
+	const detectionLimit = `NULLIF($13, '')::numeric * $9::numeric + $10::numeric`
+
+	// same is true if the stored observation won't be changed by the row.  An empty quality ($11) keeps
+	// the stored quality.  There are no rows for a new observation.
+	same, err := tx.Prepare(`SELECT (value, error, quality, qualifier, detection_limit) IS NOT DISTINCT FROM
+		(` + converted + `, COALESCE(NULLIF($11, ''), quality), $12, ` + detectionLimit + `)
+		FROM fits.observation JOIN fits.site USING (sitepk)
+		JOIN fits.type USING (typepk) JOIN fits.method USING (methodpk)
+		JOIN fits.sample USING (samplepk) JOIN fits.system USING (systempk)
+		WHERE siteid = $1 AND typeid = $2 AND methodid = $3 AND sampleid = $4 AND systemid = $5 AND time = $6`)
+	if err != nil {
+		return rep, err
+	}
+	defer same.Close()
+
+	add, err := tx.Prepare(`SELECT fits.add_observation($1, $2, $3, $4, $5, $6, ` + converted + `, $11, $12, ` + detectionLimit + `)`)
+	if err != nil {
+		return rep, err
+	}
+	defer add.Close()
+
+	samples := make(map[[2]string]bool)
+
+	for i := range rows {
+		p := &rows[i]
+		res := postResult{Line: p.Line}
+
+		c, err := check(p, m)
+
+		if err == nil {
+			k := [2]string{p.SystemID, p.SampleID}
+			ok, seen := samples[k]
+			if !seen {
+				if err = sample.QueryRow(p.SystemID, p.SampleID).Scan(&ok); err != nil {
+					return rep, err
+				}
+				samples[k] = ok
+			}
+			if !ok {
+				err = fmt.Errorf("unknown sample: systemID %q sampleID %q", p.SystemID, p.SampleID)
+			}
+		}
+
+		if err != nil {
+			res.Result = "rejected"
+			res.Error = err.Error()
+			rep.Rejected++
+			rep.Lines = append(rep.Lines, res)
+			continue
+		}
+
+		if !c.enabled() {
+			c = conversion{factor: 1}
+		}
+
+		args := []interface{}{p.SiteID, p.TypeID, p.MethodID, p.SampleID, p.SystemID, p.Time, p.Value.String(), p.Error.String(),
+			strconv.FormatFloat(c.factor, 'f', -1, 64), strconv.FormatFloat(c.shift, 'f', -1, 64), p.Quality,
+			p.Qualifier, p.DetectionLimit.String()}
+
+		var unchanged bool
+
+		err = same.QueryRow(args...).Scan(&unchanged)
+		switch {
+		case err == sql.ErrNoRows:
+			res.Result = "accepted"
+			rep.Accepted++
+		case err != nil:
+			return rep, err
+		case unchanged:
+			res.Result = "unchanged"
+			rep.Unchanged++
+		default:
+			res.Result = "updated"
+			rep.Updated++
+		}
+
+		if !unchanged {
+			if _, err = add.Exec(args...); err != nil {
+				return rep, err
+			}
+		}
+
+		rep.Lines = append(rep.Lines, res)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return postReport{}, err
+	}
+
+	return rep, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/GeoNet/fits/internal/outlier"
+	"github.com/GeoNet/fits/internal/ts"
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// outliers is the outlier detection for a query.  Detection is not done if method is empty.
+type outliers struct {
+	method    string  // outlier.MAD or outlier.IQR
+	threshold float64 // zero for the default for the method.
+}
+
+// parseOutliers returns the outlier detection from the optional outliers and threshold query parameters.
+func parseOutliers(q url.Values) (outliers, error) {
+	var o outliers
+	var err error
+
+	o.method = q.Get("outliers")
+
+	o.threshold, err = valid.ParseThreshold(q.Get("threshold"))
+	if err != nil {
+		return o, err
+	}
+
+	if o.threshold != 0 && o.method == "" {
+		return o, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("threshold requires outliers")}
+	}
+
+	return o, nil
+}
+
+func (o outliers) enabled() bool {
+	return o.method != ""
+}
+
+// flag returns true for each of v that is an outlier.
+func (o outliers) flag(v []float64) ([]bool, error) {
+	return outlier.Flag(o.method, v, o.threshold)
+}
+
+// flagValues returns true for each of values that is an outlier.
+func (o outliers) flagValues(values []value) ([]bool, error) {
+	v := make([]float64, len(values))
+	for i := range values {
+		v[i] = values[i].V
+	}
+
+	return o.flag(v)
+}
+
+// flagPoints sets Outlier for the points.  Does nothing if detection is not enabled.
+func (o outliers) flagPoints(points []ts.Point) error {
+	if !o.enabled() {
+		return nil
+	}
+
+	v := make([]float64, len(points))
+	for i := range points {
+		v[i] = points[i].Value
+	}
+
+	f, err := o.flag(v)
+	if err != nil {
+		return err
+	}
+
+	for i := range points {
+		points[i].Outlier = f[i]
+	}
+
+	return nil
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/GeoNet/fits/internal/stats"
+	"github.com/GeoNet/fits/internal/transform"
 	"github.com/GeoNet/fits/internal/ts"
 	"github.com/GeoNet/fits/internal/valid"
 	"github.com/GeoNet/kit/weft"
@@ -14,10 +17,12 @@ import (
 
 type plt struct {
 	ts.Plot
+	transform transform.Transform // applied to series as they are added.
+	outliers  outliers            // flags outliers in series as they are added, after the transform.
 }
 
 func plotSite(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "yrange", "type", "start", "stddev", "showMethod", "scheme", "networkID"}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "yrange", "type", "start", "end", "stddev", "showMethod", "showVisual", "trend", "annual", "semiAnnual", "steps", "outliers", "threshold", "transform", "epoch", "scheme", "networkID", "unit", "quality"}, valid.Query)
 	if err != nil {
 		return err
 	}
@@ -29,12 +34,42 @@ func plotSite(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		return err
 	}
 
-	start, err := valid.ParseStart(q.Get("start"))
+	showVisual, err := valid.ParseShowVisual(q.Get("showVisual"))
 	if err != nil {
 		return err
 	}
 
-	days, err := valid.ParseDays(q.Get("days"))
+	showTrend, err := valid.ParseTrend(q.Get("trend"))
+	if err != nil {
+		return err
+	}
+
+	m, err := parseTrendModel(q)
+	if err != nil {
+		return err
+	}
+
+	o, err := parseOutliers(q)
+	if err != nil {
+		return err
+	}
+
+	tr, err := parseTransform(q)
+	if err != nil {
+		return err
+	}
+
+	// the trend is fitted to the observations not the transformed values.
+	if showTrend && tr.Enabled() {
+		return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("trend is not available with transform")}
+	}
+
+	start, end, err := parseWindow(q)
+	if err != nil {
+		return err
+	}
+
+	quality, err := valid.ParseQuality(q.Get("quality"))
 	if err != nil {
 		return err
 	}
@@ -54,19 +89,15 @@ func plotSite(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		return err
 	}
 
-	var p plt
-
-	switch {
-	case start.IsZero() && days > 0:
-		n := time.Now().UTC()
-		start = n.Add(time.Duration(days*-1) * time.Hour * 24)
-		p.SetXAxis(start, n)
-		days = 0 // add all data > than start by setting 0.  Allows for adding start end to URL.
-	case !start.IsZero() && days > 0:
-		p.SetXAxis(start, start.Add(time.Duration(days*1)*time.Hour*24))
-	case !start.IsZero() && days == 0:
-		p.SetXAxis(start, time.Now().UTC())
+	c, err := parseUnit(q, t.unit)
+	if err != nil {
+		return err
 	}
+	t.unit = c.symbol(t.unit)
+
+	p := plt{transform: tr, outliers: o}
+
+	p.setXAxis(start, end)
 
 	switch {
 	case ymin == 0 && ymax == 0:
@@ -77,21 +108,44 @@ func plotSite(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	}
 
 	p.SetTitle(fmt.Sprintf("%s (%s) - %s", s.siteID, s.name, t.description))
-	p.SetUnit(t.unit)
-	p.SetYLabel(fmt.Sprintf("%s (%s)", t.name, t.unit))
+	unit := tr.Unit(t.unit)
+	p.SetUnit(unit)
+
+	switch tr.Enabled() {
+	case true:
+		p.SetYLabel(fmt.Sprintf("%s %s (%s)", t.name, tr.Name, unit))
+	case false:
+		p.SetYLabel(fmt.Sprintf("%s (%s)", t.name, unit))
+	}
+
+	f := obsFilter{siteID: s.siteID, typeID: t.typeID, start: start, end: end, unit: c, quality: quality}
 
 	switch showMethod {
 	case false:
-		err = p.addSeries(t, start, days, s)
+		err = p.addSeries(f, s)
 	case true:
-		err = p.addSeriesLabelMethod(t, start, days, s)
+		err = p.addSeriesLabelMethod(f)
 	}
 	if err != nil {
 		return err
 	}
 
 	if q.Get("stddev") == `pop` {
-		err = p.setStddevPop(s, t, start, days)
+		err = p.setStddevPop(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	if showVisual {
+		err = p.addVisual(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	if showTrend {
+		err = p.setTrend(f, m, t.unit)
 	}
 	if err != nil {
 		return err
@@ -114,51 +168,28 @@ func plotSite(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	return nil
 }
 
-/*
-to add all data leave start and days 0
-to add all data after start set start != 0 and days == 0
-to add n days of data after start set start != 0 and days != 0
-*/
-func (plt *plt) addSeries(t typeQ, start time.Time, days int, sites ...siteQ) (err error) {
+// setXAxis sets the x axis for the query window.  An open end is now.
+// The x axis auto ranges on the data if start is zero.
+func (plt *plt) setXAxis(start, end time.Time) {
+	switch {
+	case start.IsZero():
+	case end.IsZero():
+		plt.SetXAxis(start, time.Now().UTC())
+	default:
+		plt.SetXAxis(start, end)
+	}
+}
+
+// addSeries adds a series for each site for the observations selected by f.
+// The siteID in f is set from sites.
+func (plt *plt) addSeries(f obsFilter, sites ...siteQ) (err error) {
 	for _, s := range sites {
+		f.siteID = s.siteID
+		where, args := f.where()
+
 		var rows *sql.Rows
 
-		switch {
-		case start.IsZero() && days == 0:
-			rows, err = db.Query(
-				`SELECT time, value, error FROM fits.observation
-		WHERE
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	ORDER BY time ASC;`, s.siteID, t.typeID)
-		case !start.IsZero() && days == 0:
-			rows, err = db.Query(`SELECT time, value, error FROM fits.observation
-		WHERE
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	AND time > $3
-	ORDER BY time ASC;`, s.siteID, t.typeID, start)
-		case !start.IsZero() && days != 0:
-			rows, err = db.Query(`SELECT time, value, error FROM fits.observation
-		WHERE
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	AND time > $3
-	AND time < $4
-	ORDER BY time ASC;`, s.siteID, t.typeID, start, start.Add(time.Duration(days*1)*time.Hour*24))
-		}
+		rows, err = db.Query(`SELECT time, `+f.unit.valueSQL(`value`)+`, `+f.unit.errorSQL(`error`)+`, quality = 'suspect', qualifier FROM fits.observation`+where+`ORDER BY time ASC;`, args...)
 		if err != nil {
 			return
 		}
@@ -169,7 +200,7 @@ func (plt *plt) addSeries(t typeQ, start time.Time, days int, sites ...siteQ) (e
 
 		for rows.Next() {
 			p := ts.Point{}
-			err = rows.Scan(&p.DateTime, &p.Value, &p.Error)
+			err = rows.Scan(&p.DateTime, &p.Value, &p.Error, &p.Suspect, &p.Qualifier)
 			if err != nil {
 				return
 			}
@@ -178,105 +209,103 @@ func (plt *plt) addSeries(t typeQ, start time.Time, days int, sites ...siteQ) (e
 		}
 		rows.Close()
 
+		err = ser.Transform(plt.transform)
+		if err != nil {
+			return
+		}
+
+		err = plt.outliers.flagPoints(ser.Points)
+		if err != nil {
+			return
+		}
+
 		plt.AddSeries(ser)
 	}
 	return
 }
 
-func (plt *plt) addSeriesLabelMethod(t typeQ, start time.Time, days int, s siteQ) (err error) {
-	var rows *sql.Rows
+// addSeriesLabelMethod adds a series for each method for the observations selected by f.
+func (plt *plt) addSeriesLabelMethod(f obsFilter) (err error) {
+	where, args := f.where()
 
-	switch {
-	case start.IsZero() && days == 0:
-		rows, err = db.Query(
-			`SELECT time, value, error, methodpk FROM fits.observation
-		WHERE
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	ORDER BY time ASC;`, s.siteID, t.typeID)
-	case !start.IsZero() && days == 0:
-		rows, err = db.Query(
-			`SELECT time, value, error, methodpk FROM fits.observation
-		WHERE
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	AND time > $3
-	ORDER BY time ASC;`, s.siteID, t.typeID, start)
-	case !start.IsZero() && days != 0:
-		rows, err = db.Query(
-			`SELECT time, value, error, methodpk FROM fits.observation
-		WHERE
-		sitepk = (
-			SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1
-			)
-	AND typepk = (
-		SELECT typepk FROM fits.type WHERE typeid = $2
-		)
-	AND time > $3
-	AND time < $4
-	ORDER BY time ASC;`, s.siteID, t.typeID, start, start.Add(time.Duration(days*1)*time.Hour*24))
-	}
+	rows, err := db.Query(`SELECT time, `+f.unit.valueSQL(`value`)+`, `+f.unit.errorSQL(`error`)+`, quality = 'suspect', qualifier, methodid FROM fits.observation JOIN fits.method USING (methodpk)`+where+`ORDER BY time ASC;`, args...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
-	series := make(map[int][]ts.Point)
-	var methodPK int
+	series := make(map[string][]ts.Point)
+	var methodID string
 
 	for rows.Next() {
 		p := ts.Point{}
-		err = rows.Scan(&p.DateTime, &p.Value, &p.Error, &methodPK)
+		err = rows.Scan(&p.DateTime, &p.Value, &p.Error, &p.Suspect, &p.Qualifier, &methodID)
 		if err != nil {
 			return
 		}
-		series[methodPK] = append(series[methodPK], p)
+		series[methodID] = append(series[methodID], p)
 	}
 	rows.Close()
 
-	// look up the method name as the label for each series
+	m, err := meta.get()
+	if err != nil {
+		return
+	}
+
+	// the method name is the label for each series
 	for k, v := range series {
-		var m string
+		ser := ts.Series{Points: v, Label: k}
+		if d, ok := m.methods[k]; ok {
+			ser.Label = d.name
+		}
+
+		err = ser.Transform(plt.transform)
+		if err != nil {
+			return
+		}
 
-		err = db.QueryRow(`select name from fits.method where methodPK = $1`, k).Scan(&m)
+		err = plt.outliers.flagPoints(ser.Points)
 		if err != nil {
 			return
 		}
 
-		plt.AddSeries(ts.Series{Label: m, Points: v})
+		plt.AddSeries(ser)
 	}
 
 	return
 }
 
-func (plt *plt) setStddevPop(s siteQ, t typeQ, start time.Time, days int) (err error) {
+// setStddevPop sets the mean and population stddev for the observations selected by f.
+// If there is a transform they are for the transformed values.  Censored observations are not used.
+func (plt *plt) setStddevPop(f obsFilter) (err error) {
 	var m, d float64
-	switch {
-	case start.IsZero() && days == 0:
-		err = db.QueryRow(
-			`SELECT avg(value), stddev_pop(value) FROM fits.observation
-         WHERE
-         sitepk = (SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1)
-         AND typepk = ( SELECT typepk FROM fits.type WHERE typeid = $2 )`,
-			s.siteID, t.typeID).Scan(&m, &d)
-	case !start.IsZero() && days == 0:
-		err = db.QueryRow(
-			`SELECT avg(value), stddev_pop(value) FROM fits.observation
-          WHERE
-          sitepk = (SELECT DISTINCT ON (sitepk) sitepk from fits.site where siteid = $1)
-	      AND typepk = (SELECT typepk FROM fits.type WHERE typeid = $2 )
-	      AND time > $3`, s.siteID, t.typeID, start).Scan(&m, &d)
-	}
-	if err != nil {
-		return
+
+	switch plt.transform.Enabled() {
+	case true:
+		var values []value
+		values, err = loadObs(f, resample{})
+		if err != nil {
+			return
+		}
+
+		values, _ = uncensored(values)
+
+		values, err = transformValues(values, plt.transform)
+		if err != nil {
+			return
+		}
+
+		v := make([]float64, len(values))
+		for i := range values {
+			v[i] = values[i].V
+		}
+
+		m, d = stats.MeanStddevPop(v)
+	case false:
+		m, d, err = stddevPop(f)
+		if err != nil {
+			return
+		}
 	}
 
 	plt.SetMeanStddev(m, d)
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/GeoNet/fits/internal/ts"
 	"github.com/GeoNet/fits/internal/valid"
@@ -12,19 +11,19 @@ import (
 )
 
 func plotSites(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"sites", "typeID"}, []string{"days", "yrange", "type", "start", "scheme"}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"sites", "typeID"}, []string{"days", "yrange", "type", "start", "end", "scheme", "unit", "quality"}, valid.Query)
 	if err != nil {
 		return err
 	}
 
 	h.Set("Content-Type", "image/svg+xml")
 
-	start, err := valid.ParseStart(q.Get("start"))
+	start, end, err := parseWindow(q)
 	if err != nil {
 		return err
 	}
 
-	days, err := valid.ParseDays(q.Get("days"))
+	quality, err := valid.ParseQuality(q.Get("quality"))
 	if err != nil {
 		return err
 	}
@@ -44,19 +43,15 @@ func plotSites(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		return err
 	}
 
+	c, err := parseUnit(q, t.unit)
+	if err != nil {
+		return err
+	}
+	t.unit = c.symbol(t.unit)
+
 	var p plt
 
-	switch {
-	case start.IsZero() && days > 0:
-		n := time.Now().UTC()
-		start = n.Add(time.Duration(days*-1) * time.Hour * 24)
-		p.SetXAxis(start, n)
-		days = 0 // add all data > than start by setting 0.  Allows for adding start end to URL.
-	case !start.IsZero() && days > 0:
-		p.SetXAxis(start, start.Add(time.Duration(days*1)*time.Hour*24))
-	case !start.IsZero() && days == 0:
-		p.SetXAxis(start, time.Now().UTC())
-	}
+	p.setXAxis(start, end)
 
 	switch {
 	case ymin == 0 && ymax == 0:
@@ -70,7 +65,7 @@ func plotSites(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	p.SetUnit(t.unit)
 	p.SetYLabel(fmt.Sprintf("%s (%s)", t.name, t.unit))
 
-	err = p.addSeries(t, start, days, s...)
+	err = p.addSeries(obsFilter{typeID: t.typeID, start: start, end: end, unit: c, quality: quality}, s...)
 	if err != nil {
 		return err
 	}
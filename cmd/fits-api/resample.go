@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/GeoNet/kit/weft"
+)
+
+/*
+resample aggregates observations into regular time buckets.  The buckets are
+aligned using date_trunc in UTC e.g., weeks start on Monday.
+
+Errors are propagated from the observation errors:
+
+	mean   - sqrt(sum(error^2)) / n, where n is the number of observations with an error.
+	median - sqrt(pi/2) * the mean error (the large sample error of the median).
+	min    - the error of the minimum observation.
+	max    - the error of the maximum observation.
+
+A zero (unknown) error contributes nothing to the propagated error.  A bucket with no errors has a zero error.
+
+The qualifier for the min or max is the qualifier of that observation.  For the mean or median it is the
+qualifier of the observations if they all have the same one, otherwise ~ (approximate).  The detection
+limit is the detection limit of the min or max observation, or the largest in the bucket.
+*/
+type resample struct {
+	interval  string // day, week, month, or year.  Empty for no resampling.
+	aggregate string // mean, median, min, or max.  Defaults to mean.
+}
+
+func (rs resample) enabled() bool {
+	return rs.interval != ""
+}
+
+// timeSQL is the start of the bucket for each observation.
+// interval has been validated so is safe to use in the query.
+func (rs resample) timeSQL() string {
+	return fmt.Sprintf(`date_trunc('%s', time, 'UTC')`, rs.interval)
+}
+
+func (rs resample) valueSQL() string {
+	switch rs.aggregate {
+	case `median`:
+		return `percentile_cont(0.5) WITHIN GROUP (ORDER BY value::double precision)`
+	case `min`:
+		return `min(value)`
+	case `max`:
+		return `max(value)`
+	default:
+		return `avg(value)`
+	}
+}
+
+// meanErrorSQL is the error of the mean for a bucket.  Only observations with an error are counted.
+const meanErrorSQL = `COALESCE(sqrt(sum(error * error)) / NULLIF(count(*) FILTER (WHERE error <> 0), 0), 0)`
+
+func (rs resample) errorSQL() string {
+	switch rs.aggregate {
+	case `median`:
+		return `sqrt(pi() / 2) * ` + meanErrorSQL
+	case `min`:
+		return `(array_agg(error ORDER BY value ASC))[1]`
+	case `max`:
+		return `(array_agg(error ORDER BY value DESC))[1]`
+	default:
+		return meanErrorSQL
+	}
+}
+
+// qualifierSQL is the qualifier for each bucket.
+func (rs resample) qualifierSQL() string {
+	switch rs.aggregate {
+	case `min`:
+		return `(array_agg(qualifier ORDER BY value ASC))[1]`
+	case `max`:
+		return `(array_agg(qualifier ORDER BY value DESC))[1]`
+	default:
+		return `CASE WHEN count(DISTINCT qualifier) = 1 THEN min(qualifier) ELSE '~' END`
+	}
+}
+
+// detectionLimitSQL is the detection limit for each bucket.
+func (rs resample) detectionLimitSQL() string {
+	switch rs.aggregate {
+	case `min`:
+		return `(array_agg(detection_limit ORDER BY value ASC))[1]`
+	case `max`:
+		return `(array_agg(detection_limit ORDER BY value DESC))[1]`
+	default:
+		return `max(detection_limit)`
+	}
+}
+
+// query returns an SQL query and arguments for time, value, error, methodid, systemid, sampleid, quality,
+// qualifier, and detection_limit for the observations selected by f, resampled if required.  The query is not ordered.
+// A resampled bucket has a methodid (or sample, or quality) only if all the observations in it have the same one.
+// The values are converted to the unit for f after resampling.
+func (rs resample) query(f obsFilter) (string, []interface{}) {
+	where, args := f.where()
+
+	obs := `fits.observation`
+	if !f.asOf.IsZero() {
+		obs, args = f.revisions()
+		where = ""
+	}
+
+	from := ` FROM ` + obs + ` JOIN fits.method USING (methodpk)
+		JOIN fits.sample USING (samplepk) JOIN fits.system USING (systempk)`
+
+	var q string
+
+	switch rs.enabled() {
+	case false:
+		q = `SELECT time, value, error, methodid, systemid, sampleid, quality, qualifier, detection_limit` + from + where
+	case true:
+		q = `SELECT ` + rs.timeSQL() + ` AS time, ` + rs.valueSQL() + ` AS value, ` + rs.errorSQL() + ` AS error,
+		CASE WHEN count(DISTINCT methodid) = 1 THEN min(methodid) ELSE '' END AS methodid,
+		CASE WHEN count(DISTINCT samplepk) = 1 THEN min(systemid) ELSE '' END AS systemid,
+		CASE WHEN count(DISTINCT samplepk) = 1 THEN min(sampleid) ELSE '' END AS sampleid,
+		CASE WHEN count(DISTINCT quality) = 1 THEN min(quality) ELSE '' END AS quality,
+		` + rs.qualifierSQL() + ` AS qualifier, ` + rs.detectionLimitSQL() + ` AS detection_limit` + from + where + ` GROUP BY 1`
+	}
+
+	if f.unit.enabled() {
+		q = `SELECT time, ` + f.unit.valueSQL(`value`) + ` AS value, ` + f.unit.errorSQL(`error`) + ` AS error,
+		methodid, systemid, sampleid, quality, qualifier, ` + f.unit.valueSQL(`detection_limit`) + ` AS detection_limit FROM (` + q + `) AS u`
+	}
+
+	return q, args
+}
+
+// parseResample returns the resampling for the optional interval and aggregate query parameters.
+func parseResample(q url.Values) (resample, error) {
+	rs := resample{
+		interval:  q.Get("interval"),
+		aggregate: q.Get("aggregate"),
+	}
+
+	if rs.aggregate != "" && rs.interval == "" {
+		return rs, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("interval must be specified when aggregate is specified")}
+	}
+
+	return rs, nil
+}
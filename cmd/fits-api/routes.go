@@ -23,11 +23,20 @@ func init() {
 	mux.HandleFunc("/map/site", weft.MakeHandler(siteMapHandler, weft.TextError))
 	mux.HandleFunc("/observation_results", weft.MakeHandler(observationResults, weft.TextError))
 	mux.HandleFunc("/observation/stats", weft.MakeHandler(observationStats, weft.TextError))
+	mux.HandleFunc("/observation/multi", weft.MakeHandler(observationMulti, weft.TextError))
+	mux.HandleFunc("/observation/trend", weft.MakeHandler(observationTrend, weft.TextError))
+	mux.HandleFunc("/observation/gaps", weft.MakeHandler(gapsHandler, weft.TextError))
+	mux.HandleFunc("/observation/history", weft.MakeHandler(observationHistory, weft.TextError))
+	mux.HandleFunc("/inventory", weft.MakeHandler(inventory, weft.TextError))
 	mux.HandleFunc("/type", weft.MakeHandler(types, weft.TextError))
 	mux.HandleFunc("/method", weft.MakeHandler(method, weft.TextError))
 	mux.HandleFunc("/plot", weft.MakeHandler(plotHandler, weft.TextError))
-	mux.HandleFunc("/observation", weft.MakeHandler(observationHandler, weft.TextError))
+	mux.HandleFunc("/observation", weft.MakeDirectHandler(observationHandler, weft.TextError))
 	mux.HandleFunc("/site", weft.MakeHandler(siteHandler, weft.TextError))
+	mux.HandleFunc("/site/search", weft.MakeHandler(siteSearch, weft.TextError))
+	mux.HandleFunc("/sample", weft.MakeHandler(sample, weft.TextError))
+	mux.HandleFunc("/sample/observation", weft.MakeHandler(sampleObservation, weft.TextError))
+	mux.HandleFunc("/visual_observation", weft.MakeHandler(visualObservation, weft.TextError))
 	mux.HandleFunc("/", weft.MakeHandlerWithCspNonce(charts, weft.HTMLError, chartCsp))
 	mux.HandleFunc("/charts", weft.MakeHandlerWithCspNonce(charts, weft.HTMLError, chartCsp))
 
@@ -43,11 +52,25 @@ func init() {
 
 // these handlers take care of the extra routing based on optional query parameters
 
-func observationHandler(r *http.Request, h http.Header, b *bytes.Buffer) error {
+// observationHandler streams the response so large responses are not held in memory.
+// Observations are added with POST.
+func observationHandler(r *http.Request, w http.ResponseWriter) (int64, error) {
+	if r.Method == "POST" {
+		return observationPost(r, w)
+	}
+
+	if r.URL.Query().Get("siteID") != "" {
+		return observation(r, w)
+	} else {
+		return spatialObs(r, w)
+	}
+}
+
+func gapsHandler(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	if r.URL.Query().Get("siteID") != "" {
-		return observation(r, h, b)
+		return observationGaps(r, h, b)
 	} else {
-		return spatialObs(r, h, b)
+		return observationGapsNetwork(r, h, b)
 	}
 }
 
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	wt "github.com/GeoNet/kit/weft/wefttest"
 )
@@ -21,14 +31,57 @@ var routes = wt.Requests{
 	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))"},
 	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?typeID=t1&within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))"},
 	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?typeID=t1&methodID=m1&within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?near=172.8,-42.2&radius=10"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?near=175.8,-42.2&limit=2"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?near=175.8,-42.2&radius=500&limit=2&typeID=t1&methodID=m1"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?siteID=TEST1&inventory=true"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?typeID=t1&inventory=true"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?siteID=TEST1&inventory=true&quality=suspect"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site?typeID=t1&near=175.8,-42.2&limit=2&inventory=true&quality=raw,verified"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site/search?q=test"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site/search?q=T%C3%A9st+Site"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site/search?q=test1"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site/search?q=site&typeID=t2&limit=1"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/site/search?q=nomatch"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/inventory"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/inventory"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/inventory?siteID=TEST2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/inventory?siteID=TEST2&typeID=t1&methodID=m2"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/inventory?typeID=t2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/inventory?quality=suspect"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/inventory?siteID=TEST1&quality=raw,verified"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?siteID=TEST1&typeID=t1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?siteID=TEST1&typeID=t1&start=2000-01-01T00:00:00Z&end=2000-01-10T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?siteID=TEST1&typeID=t1&methodID=m1&start=2000-01-01T00:00:00Z&days=30&interval=week"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?siteID=TEST3&typeID=t1&start=2001-01-01T00:00:00Z&days=30&interval=day"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?typeID=t1&start=2000-01-01T00:00:00Z&end=2001-12-31T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?typeID=t1&days=30&interval=day"},
 
 	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation_results?typeID=t1&siteID=TEST1"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation_results?typeID=t1&siteID=TEST1,TEST2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation_results?typeID=t1&siteID=TEST1&interval=day"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation_results?typeID=t1&siteID=TEST1,TEST2&interval=month&aggregate=median"},
 
 	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1&methodID=m1"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1&days=40000"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1&days=40000&methodID=m1"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1&percentiles=5,50,95"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t2&siteID=TEST2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t2&siteID=TEST2&unit=%C2%B0C"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t2&siteID=TEST2&unit=%C2%B0C"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t2&siteID=TEST2&unit=%C2%B0C"},
+	{ID: wt.L(), Accept: covJSON, Content: covJSON, URL: "/observation?typeID=t2&siteID=TEST2&unit=%C2%B0C"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t2&siteID=TEST2&unit=K"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t2&siteID=TEST3"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t2&siteID=TEST3&unit=%C2%B0C"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t2&start=2001-01-01T00:00:00Z&days=30"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/observation?typeID=t2&start=2001-01-01T00:00:00Z&days=30"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t2&sites=TEST2,TEST3&start=2001-01-01T00:00:00Z&days=30"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&unit=mm"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&unit=mm&interval=day&aggregate=median"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&unit=mm&transform=rate"},
 
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&networkID=TN1"},
@@ -39,14 +92,75 @@ var routes = wt.Requests{
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&networkID=TN1&days=400"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&days=400"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&days=400&methodID=m1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&methodID=m1&days=400"},
+	{ID: wt.L(), Accept: covJSON, Content: covJSON, URL: "/observation?typeID=t1&siteID=TEST1"},
+	{ID: wt.L(), Accept: covJSON, Content: covJSON, URL: "/observation?typeID=t1&siteID=TEST2&methodID=m2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&days=7&methodID=m1"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&end=2000-01-08T00:00:00Z&days=7"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&interval=day"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&interval=week&aggregate=median"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&interval=month&aggregate=max"},
+	{ID: wt.L(), Accept: covJSON, Content: covJSON, URL: "/observation?typeID=t1&siteID=TEST1&interval=year&aggregate=min"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&outliers=mad"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&outliers=iqr&threshold=1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&outliers=mad&threshold=2"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&interval=day&outliers=iqr"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&transform=rate"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&transform=diff&outliers=mad"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST2&transform=cumulative&methodID=m1"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&transform=relative&epoch=2000-01-08T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&transform=rate&interval=day"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&transform=relative"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&methodID=m1"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2000-01-05T00:00:00Z&days=2&srsName=EPSG:27200"},
 	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))&methodID=m1"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=30"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=800&bbox=165,-48,179,-34"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=800"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=800&bbox=174,-48,179,-34&methodID=m3"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=800&near=175.8,-42.2&limit=1"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=800&near=175.8,-42.2&radius=300"},
 	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/type"},
 	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/method?typeID=t1"},
 	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/method"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/sample?siteID=TEST2"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/sample?siteID=TEST1&systemID=lab"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/sample/observation?systemID=lab&sampleID=0001"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation/multi?siteID=TEST2&typeID=t1,t2"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/multi?siteID=TEST2&typeID=t1,t2"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation/multi?siteID=TEST2&typeID=t2,t1&showMethod=true"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation/multi?siteID=TEST2&typeID=t1,t2&interval=day&aggregate=max"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/multi?siteID=TEST2&typeID=t1,t2&start=2001-01-01T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/trend?siteID=TEST2&typeID=t1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/visual_observation?siteID=TEST1"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/visual_observation?siteID=TEST1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/visual_observation?siteID=TEST1&start=2000-01-08T00:00:00Z&end=2000-01-10T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/sample/observation?systemID=lab&sampleID=0001"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST2&systemID=lab"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST2&systemID=lab&sampleID=0001"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&asOf=2000-01-01T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&methodID=m1&asOf=2100-01-01T00:00:00Z&interval=day"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation/history?typeID=t1&siteID=TEST1"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/history?typeID=t1&siteID=TEST1&methodID=m1&time=2000-01-01T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/history?typeID=t1&siteID=TEST2&systemID=lab&sampleID=0001"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&siteID=TEST1&quality=suspect"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&quality=raw,verified&interval=day"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation?typeID=t1&siteID=TEST1&quality=verified&asOf=2100-01-01T00:00:00Z"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=30&quality=raw,verified"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: v1GeoJSON, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=30&quality=suspect"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation/stats?typeID=t1&siteID=TEST1&quality=raw,verified"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation_results?typeID=t1&siteID=TEST1,TEST2&quality=raw"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1JSON, URL: "/observation_results?typeID=t1&siteID=TEST1&interval=day&quality=raw,suspect"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/multi?siteID=TEST2&typeID=t1,t2&quality=raw"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/trend?siteID=TEST2&typeID=t1&quality=raw,verified"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?siteID=TEST1&typeID=t1&quality=raw,verified"},
+	{ID: wt.L(), Accept: v1JSON, Content: v1JSON, URL: "/observation/gaps?typeID=t1&days=30&quality=raw"},
+	{ID: wt.L(), Accept: v1CSV, Content: v1CSV, URL: "/sample/observation?systemID=lab&sampleID=0001&quality=raw"},
 
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&networkID=TN1"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&scheme=web"},
@@ -54,6 +168,10 @@ var routes = wt.Requests{
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&networkID=TN1&yrange=12.2"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&yrange=12.2"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&networkID=TN1&days=10000"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&unit=mm"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&unit=mm&showMethod=true&stddev=pop"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&sites=TEST1,TEST2&unit=mm"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&unit=mm"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&days=10000"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&networkID=TN1&days=10000&yrange=12.2"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&days=10000&yrange=12.2"},
@@ -65,6 +183,22 @@ var routes = wt.Requests{
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&start=2010-11-24T00:00:00Z&days=10000&yrange=12.2&showMethod=true"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&start=2010-11-24T00:00:00Z&yrange=12.2&showMethod=true"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&yrange=12.2&showMethod=true"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z&stddev=pop"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z&showMethod=true"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&showVisual=true"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z&showVisual=true"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST2&trend=true"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&trend=true"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t2&siteID=TEST3&trend=true&stddev=pop"}, // all censored
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&outliers=mad"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&type=scatter&showMethod=true&outliers=iqr&threshold=1"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&transform=diff"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&transform=cumulative&stddev=pop"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&type=scatter&showMethod=true&transform=rate"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&quality=raw,verified"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&siteID=TEST1&showMethod=true&quality=suspect"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&sites=TEST1,TEST2&quality=raw"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&quality=raw,verified"},
 
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&sites=TEST1,TEST2"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/plot?typeID=t1&sites=TEST1,TEST2&scheme=web"},
@@ -77,6 +211,10 @@ var routes = wt.Requests{
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&days=12"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=line"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&outliers=mad"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=scatter&outliers=iqr&threshold=1"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&transform=rate"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&transform=relative&epoch=2000-01-08T00:00:00Z&stddev=pop"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=scatter"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=line&label=all"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=line&label=latest"},
@@ -84,6 +222,7 @@ var routes = wt.Requests{
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=scatter&label=all"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=scatter&label=latest"},
 	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&type=scatter&label=none"},
+	{ID: wt.L(), Accept: svg, Content: svg, URL: "/spark?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z"},
 
 	// Routes that should bad request.
 	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1"},
@@ -95,10 +234,30 @@ var routes = wt.Requests{
 	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST1&yrange=-12.2"},
 	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST1&networkID=TN1&yrange=0"},
 	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST1&yrange=0"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST1&start=2000-01-08T00:00:00Z&end=2000-01-01T00:00:00Z"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&start=2000-01-01T00:00:00Z&end=2000-01-08T00:00:00Z&days=2"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&aggregate=mean"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&sampleID=0001"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation/multi?typeID=t1,t1&siteID=TEST1"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation/stats?typeID=t1&siteID=TEST1&percentiles=101"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation/trend?typeID=t1&siteID=TEST2&steps=2000-13-01"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation/trend?typeID=t1&siteID=TEST2&steps=1999-01-01T00:00:00Z"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST2&trend=yes"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&outliers=zscore"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&threshold=3"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST1&outliers=mad&threshold=0"},
+	{ID: wt.L(), Accept: covJSON, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&outliers=mad"},
+	{ID: wt.L(), Accept: covJSON, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&transform=rate"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&transform=log"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&transform=diff&epoch=2000-01-08T00:00:00Z"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST2&transform=rate&trend=true"},
+	{ID: wt.L(), Status: http.StatusBadRequest, URL: "/spark?typeID=t1&siteID=TEST1&epoch=2000-01-08T00:00:00Z"},
 
 	// CSV routes that should bad request
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=0"},
-	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=8"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=400000"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&bbox=165,-48,179,-34&within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&srsName=EPSG:27200"},
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&srsName=EPSG:999999"},
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&within=POLYGON((177.18+-37.52,177.19+-37.52,177.20+-37.53))"},             // not enough points
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&within=POLYGON((177.18+-37.52,177.19+-37.52,177.20+-37.53,178.0+-34.5))"}, // doesn't close
@@ -106,15 +265,60 @@ var routes = wt.Requests{
 	// GeoJSON routes that should bad request
 	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?methodID=m1"},
 	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?methodID=m1&within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,170.18+-37.52))"},
-	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?within=POLYGON((170.18+-37.52,177.19+-47.52))"},                             // not enough points
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?within=POLYGON((170.18+-37.52,177.19+-47.52))"}, // not enough points
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?near=172.8,-42.2"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?radius=10"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?near=172.8&radius=10"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?near=172.8,-42.2&limit=0"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?siteID=TEST1&inventory=yes"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?siteID=TEST1&quality=raw"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?typeID=t1&inventory=true&quality=good"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t2&siteID=TEST2&unit=m"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t2&siteID=TEST2&unit=furlong"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusBadRequest, URL: "/observation/stats?typeID=t1&siteID=TEST1&unit=%C2%B0C"},
+	{ID: wt.L(), Accept: svg, Content: textError, Status: http.StatusBadRequest, URL: "/spark?typeID=t1&siteID=TEST1&unit=K"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site/search"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site/search?q=te%25"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site/search?q=test&limit=0"},
+	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusNotFound, URL: "/site/search?q=test&typeID=notype"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusBadRequest, URL: "/inventory?methodID=m1"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusBadRequest, URL: "/inventory?quality=good"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/inventory?siteID=NOSITE"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/inventory?typeID=notype"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/gaps?siteID=NOSITE&typeID=t1"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/gaps?siteID=TEST3&typeID=t1"}, // one observation, can't infer the cadence
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/gaps?siteID=TEST1&typeID=t2"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusBadRequest, URL: "/observation/gaps?siteID=TEST1&typeID=t1&interval=fortnight"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusBadRequest, URL: "/observation/gaps?typeID=t1"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=800&limit=1"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&asOf=2000-01-01"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation/history?typeID=t1&siteID=TEST1&time=yesterday"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation/history?typeID=t1"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&siteID=TEST1&quality=good"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusBadRequest, URL: "/observation/stats?typeID=t1&siteID=TEST1&quality=raw,"},
+	{ID: wt.L(), Accept: svg, Content: textError, Status: http.StatusBadRequest, URL: "/plot?typeID=t1&siteID=TEST1&quality=good"},
 	{ID: wt.L(), Accept: v1GeoJSON, Content: textError, Status: http.StatusBadRequest, URL: "/site?within=POLYGON((170.18+-37.52,177.19+-47.52,177.20+-37.53,178.18+-37.52))"}, // doesn't close
 
 	// Routes that should 404
 	{ID: wt.L(), Status: http.StatusNotFound, URL: "/bob"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation?typeID=t1&siteID=NOSITE"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/history?typeID=t1&siteID=NOSITE"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/history?typeID=t9&siteID=TEST1"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusNotFound, URL: "/observation?typeID=t1&siteID=TEST1&systemID=lab&sampleID=9999"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/sample?siteID=NOSITE"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/visual_observation?siteID=NOSITE"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusNotFound, URL: "/observation/multi?typeID=t1,t99&siteID=TEST1"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/stats?typeID=t1&siteID=TEST1&start=2020-01-01T00:00:00Z"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/stats?typeID=t2&siteID=TEST1"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/stats?typeID=t2&siteID=TEST3"}, // all censored
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/trend?typeID=t2&siteID=TEST3"}, // all censored
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/trend?typeID=t1&siteID=TEST1"},
+	{ID: wt.L(), Accept: v1JSON, Content: textError, Status: http.StatusNotFound, URL: "/observation/trend?typeID=t1&siteID=NOSITE"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusNotFound, URL: "/sample/observation?systemID=lab&sampleID=9999"},
 
 	// CSV routes that should bad request
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=0"},
-	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=8"},
+	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=400000"},
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&srsName=EPSG:999999"},
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&within=POLYGON((177.18+-37.52,177.19+-37.52,177.20+-37.53))"},             // not enough points
 	{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2010-11-24T00:00:00Z&days=2&within=POLYGON((177.18+-37.52,177.19+-37.52,177.20+-37.53,178.0+-34.5))"}, // doesn't close
@@ -141,3 +345,469 @@ func TestRoutes(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestSpatialObsRowBudget(t *testing.T) {
+	setup(t)
+	defer t.Cleanup(teardown)
+
+	b := spatialRowBudget
+	defer func() { spatialRowBudget = b }()
+
+	spatialRowBudget = 1
+
+	r := wt.Request{ID: wt.L(), Accept: v1CSV, Content: textError, Status: http.StatusBadRequest, URL: "/observation?typeID=t1&start=2000-01-01T00:00:00Z&days=30"}
+
+	if b, err := r.Do(testServer.URL); err != nil {
+		t.Error(err)
+		t.Error(string(b))
+	}
+}
+
+// Test conditional GET and compression.  These are done by inbound.
+func TestConditionalGet(t *testing.T) {
+	setup(t)
+	defer t.Cleanup(teardown)
+
+	s := httptest.NewServer(inbound(mux))
+	defer s.Close()
+
+	get := func(url string, h map[string]string) *http.Response {
+		req, err := http.NewRequest("GET", s.URL+url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	u := "/observation?siteID=TEST1&typeID=t1"
+
+	res := get(u, map[string]string{"Accept": v1CSV})
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+
+	tag := res.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("expected an ETag")
+	}
+
+	modified := res.Header.Get("Last-Modified")
+	if modified == "" {
+		t.Fatal("expected Last-Modified")
+	}
+
+	in := []struct {
+		id     string
+		url    string
+		h      map[string]string
+		status int
+	}{
+		{id: wt.L(), url: u, h: map[string]string{"Accept": v1CSV, "If-None-Match": tag}, status: http.StatusNotModified},
+		{id: wt.L(), url: u, h: map[string]string{"Accept": v1CSV, "If-Modified-Since": modified}, status: http.StatusNotModified},
+		{id: wt.L(), url: u, h: map[string]string{"Accept": v1CSV, "If-None-Match": `W/"0"`, "If-Modified-Since": modified}, status: http.StatusOK},
+		// the tag depends on the representation.
+		{id: wt.L(), url: u, h: map[string]string{"Accept": v1JSON, "If-None-Match": tag}, status: http.StatusOK},
+		// observations in the last days depend on when the request is made.
+		{id: wt.L(), url: u + "&days=10000", h: map[string]string{"Accept": v1CSV, "If-None-Match": tag}, status: http.StatusOK},
+		{id: wt.L(), url: "/observation?siteID=TEST2&typeID=t1", h: map[string]string{"Accept": v1CSV, "If-None-Match": tag}, status: http.StatusOK},
+		{id: wt.L(), url: "/observation?siteID=NOSITE&typeID=t1", h: map[string]string{"Accept": v1CSV}, status: http.StatusNotFound},
+	}
+
+	for _, v := range in {
+		res := get(v.url, v.h)
+		res.Body.Close()
+
+		if res.StatusCode != v.status {
+			t.Errorf("%s expected status %d got %d", v.id, v.status, res.StatusCode)
+		}
+
+		if res.StatusCode == http.StatusNotFound && res.Header.Get("ETag") != "" {
+			t.Errorf("%s expected no ETag for an error", v.id)
+		}
+	}
+
+	// the streamed observations are compressed.  Setting Accept-Encoding stops
+	// the client decoding the response.
+	for _, e := range []string{"gzip", "deflate"} {
+		res := get(u, map[string]string{"Accept": v1CSV, "Accept-Encoding": e})
+
+		if res.Header.Get("Content-Encoding") != e {
+			t.Errorf("expected Content-Encoding %s got %s", e, res.Header.Get("Content-Encoding"))
+		}
+
+		var r io.Reader
+		switch e {
+		case "gzip":
+			r, err = gzip.NewReader(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case "deflate":
+			r = flate.NewReader(res.Body)
+		}
+
+		b, err := io.ReadAll(r)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, body) {
+			t.Errorf("%s body differs from the uncompressed body", e)
+		}
+	}
+}
+
+// Test adding observations with POST.
+func TestObservationPost(t *testing.T) {
+	setup(t)
+	defer t.Cleanup(teardown)
+
+	var err error
+	dbw, err = sql.Open("postgres", "host=localhost connect_timeout=5 user=fits_w password=test dbname=fits sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		dbw.Close()
+		dbw = nil
+	}()
+
+	sum := sha256.Sum256([]byte("test-token"))
+	writers = []writer{{name: "test", hash: sum[:]}}
+	defer func() { writers = nil }()
+
+	defer func() {
+		_, err := dbw.Exec(`DELETE FROM fits.observation WHERE time >= '1999-01-01T00:00:00Z' AND time < '2000-01-01T00:00:00Z'`)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = dbw.Exec(`DELETE FROM fits.observation_revision WHERE time >= '1999-01-01T00:00:00Z' AND time < '2000-01-01T00:00:00Z'`)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	post := func(query, token, content, body string) *http.Response {
+		req, err := http.NewRequest("POST", testServer.URL+"/observation"+query, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", content)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	csv := `siteID, typeID, methodID, date-time, value, error, systemID, sampleID, unit
+TEST3, t1, m3, 1999-01-01T00:00:00Z, 1.25, 0.1, lab, 0001,
+TEST1, t1, m1, 2000-01-09T12:00:00.000000Z, 4.52, 1.1, none, none, m
+TEST1, t1, m2, 1999-01-02T00:00:00Z, 1250, 100, none, none, mm
+NOSITE, t1, m1, 1999-01-01T00:00:00Z, 1.25, 0.1, none, none,
+TEST1, t2, m2, 1999-01-01T00:00:00Z, 1.25, 0.1, none, none,
+TEST1, t1, m1, 1999-01-01T00:00:00Z, 0x1p-2, 0.1, none, none,
+TEST1, t1, m1, 1999-01-01T00:00:00Z, 1.25, 0.1, lab, 9999,
+TEST1, t1, m1, 1999-01-01T00:00:00Z, 1.25, 0.1, none, none, K
+TEST1, t1, m1, 1999-01-01T00:00:00Z
+`
+
+	in := []struct {
+		id, token, content, body string
+		status                   int
+	}{
+		{id: wt.L(), content: v1CSV, body: csv, status: http.StatusUnauthorized},
+		{id: wt.L(), token: "wrong", content: v1CSV, body: csv, status: http.StatusUnauthorized},
+		{id: wt.L(), token: "test-token", content: "text/plain", body: csv, status: http.StatusUnsupportedMediaType},
+		{id: wt.L(), token: "test-token", content: v1CSV, body: "siteID, typeID\n", status: http.StatusBadRequest},
+		{id: wt.L(), token: "test-token", content: v1CSV, body: "siteID, typeID, methodID, date-time, value, colour\n", status: http.StatusBadRequest},
+		{id: wt.L(), token: "test-token", content: v1JSON, body: `[]`, status: http.StatusBadRequest},
+		{id: wt.L(), token: "test-token", content: v1JSON, body: `[{"SiteID": "TEST1", "Colour": "red"}]`, status: http.StatusBadRequest},
+	}
+
+	for _, v := range in {
+		res := post("", v.token, v.content, v.body)
+		res.Body.Close()
+
+		if res.StatusCode != v.status {
+			t.Errorf("%s expected status %d got %d", v.id, v.status, res.StatusCode)
+		}
+	}
+
+	res := post("", "test-token", v1CSV, csv)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+
+	var rep postReport
+	if err = json.NewDecoder(res.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+
+	// the TEST1 observation on 2000-01-09 is the same as the test data.
+	if rep.Accepted != 2 || rep.Updated != 0 || rep.Unchanged != 1 || rep.Rejected != 6 {
+		t.Errorf("expected 2 accepted, 1 unchanged, 6 rejected got %+v", rep)
+	}
+
+	expected := []string{"accepted", "unchanged", "accepted", "rejected", "rejected", "rejected", "rejected", "rejected", "rejected"}
+
+	if len(rep.Lines) != len(expected) {
+		t.Fatalf("expected %d lines got %d", len(expected), len(rep.Lines))
+	}
+
+	for i, v := range rep.Lines {
+		if v.Line != i+2 {
+			t.Errorf("expected line %d got %d", i+2, v.Line)
+		}
+		if v.Result != expected[i] {
+			t.Errorf("line %d expected %s got %s", v.Line, expected[i], v.Result)
+		}
+	}
+
+	res = post("?reason=corrected+lab+result", "test-token", v1JSON, `[{"SiteID": "TEST3", "TypeID": "t1", "MethodID": "m3",
+		"SystemID": "lab", "SampleID": "0001", "DateTime": "1999-01-01T00:00:00Z", "Value": 1.5, "Error": 0.1}]`)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+
+	rep = postReport{}
+	if err = json.NewDecoder(res.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Updated != 1 || len(rep.Lines) != 1 || rep.Lines[0].Line != 1 {
+		t.Errorf("expected one updated observation on line 1 got %+v", rep)
+	}
+
+	// sending it again changes nothing.
+	res = post("?reason=corrected+lab+result", "test-token", v1JSON, `[{"SiteID": "TEST3", "TypeID": "t1", "MethodID": "m3",
+		"SystemID": "lab", "SampleID": "0001", "DateTime": "1999-01-01T00:00:00Z", "Value": 1.50, "Error": 0.1}]`)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+
+	rep = postReport{}
+	if err = json.NewDecoder(res.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.Unchanged != 1 || rep.Updated != 0 || len(rep.Lines) != 1 || rep.Lines[0].Result != "unchanged" {
+		t.Errorf("expected one unchanged observation got %+v", rep)
+	}
+
+	var v float64
+	err = db.QueryRow(`SELECT value FROM fits.observation JOIN fits.site USING (sitepk)
+		WHERE siteid = 'TEST3' AND time = '1999-01-01T00:00:00Z'`).Scan(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 1.5 {
+		t.Errorf("expected value 1.5 got %f", v)
+	}
+
+	// observations in mm are converted to m.
+	var e float64
+	err = db.QueryRow(`SELECT value, error FROM fits.observation JOIN fits.site USING (sitepk)
+		WHERE siteid = 'TEST1' AND time = '1999-01-02T00:00:00Z'`).Scan(&v, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 1.25 || e != 0.1 {
+		t.Errorf("expected value 1.25 and error 0.1 got %f and %f", v, e)
+	}
+
+	// the history has the observation when it was added and when it was updated.
+	var revisions []revision
+	get(t, "/observation/history?siteID=TEST3&typeID=t1&time=1999-01-01T00:00:00Z", &revisions)
+
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions got %+v", revisions)
+	}
+
+	r0, r1 := revisions[0], revisions[1]
+
+	if r0.OldValue != nil || *r0.Value != 1.25 || r0.Submitter != "test" || r0.Reason != "" {
+		t.Errorf("unexpected revision for the added observation %+v", r0)
+	}
+
+	if *r1.OldValue != 1.25 || *r1.Value != 1.5 || *r1.Error != 0.1 || r1.Submitter != "test" || r1.Reason != "corrected lab result" {
+		t.Errorf("unexpected revision for the updated observation %+v", r1)
+	}
+
+	// asOf shows the observations as they were.
+	for _, v := range []struct {
+		asOf   time.Time
+		values []float64
+	}{
+		{asOf: r0.Modified.Add(-time.Microsecond)},
+		{asOf: r0.Modified, values: []float64{1.25}},
+		{asOf: r1.Modified, values: []float64{1.5}},
+	} {
+		var values []value
+		get(t, "/observation?siteID=TEST3&typeID=t1&start=1999-01-01T00:00:00Z&end=1999-01-02T00:00:00Z&asOf="+v.asOf.UTC().Format(time.RFC3339Nano), &values)
+
+		if len(values) != len(v.values) {
+			t.Errorf("asOf %s expected %d values got %+v", v.asOf, len(v.values), values)
+			continue
+		}
+
+		for i := range values {
+			if values[i].V != v.values[i] {
+				t.Errorf("asOf %s expected %f got %f", v.asOf, v.values[i], values[i].V)
+			}
+		}
+	}
+
+	// flagging the observation suspect changes only its quality and is in the history.
+	res = post("?reason=contaminated", "test-token", v1JSON, `[{"SiteID": "TEST3", "TypeID": "t1", "MethodID": "m3",
+		"SystemID": "lab", "SampleID": "0001", "DateTime": "1999-01-01T00:00:00Z", "Value": 1.5, "Error": 0.1, "Quality": "suspect"}]`)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+
+	for _, v := range []struct {
+		quality string
+		values  []value
+	}{
+		{quality: "raw,verified"},
+		{quality: "suspect", values: []value{{V: 1.5, E: 0.1, Q: "suspect"}}},
+		{quality: "", values: []value{{V: 1.5, E: 0.1, Q: "suspect"}}},
+	} {
+		path := "/observation?siteID=TEST3&typeID=t1&start=1999-01-01T00:00:00Z&end=1999-01-02T00:00:00Z"
+		if v.quality != "" {
+			path += "&quality=" + v.quality
+		}
+
+		var values []value
+		get(t, path, &values)
+
+		if len(values) != len(v.values) {
+			t.Errorf("quality %q expected %d values got %+v", v.quality, len(v.values), values)
+			continue
+		}
+
+		for i := range values {
+			if values[i].V != v.values[i].V || values[i].E != v.values[i].E || values[i].Q != v.values[i].Q {
+				t.Errorf("quality %q expected %+v got %+v", v.quality, v.values[i], values[i])
+			}
+		}
+	}
+
+	revisions = nil
+	get(t, "/observation/history?siteID=TEST3&typeID=t1&time=1999-01-01T00:00:00Z", &revisions)
+
+	if len(revisions) != 3 {
+		t.Fatalf("expected 3 revisions got %+v", revisions)
+	}
+
+	r2 := revisions[2]
+
+	if *r2.OldQuality != "raw" || *r2.Quality != "suspect" || *r2.Value != 1.5 || r2.Reason != "contaminated" {
+		t.Errorf("unexpected revision for the suspect observation %+v", r2)
+	}
+
+	// a result below the detection limit keeps its qualifier and the detection limit is converted with the value.
+	res = post("?reason=lab+result", "test-token", v1CSV, `siteID, typeID, methodID, date-time, value, error, systemID, sampleID, unit, detectionLimit
+TEST1, t1, m1, 1999-01-03T00:00:00Z, <50, 0, none, none, mm, 50
+`)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200 got %d", res.StatusCode)
+	}
+
+	for _, v := range []struct {
+		unit  string
+		value float64
+	}{
+		{unit: "m", value: 0.05},
+		{unit: "mm", value: 50},
+	} {
+		var values []value
+		get(t, "/observation?siteID=TEST1&typeID=t1&start=1999-01-03T00:00:00Z&end=1999-01-04T00:00:00Z&unit="+v.unit, &values)
+
+		if len(values) != 1 {
+			t.Fatalf("expected 1 value got %+v", values)
+		}
+
+		if values[0].V != v.value || values[0].Qual != "<" || values[0].DL == nil || *values[0].DL != v.value {
+			t.Errorf("unit %s expected <%g with a detection limit of %g got %+v", v.unit, v.value, v.value, values[0])
+		}
+	}
+
+	// censored values aren't used for the stats.
+	res, err = http.Get(testServer.URL + "/observation/stats?siteID=TEST1&typeID=t1&start=1999-01-03T00:00:00Z&end=1999-01-04T00:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 for the stats of censored values got %d", res.StatusCode)
+	}
+
+	revisions = nil
+	get(t, "/observation/history?siteID=TEST1&typeID=t1&time=1999-01-03T00:00:00Z", &revisions)
+
+	if len(revisions) != 1 {
+		t.Fatalf("expected 1 revision got %+v", revisions)
+	}
+
+	r := revisions[0]
+
+	if r.OldQualifier != nil || r.Qualifier == nil || *r.Qualifier != "<" || r.DetectionLimit == nil || *r.DetectionLimit != 0.05 {
+		t.Errorf("unexpected revision for the censored observation %+v", r)
+	}
+}
+
+// get decodes the JSON response for the path into v.
+func get(t *testing.T, path string, v interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", testServer.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", v1JSON)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s expected status 200 got %d", path, res.StatusCode)
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
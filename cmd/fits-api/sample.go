@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// noSystem is the system for observations that are not from a sample
+// e.g., from a continuous instrument.  These use the sample none.
+const noSystem = "none"
+
+// sample returns the samples taken at a site.  The collection time for
+// each sample is the time of the first observation on it.
+func sample(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID"}, []string{"systemID"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	h.Set("Content-Type", "application/json;version=1")
+
+	siteID := q.Get("siteID")
+
+	err = validSite(siteID)
+	if err != nil {
+		return err
+	}
+
+	f := obsFilter{siteID: siteID, systemID: q.Get("systemID")}
+	where, args := f.where()
+
+	var d string
+
+	err = db.QueryRow(
+		`select row_to_json(fc) from (select COALESCE(array_to_json(array_agg(s)), '[]') as sample
+			from (select systemid as "systemID", sampleid as "sampleID",
+				to_char(min(time), 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "collectionTime",
+				array_agg(DISTINCT typeid ORDER BY typeid) as "typeIDs"
+			from fits.observation join fits.type using (typepk)
+			join fits.sample using (samplepk) join fits.system using (systempk)`+where+
+			`AND systemid <> '`+noSystem+`'
+			group by systemid, sampleid
+			order by min(time), systemid, sampleid) as s) as fc`, args...).Scan(&d)
+	if err != nil {
+		return err
+	}
+
+	b.WriteString(d)
+
+	return nil
+}
+
+type sampleObs struct {
+	SystemID     string       `json:"systemID"`
+	SampleID     string       `json:"sampleID"`
+	Columns      []wideColumn `json:"columns"`
+	Observations []wideRow    `json:"observations"`
+}
+
+// sampleObservation returns all the observations on a sample in wide format.
+// There is a row for each site and time and a column for each type and method.
+func sampleObservation(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"systemID", "sampleID"}, []string{"quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	s := sampleObs{
+		SystemID: q.Get("systemID"),
+		SampleID: q.Get("sampleID"),
+	}
+
+	err = validSample(s.SystemID, s.SampleID)
+	if err != nil {
+		return err
+	}
+
+	quality, err := valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return err
+	}
+
+	where, args := obsFilter{systemID: s.SystemID, sampleID: s.SampleID, quality: quality}.where()
+
+	rows, err := db.Query(`SELECT siteid, time, typeid, methodid, symbol, value, error, qualifier, detection_limit
+		FROM fits.observation JOIN fits.site USING (sitepk) JOIN fits.method USING (methodpk)
+		JOIN fits.type USING (typepk) JOIN fits.unit USING (unitpk)`+where, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var o []wideObs
+	columns := make(map[wideColumn]bool)
+
+	for rows.Next() {
+		var d wideObs
+
+		err = rows.Scan(&d.siteID, &d.t, &d.c.TypeID, &d.c.MethodID, &d.c.Unit, &d.v, &d.e, &d.qual, &d.dl)
+		if err != nil {
+			return err
+		}
+
+		columns[d.c] = true
+		o = append(o, d)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for c := range columns {
+		s.Columns = append(s.Columns, c)
+	}
+
+	sort.Slice(s.Columns, func(i, j int) bool {
+		if s.Columns[i].TypeID != s.Columns[j].TypeID {
+			return s.Columns[i].TypeID < s.Columns[j].TypeID
+		}
+		return s.Columns[i].MethodID < s.Columns[j].MethodID
+	})
+
+	s.Observations = wideRows(o, s.Columns)
+
+	switch r.Header.Get("Accept") {
+	case v1JSON:
+		h.Set("Content-Type", v1JSON)
+
+		by, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		b.Write(by)
+	default:
+		h.Set("Content-Type", v1CSV)
+		h.Set("Content-Disposition", `attachment; filename="FITS-`+s.SystemID+`-`+s.SampleID+`.csv"`)
+
+		writeWideCSV(b, s.Columns, s.Observations, true)
+	}
+
+	return nil
+}
+
+// parseSample returns the optional systemID and sampleID query parameters.
+// A sampleID is only unique within a system so systemID must be specified with sampleID.
+// If a system or sample is specified it is checked that it exists.
+func parseSample(q url.Values) (systemID, sampleID string, err error) {
+	systemID = q.Get("systemID")
+	sampleID = q.Get("sampleID")
+
+	switch {
+	case sampleID != "" && systemID == "":
+		err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("systemID must be specified when sampleID is specified")}
+	case systemID != "":
+		err = validSample(systemID, sampleID)
+	}
+
+	return
+}
+
+// validSample checks that the systemID and sampleID exist in the DB.
+// If sampleID is empty only systemID is checked.
+func validSample(systemID, sampleID string) error {
+	var err error
+	var d string
+
+	switch sampleID {
+	case "":
+		err = db.QueryRow("SELECT systemid FROM fits.system WHERE systemid = $1", systemID).Scan(&d)
+	default:
+		err = db.QueryRow("SELECT sampleid FROM fits.sample JOIN fits.system USING (systempk) WHERE systemid = $1 AND sampleid = $2",
+			systemID, sampleID).Scan(&d)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return weft.StatusError{Code: http.StatusNotFound}
+		}
+		return err
+	}
+
+	return nil
+}
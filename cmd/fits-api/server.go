@@ -16,7 +16,9 @@ import (
 
 var (
 	db *sql.DB
-	wm *map180.Map180
+	// dbw is for adding observations.  It is nil unless DB_WRITE_USER is set.
+	dbw *sql.DB
+	wm  *map180.Map180
 )
 
 // These constants represent part of a public API and can't be changed.
@@ -24,6 +26,7 @@ const (
 	v1GeoJSON = "application/vnd.geo+json;version=1"
 	v1JSON    = "application/json;version=1"
 	v1CSV     = "text/csv;version=1"
+	covJSON   = "application/prs.coverage+json"
 	svg       = "image/svg+xml"
 )
 
@@ -34,15 +37,16 @@ func main() {
 		healthCheck()
 	}
 
+	dsn := fmt.Sprintf("host=%s connect_timeout=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_CONN_TIMEOUT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWD"),
+		os.Getenv("DB_NAME"),
+		os.Getenv("DB_SSLMODE"))
+
 	var err error
-	db, err = sql.Open("postgres",
-		fmt.Sprintf("host=%s connect_timeout=%s user=%s password=%s dbname=%s sslmode=%s",
-			os.Getenv("DB_HOST"),
-			os.Getenv("DB_CONN_TIMEOUT"),
-			os.Getenv("DB_USER"),
-			os.Getenv("DB_PASSWD"),
-			os.Getenv("DB_NAME"),
-			os.Getenv("DB_SSLMODE")))
+	db, err = sql.Open("postgres", dsn)
 	if err != nil {
 		log.Fatalf("ERROR: problem with DB config: %s", err)
 	}
@@ -56,6 +60,38 @@ func main() {
 		log.Println("Error: problem pinging DB - is it up and contactable?  500s will be served")
 	}
 
+	// adding observations needs a user that can write and at least one token.
+	if os.Getenv("DB_WRITE_USER") != "" {
+		writers, err = parseWriters(os.Getenv("WRITE_TOKENS"))
+		if err != nil {
+			log.Fatalf("ERROR: problem with WRITE_TOKENS: %s", err)
+		}
+		if len(writers) == 0 {
+			log.Fatal("ERROR: WRITE_TOKENS must be set when DB_WRITE_USER is set")
+		}
+
+		dbw, err = sql.Open("postgres", fmt.Sprintf("host=%s connect_timeout=%s user=%s password=%s dbname=%s sslmode=%s",
+			os.Getenv("DB_HOST"),
+			os.Getenv("DB_CONN_TIMEOUT"),
+			os.Getenv("DB_WRITE_USER"),
+			os.Getenv("DB_WRITE_PASSWD"),
+			os.Getenv("DB_NAME"),
+			os.Getenv("DB_SSLMODE")))
+		if err != nil {
+			log.Fatalf("ERROR: problem with write DB config: %s", err)
+		}
+		defer dbw.Close()
+
+		dbw.SetMaxIdleConns(5)
+		dbw.SetMaxOpenConns(5)
+
+		log.Printf("observations can be added by %d writers", len(writers))
+	}
+
+	// the metadata cache is invalidated when the tables change and can be reloaded with SIGHUP.
+	go listenMetadata(dsn)
+	go refreshMetadataOnHUP()
+
 	// For map zoom regions other than NZ will need to read some config from somewhere.
 	wm, err = map180.Init(db, map180.Region(`newzealand`), 256000000)
 	if err != nil {
@@ -102,6 +138,31 @@ func inbound(h http.Handler) http.Handler {
 		w.Header().Set("Vary", "Accept")
 		w.Header().Set("Surrogate-Control", "max-age=10")
 
-		h.ServeHTTP(w, r)
+		// Answer conditional requests before running the query for the response.
+		// If the version can't be found serve the response without validators.
+		var v *version
+		if r.Method == "GET" && conditionalPaths[r.URL.Path] && !relative(r.URL.Path, r.URL.Query()) {
+			d, err := dataVersion(r)
+			switch {
+			case err != nil:
+				log.Printf("finding data version for %s: %s", r.URL.Path, err)
+			case d.notModified(r):
+				d.setHeaders(w.Header())
+				w.WriteHeader(http.StatusNotModified)
+				return
+			default:
+				v = &d
+			}
+		}
+
+		rw := newResponseWriter(w, r, v)
+
+		// a streamed response that fails panics with http.ErrAbortHandler so this is not
+		// deferred.  That leaves the compressed body incomplete which is what we want.
+		h.ServeHTTP(rw, r)
+
+		if err := rw.close(); err != nil {
+			log.Printf("completing compressed response for %s: %s", r.URL.Path, err)
+		}
 	})
 }
@@ -2,18 +2,56 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/GeoNet/fits/internal/valid"
 	"github.com/GeoNet/kit/weft"
+	"github.com/lib/pq"
 )
 
-const (
-	siteGeoJSON = `SELECT row_to_json(fc)
-                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
+const fc = ` ) As f )  as fc`
+
+// siteInventory is the inventory property for a site.  quality is the argument for the
+// qualities of the observations in the inventory, or empty for any quality.
+func siteInventory(quality string) string {
+	where := ` WHERE sitepk = s.sitepk`
+	if quality != "" {
+		where += ` AND quality = ANY(` + quality + `)`
+	}
+
+	return `, (SELECT COALESCE(json_agg(i), '[]') FROM (` + inventorySQL + where + inventoryGroup + `) AS i) AS inventory`
+}
+
+// parseSiteInventory returns true if the sites should have the inventory and the qualities
+// of the observations in it.  quality can only be used with inventory.
+func parseSiteInventory(q url.Values) (inv bool, quality []string, err error) {
+	inv, err = valid.ParseInventory(q.Get("inventory"))
+	if err != nil {
+		return
+	}
+
+	quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return
+	}
+
+	if len(quality) > 0 && !inv {
+		err = weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("quality requires inventory")}
+	}
+
+	return
+}
+
+// siteGeoJSON returns the query for a GeoJSON FeatureCollection of sites up to the
+// FROM clause (fits.site as s).  props are extra properties each with a leading comma and
+// features aggregates the features f e.g., array_agg(f).  Complete the query with fc.
+func siteGeoJSON(props, features string) string {
+	return `SELECT row_to_json(fc)
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(` + features + `), '[]') as features
                          FROM (SELECT 'Feature' as type,
                          ST_AsGeoJSON(s.location)::json as geometry,
                          row_to_json((SELECT l FROM 
@@ -22,14 +60,13 @@ const (
                          		siteid AS "siteID",
                                 height,
                                 ground_relationship AS "groundRelationship",
-                                name
+                                name` + props + `
                            ) as l
                          )) as properties FROM fits.site as s `
-	fc = ` ) As f )  as fc`
-)
+}
 
 func site(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID"}, []string{"networkID"}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID"}, []string{"networkID", "inventory", "quality"}, valid.Query)
 	if err != nil {
 		return err
 	}
@@ -38,16 +75,17 @@ func site(r *http.Request, h http.Header, b *bytes.Buffer) error {
 
 	siteID := q.Get("siteID")
 
-	var d string
+	inv, quality, err := parseSiteInventory(q)
+	if err != nil {
+		return err
+	}
 
-	if err := db.QueryRow("select siteID FROM fits.site where siteid = $1", siteID).Scan(&d); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
-		}
+	err = validSite(siteID)
+	if err != nil {
 		return err
 	}
 
-	by, err := geoJSONSite(siteID)
+	by, err := geoJSONSite(siteID, inv, quality)
 	if err != nil {
 		return err
 	}
@@ -58,7 +96,7 @@ func site(r *http.Request, h http.Header, b *bytes.Buffer) error {
 }
 
 func siteType(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{}, []string{"typeID", "methodID", "within"}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{}, []string{"typeID", "methodID", "within", "near", "radius", "limit", "inventory", "quality"}, valid.Query)
 	if err != nil {
 		return err
 	}
@@ -96,7 +134,17 @@ func siteType(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		}
 	}
 
-	by, err := geoJSONSites(typeID, methodID, within)
+	n, err := parseNear(q)
+	if err != nil {
+		return err
+	}
+
+	inv, quality, err := parseSiteInventory(q)
+	if err != nil {
+		return err
+	}
+
+	by, err := geoJSONSites(typeID, methodID, within, n, inv, quality)
 	if err != nil {
 		return err
 	}
@@ -106,69 +154,159 @@ func siteType(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	return nil
 }
 
-// validSite checks that the siteID exists in the DB.
-func validSite(siteID string) error {
-	var d string
+// siteSearchLimit is the default number of sites returned by siteSearch.
+const siteSearchLimit = 20
+
+// siteSearch finds sites by name or siteID.  The match ignores case and accents and is ranked
+// by trigram word similarity so that part of a name e.g., Ruapehu, matches.
+func siteSearch(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"q"}, []string{"typeID", "limit"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	h.Set("Content-Type", v1GeoJSON)
+
+	limit, err := valid.ParseLimit(q.Get("limit"))
+	if err != nil {
+		return err
+	}
+
+	if limit == 0 {
+		limit = siteSearchLimit
+	}
 
-	if err := db.QueryRow("select siteID FROM fits.site WHERE siteid = $1",
-		siteID).Scan(&d); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
+	typeID := q.Get("typeID")
+
+	if typeID != "" {
+		err = validType(typeID)
+		if err != nil {
+			return err
 		}
+	}
+
+	by, err := geoJSONSiteSearch(strings.TrimSpace(q.Get("q")), typeID, limit)
+	if err != nil {
 		return err
 	}
 
+	b.Write(by)
+
 	return nil
 }
 
-func geoJSONSite(siteID string) ([]byte, error) {
+// validSite checks that the siteID exists.
+func validSite(siteID string) error {
+	_, err := getSite(siteID)
+	return err
+}
+
+// geoJSONSite returns a GeoJSON FeatureCollection for siteID.  If inv is true
+// the feature has the inventory for the site of the observations with any of quality.
+func geoJSONSite(siteID string, inv bool, quality []string) ([]byte, error) {
+	args := []interface{}{siteID}
+
+	var props string
+	if inv {
+		var qa string
+		if len(quality) > 0 {
+			args = append(args, pq.Array(quality))
+			qa = `$2`
+		}
+		props = siteInventory(qa)
+	}
+
 	var d string
 	err := db.QueryRow(
-		siteGeoJSON+` WHERE siteid = $1`+fc, siteID).Scan(&d)
+		siteGeoJSON(props, `array_agg(f)`)+` WHERE siteid = $1`+fc, args...).Scan(&d)
 
 	return []byte(d), err
 }
 
-func geoJSONSites(typeID, methodID, within string) ([]byte, error) {
-	var d string
-	var err error
+/*
+geoJSONSites returns a GeoJSON FeatureCollection of the sites with observations of typeID
+and methodID that are within the polygon and near the point.  Empty strings and a zero n
+do not restrict the sites.  If n is enabled the features have the distance (km) to the site
+and are sorted by it.  If inv is true the features have the inventory for the site of the observations
+with any of quality.
+*/
+func geoJSONSites(typeID, methodID, within string, n near, inv bool, quality []string) ([]byte, error) {
+	var c []string
+	var args []interface{}
+
+	arg := func(a interface{}) string {
+		args = append(args, a)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if typeID != "" {
+		o := `observation.typepk = (select typepk from fits.type where typeid = ` + arg(typeID) + `)`
+		if methodID != "" {
+			o += ` AND observation.methodpk = (select methodpk from fits.method where methodid = ` + arg(methodID) + `)`
+		}
+		c = append(c, `sitepk IN (select distinct on (sitepk) sitepk from fits.observation where `+o+`)`)
+	}
 
+	// the longitudes are only shifted when there is a type, as they always have been.
 	switch {
-	case typeID == "" && methodID == "" && within == "":
-		err = db.QueryRow(
-			siteGeoJSON + fc).Scan(&d)
-	case typeID == "" && methodID == "" && within != "":
-		err = db.QueryRow(
-			siteGeoJSON+
-				`where ST_Within(location::geometry, ST_GeomFromText($1, 4326))`+
-				fc, within).Scan(&d)
-	case typeID != "" && methodID == "" && within == "":
-		err = db.QueryRow(
-			siteGeoJSON+
-				` where sitepk IN
-(select distinct on (sitepk) sitepk from fits.observation where observation.typepk = (select typepk from fits.type where typeid = $1))`+fc, typeID).Scan(&d)
-	case typeID != "" && methodID == "" && within != "":
-		err = db.QueryRow(
-			siteGeoJSON+
-				` where sitepk IN
-(select distinct on (sitepk) sitepk from fits.observation where observation.typepk = (select typepk from fits.type where typeid = $1)) 
- AND ST_Within(ST_ShiftLongitude(location::geometry), ST_ShiftLongitude(ST_GeomFromText($2, 4326)))`+fc, typeID, within).Scan(&d)
-	case typeID != "" && methodID != "" && within == "":
-		err = db.QueryRow(
-			siteGeoJSON+
-				` where sitepk IN
-(select distinct on (sitepk) sitepk from fits.observation where 
-	observation.typepk = (select typepk from fits.type where typeid = $1)
-	AND observation.methodpk = (select methodpk from fits.method where methodid = $2))`+fc, typeID, methodID).Scan(&d)
-	case typeID != "" && methodID != "" && within != "":
-		err = db.QueryRow(
-			siteGeoJSON+
-				` where sitepk IN
-(select distinct on (sitepk) sitepk from fits.observation where 
-	observation.typepk = (select typepk from fits.type where typeid = $1)
-	AND observation.methodpk = (select methodpk from fits.method where methodid = $2))
-		 AND ST_Within(ST_ShiftLongitude(location::geometry), ST_ShiftLongitude(ST_GeomFromText($3, 4326)))`+fc, typeID, methodID, within).Scan(&d)
+	case within != "" && typeID != "":
+		c = append(c, `ST_Within(ST_ShiftLongitude(location::geometry), ST_ShiftLongitude(ST_GeomFromText(`+arg(within)+`, 4326)))`)
+	case within != "":
+		c = append(c, `ST_Within(location::geometry, ST_GeomFromText(`+arg(within)+`, 4326))`)
 	}
 
+	var props string
+	features := `array_agg(f)`
+
+	if n.enabled() {
+		// the nearest sites are counted after the other conditions are applied.
+		c = []string{`sitepk IN (` + n.sites(arg, c...) + `)`}
+		props = `, ` + n.distance(arg) + ` AS distance`
+		features = `array_agg(f ORDER BY (f.properties->>'distance')::float8)`
+	}
+
+	if inv {
+		var qa string
+		if len(quality) > 0 {
+			qa = arg(pq.Array(quality))
+		}
+		props += siteInventory(qa)
+	}
+
+	var where string
+	if len(c) > 0 {
+		where = ` WHERE ` + strings.Join(c, ` AND `)
+	}
+
+	var d string
+
+	err := db.QueryRow(siteGeoJSON(props, features)+where+fc, args...).Scan(&d)
+
+	return []byte(d), err
+}
+
+// geoJSONSiteSearch returns a GeoJSON FeatureCollection of up to limit sites that match text,
+// best match first.  If typeID is not empty only sites with observations of the type are matched.
+// The features have the match score (0-1).
+func geoJSONSiteSearch(text, typeID string, limit int) ([]byte, error) {
+	args := []interface{}{text, limit}
+
+	score := `greatest(word_similarity(fits.search_text($1), fits.search_text(name)), word_similarity(fits.search_text($1), fits.search_text(siteid)))`
+
+	c := `(fits.search_text($1) <% fits.search_text(name)
+		OR fits.search_text($1) <% fits.search_text(siteid)
+		OR fits.search_text(name) LIKE '%' || fits.search_text($1) || '%'
+		OR fits.search_text(siteid) LIKE fits.search_text($1) || '%')`
+
+	if typeID != "" {
+		args = append(args, typeID)
+		c += ` AND sitepk IN (select distinct on (sitepk) sitepk from fits.observation where observation.typepk = (select typepk from fits.type where typeid = $3))`
+	}
+
+	var d string
+
+	err := db.QueryRow(siteGeoJSON(`, `+score+` AS score`, `array_agg(f ORDER BY (f.properties->>'score')::float8 DESC, f.properties->>'siteID')`)+
+		` WHERE sitepk IN (SELECT sitepk FROM fits.site WHERE `+c+` ORDER BY `+score+` DESC, siteid LIMIT $2)`+fc, args...).Scan(&d)
+
 	return []byte(d), err
 }
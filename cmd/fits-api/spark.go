@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"net/http"
-	"time"
 
 	"github.com/GeoNet/fits/internal/ts"
 	"github.com/GeoNet/fits/internal/valid"
@@ -11,14 +10,19 @@ import (
 )
 
 func spark(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "yrange", "type", "stddev", "label", "networkID"}, valid.Query)
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "start", "end", "yrange", "type", "stddev", "label", "outliers", "threshold", "transform", "epoch", "networkID", "unit", "quality"}, valid.Query)
 	if err != nil {
 		return err
 	}
 
 	h.Set("Content-Type", "image/svg+xml")
 
-	days, err := valid.ParseDays(q.Get("days"))
+	start, end, err := parseWindow(q)
+	if err != nil {
+		return err
+	}
+
+	quality, err := valid.ParseQuality(q.Get("quality"))
 	if err != nil {
 		return err
 	}
@@ -28,6 +32,16 @@ func spark(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		return err
 	}
 
+	o, err := parseOutliers(q)
+	if err != nil {
+		return err
+	}
+
+	tr, err := parseTransform(q)
+	if err != nil {
+		return err
+	}
+
 	t, err := getType(q.Get("typeID"))
 	if err != nil {
 		return err
@@ -38,15 +52,15 @@ func spark(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		return err
 	}
 
-	var p plt
-	var tmin time.Time
-
-	if days > 0 {
-		n := time.Now().UTC()
-		tmin = n.Add(time.Duration(days*-1) * time.Hour * 24)
-		p.SetXAxis(tmin, n)
-		days = 0 // add all data > than tmin
+	c, err := parseUnit(q, t.unit)
+	if err != nil {
+		return err
 	}
+	t.unit = c.symbol(t.unit)
+
+	p := plt{transform: tr, outliers: o}
+
+	p.setXAxis(start, end)
 
 	switch {
 	case ymin == 0 && ymax == 0:
@@ -56,16 +70,18 @@ func spark(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		p.SetYAxis(ymin, ymax)
 	}
 
-	p.SetUnit(t.unit)
+	p.SetUnit(tr.Unit(t.unit))
+
+	f := obsFilter{siteID: s.siteID, typeID: t.typeID, start: start, end: end, unit: c, quality: quality}
 
 	if q.Get("stddev") == `pop` {
-		err = p.setStddevPop(s, t, tmin, days)
+		err = p.setStddevPop(f)
 	}
 	if err != nil {
 		return err
 	}
 
-	err = p.addSeries(t, tmin, days, s)
+	err = p.addSeries(f, s)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -11,27 +11,41 @@ import (
 	"time"
 
 	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/map180"
 	"github.com/GeoNet/kit/weft"
 )
 
-func spatialObs(r *http.Request, h http.Header, b *bytes.Buffer) error {
-	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"typeID", "days", "start"}, []string{"srsName", "within", "methodID"}, valid.Query)
+// spatialRowBudget is the most observations spatialObs will return.  This protects
+// against large responses for dense types while sparse types can be fetched for long
+// time windows.
+var spatialRowBudget = 250000
+
+// spatialObs streams observations for all sites as CSV or GeoJSON.
+func spatialObs(r *http.Request, w http.ResponseWriter) (int64, error) {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"typeID", "days", "start"}, []string{"srsName", "within", "bbox", "methodID", "near", "radius", "limit", "quality"}, valid.Query)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	h.Set("Content-Type", "text/csv;version=1")
+	h := w.Header()
 
-	var days int
+	geoJSON := r.Header.Get("Accept") == v1GeoJSON
 
-	days, err = strconv.Atoi(q.Get("days"))
-	if err != nil || days > 7 || days <= 0 {
-		return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid days query param")}
+	switch geoJSON {
+	case true:
+		h.Set("Content-Type", v1GeoJSON)
+	case false:
+		h.Set("Content-Type", v1CSV)
+	}
+
+	days, err := valid.ParseDays(q.Get("days"))
+	if err != nil || days <= 0 {
+		return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid days query param")}
 	}
 
 	start, err := time.Parse(time.RFC3339, q.Get("start"))
 	if err != nil {
-		return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid start query param")}
+		return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid start query param")}
 	}
 
 	end := start.Add(time.Duration(days) * time.Hour * 24)
@@ -42,123 +56,221 @@ func spatialObs(r *http.Request, h http.Header, b *bytes.Buffer) error {
 		srsName = q.Get("srsName")
 		srs := strings.Split(srsName, ":")
 		if len(srs) != 2 {
-			return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid srsName")}
+			return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid srsName")}
 		}
 		authName = srs[0]
 		var err error
 		srid, err = strconv.Atoi(srs[1])
 		if err != nil {
-			return weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid srsName")}
+			return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("invalid srsName")}
 		}
 		err = validSrs(authName, srid)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	} else {
 		srid = 4326
 		srsName = "EPSG:4326"
 	}
 
-	typeID := q.Get("typeID")
+	if geoJSON && srid != 4326 {
+		return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("GeoJSON is only available for EPSG:4326")}
+	}
+
+	f := obsFilter{typeID: q.Get("typeID"), start: start}
+
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return 0, err
+	}
 
-	var methodID string
 	if q.Get("methodID") != "" {
-		methodID = q.Get("methodID")
-		err = validTypeMethod(typeID, methodID)
+		f.methodID = q.Get("methodID")
+		err = validTypeMethod(f.typeID, f.methodID)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	var within string
-	if q.Get("within") != "" {
-		within = strings.Replace(q.Get("within"), "+", "", -1)
-		err = validPoly(within)
+	switch {
+	case q.Get("within") != "" && q.Get("bbox") != "":
+		return 0, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("specify only one of within and bbox")}
+	case q.Get("within") != "":
+		f.within = strings.Replace(q.Get("within"), "+", "", -1)
+		err = validPoly(f.within)
+		if err != nil {
+			return 0, err
+		}
+	case q.Get("bbox") != "":
+		f.within, err = map180.BboxToWKTPolygon(q.Get("bbox"))
 		if err != nil {
-			return err
+			return 0, weft.StatusError{Code: http.StatusBadRequest, Err: err}
 		}
 	}
 
-	var unit string
-	if err = db.QueryRow("select symbol FROM fits.type join fits.unit using (unitPK) where typeID = $1", typeID).Scan(&unit); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
-		}
-		return err
+	f.near, err = parseNear(q)
+	if err != nil {
+		return 0, err
 	}
 
-	var d string
-	var rows *sql.Rows
+	t, err := getType(f.typeID)
+	if err != nil {
+		return 0, err
+	}
+	unit := t.unit
 
-	switch {
-	case within == "" && methodID == "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s,%s,%s,%s,%s,%s', siteid,
-		ST_X(ST_Transform(location::geometry, $4::Integer)), ST_Y(ST_Transform(location::geometry, $4::Integer)),
-		height,ground_relationship, to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) 
-		as csv FROM fits.observation join fits.site using (sitepk)
-		WHERE typepk = (SELECT typepk FROM fits.type WHERE typeid = $1) AND 
-		time >= $2 and time < $3 order by siteid asc`, typeID, start, end, srid)
-	case within != "" && methodID == "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s,%s,%s,%s,%s,%s', siteid,
-		ST_X(ST_Transform(location::geometry, $4::Integer)), ST_Y(ST_Transform(location::geometry, $4::Integer)),
-		height,ground_relationship, to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) 
-		as csv FROM fits.observation join fits.site using (sitepk)
-		WHERE typepk = (SELECT typepk FROM fits.type WHERE typeid = $1) 
-		AND  ST_Within(location::geometry, ST_GeomFromText($5, 4326))
-		AND time >= $2 and time < $3 order by siteid asc`, typeID, start, end, srid, within)
-
-	case within == "" && methodID != "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s,%s,%s,%s,%s,%s', siteid,
-		ST_X(ST_Transform(location::geometry, $4::Integer)), ST_Y(ST_Transform(location::geometry, $4::Integer)),
-		height,ground_relationship, to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) 
-		as csv FROM fits.observation join fits.site using (sitepk)
-		WHERE typepk = (SELECT typepk FROM fits.type WHERE typeid = $1) 
-		AND methodpk = (SELECT methodpk FROM fits.method WHERE methodid = $5)
-		AND time >= $2 and time < $3 order by siteid asc`, typeID, start, end, srid, methodID)
-	case within != "" && methodID != "":
-		rows, err = db.Query(
-			`SELECT format('%s,%s,%s,%s,%s,%s,%s,%s', siteid,
-		ST_X(ST_Transform(location::geometry, $4::Integer)), ST_Y(ST_Transform(location::geometry, $4::Integer)),
-		height,ground_relationship, to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error) 
-		as csv FROM fits.observation join fits.site using (sitepk)
-		WHERE typepk = (SELECT typepk FROM fits.type WHERE typeid = $1) 
-		AND methodpk = (SELECT methodpk FROM fits.method WHERE methodid = $6)
-		AND  ST_Within(location::geometry, ST_GeomFromText($5, 4326))
-		AND time >= $2 and time < $3 order by siteid asc`, typeID, start, end, srid, within, methodID)
+	// the window is start inclusive and end exclusive.
+	where, args := f.where()
+	args = append(args, end)
+	where += fmt.Sprintf("AND time < $%d ", len(args))
+
+	err = checkSpatialBudget(where, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if geoJSON {
+		return spatialObsGeoJSON(where, args, unit, f.near, w)
 	}
+
+	arg := func(a interface{}) string {
+		args = append(args, a)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	srs := arg(srid)
+
+	order := `siteid asc, time asc`
+	if f.near.enabled() {
+		order = f.near.distance(arg) + `, ` + order
+	}
+
+	var d string
+
+	rows, err := db.Query(
+		`SELECT format('%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s', siteid,
+		ST_X(ST_Transform(location::geometry, `+srs+`::Integer)), ST_Y(ST_Transform(location::geometry, `+srs+`::Integer)),
+		height,ground_relationship, to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'), value, error, quality, qualifier, detection_limit)
+		as csv FROM fits.observation join fits.site using (sitepk)`+where+`order by `+order, args...)
 	if err != nil {
 		// not sure what a transformation error would look like.
 		// Return any errors as a 404.  Could improve this by inspecting
 		// the error type to check for net dial errors that should 503.
 		log.Println("## error execute query", err)
-		return weft.StatusError{Code: http.StatusNotFound}
+		return 0, weft.StatusError{Code: http.StatusNotFound}
 	}
 	defer rows.Close()
 
-	b.Write([]byte("siteID, X (" + srsName + "), Y (" + srsName + "), height, groundRelationship, date-time, " + typeID + " (" + unit + "), error (" + unit + ")"))
-	b.Write(eol)
+	if f.methodID != "" {
+		h.Set("Content-Disposition", `attachment; filename="FITS-`+f.typeID+`-`+f.methodID+`.csv"`)
+	} else {
+		h.Set("Content-Disposition", `attachment; filename="FITS-`+f.typeID+`.csv"`)
+	}
+
+	st := newStream(w)
+
+	st.WriteString("siteID, X (" + srsName + "), Y (" + srsName + "), height, groundRelationship, date-time, " + f.typeID + " (" + unit + "), error (" + unit + "), quality, qualifier, detection limit (" + unit + ")")
+	st.Write(eol)
 	for rows.Next() {
 		err := rows.Scan(&d)
 		if err != nil {
-			return err
+			return st.Fail(err)
 		}
-		b.Write([]byte(d))
-		b.Write(eol)
+		st.WriteString(d)
+		st.Write(eol)
+	}
+	if err = rows.Err(); err != nil {
+		return st.Fail(err)
 	}
-	rows.Close()
 
-	if methodID != "" {
-		h.Set("Content-Disposition", `attachment; filename="FITS-`+typeID+`-`+methodID+`.csv"`)
-	} else {
-		h.Set("Content-Disposition", `attachment; filename="FITS-`+typeID+`.csv"`)
+	return st.Close()
+}
+
+// checkSpatialBudget returns an error if more than spatialRowBudget observations
+// match where.  The count stops at the budget so it is cheap for large windows.
+func checkSpatialBudget(where string, args []interface{}) error {
+	var n int
+
+	err := db.QueryRow(`SELECT count(*) FROM (SELECT 1 FROM fits.observation`+where+`LIMIT $`+strconv.Itoa(len(args)+1)+`) AS o`,
+		append(args, spatialRowBudget+1)...).Scan(&n)
+	if err != nil {
+		return err
+	}
+
+	if n > spatialRowBudget {
+		return weft.StatusError{Code: http.StatusBadRequest,
+			Err: fmt.Errorf("more than %d observations match the query, use fewer days or a smaller area", spatialRowBudget)}
 	}
 
 	return nil
 }
 
+// spatialObsGeoJSON streams a GeoJSON FeatureCollection to w with a feature for each site
+// that has observations matching where.  The observations are a property of the site.
+// If n is enabled the features have the distance (km) to the site and are sorted by it.
+func spatialObsGeoJSON(where string, args []interface{}, unit string, n near, w http.ResponseWriter) (int64, error) {
+	arg := func(a interface{}) string {
+		args = append(args, a)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	u := arg(unit)
+
+	var distance string
+	order := `f.properties->>'siteID'`
+	if n.enabled() {
+		distance = `'distance', ` + n.distance(arg) + `,`
+		order = `(f.properties->>'distance')::float8, ` + order
+	}
+
+	rows, err := db.Query(`SELECT row_to_json(f)
+		FROM (SELECT 'Feature' as type,
+			ST_AsGeoJSON(s.location)::json as geometry,
+			json_build_object(
+				'siteID', siteid,
+				'name', name,
+				'height', height,
+				'groundRelationship', ground_relationship,
+				'unit', `+u+`::text,`+distance+`
+				'observations', o.observations) as properties
+			FROM fits.site as s
+			JOIN (SELECT sitepk, json_agg(json_build_object(
+				'DateTime', to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'),
+				'Value', value,
+				'Error', error,
+				'Quality', quality,
+				'Qualifier', qualifier,
+				'DetectionLimit', detection_limit) ORDER BY time) as observations
+				FROM fits.observation`+where+`GROUP BY sitepk) as o USING (sitepk)
+		) As f ORDER BY `+order, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	st := newStream(w)
+
+	st.WriteString(`{"type":"FeatureCollection","features":[`)
+
+	var d string
+	for i := 0; rows.Next(); i++ {
+		if err = rows.Scan(&d); err != nil {
+			return st.Fail(err)
+		}
+		if i > 0 {
+			st.WriteString(",")
+		}
+		st.WriteString(d)
+	}
+	if err = rows.Err(); err != nil {
+		return st.Fail(err)
+	}
+
+	st.WriteString("]}")
+
+	return st.Close()
+}
+
 // validSrs checks that the srs represented by auth and srid exists in the DB.
 func validSrs(auth string, srid int) error {
 	var d string
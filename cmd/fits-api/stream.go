@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+)
+
+// streamBufferSize is the amount of data buffered before it is sent to the client.
+// Errors before the first send can still be returned with an error status.
+const streamBufferSize = 32 * 1024
+
+/*
+stream writes a response directly to an http.ResponseWriter using chunked encoding.
+This avoids holding large responses in memory.
+
+Nothing is sent to the client until the buffer fills or Flush is called.  Until then
+an error can be returned to weft for the usual error response.  After that the status
+has been sent and Fail aborts the response so the client sees a truncated body
+instead of an apparently complete one.
+
+Set all headers before writing.
+*/
+type stream struct {
+	w    http.ResponseWriter
+	buf  *bufio.Writer
+	n    int64
+	sent bool
+}
+
+func newStream(w http.ResponseWriter) *stream {
+	s := &stream{w: w}
+	s.buf = bufio.NewWriterSize(sender{s}, streamBufferSize)
+	return s
+}
+
+// sender is the io.Writer for the buffer.
+type sender struct {
+	s *stream
+}
+
+func (d sender) Write(p []byte) (int, error) {
+	d.s.sent = true
+	n, err := d.s.w.Write(p)
+	d.s.n += int64(n)
+	return n, err
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *stream) WriteString(str string) (int, error) {
+	return s.buf.WriteString(str)
+}
+
+// Flush sends any buffered data to the client.
+func (s *stream) Flush() error {
+	err := s.buf.Flush()
+	if err != nil {
+		return err
+	}
+
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// Close flushes the stream and returns the number of bytes written to the client.
+// Use as the return value for a weft.DirectRequestHandler.
+func (s *stream) Close() (int64, error) {
+	err := s.Flush()
+	if err != nil {
+		return s.Fail(err)
+	}
+
+	return s.n, nil
+}
+
+// Fail handles an error while streaming.  If nothing has been sent then
+// err is returned for weft to send an error response.  Otherwise the
+// response is aborted.
+func (s *stream) Fail(err error) (int64, error) {
+	if !s.sent {
+		s.buf.Reset(sender{s})
+		s.w.Header().Del("Content-Disposition")
+		return 0, err
+	}
+
+	log.Printf("aborting response after %d bytes: %s", s.n, err)
+	panic(http.ErrAbortHandler)
+}
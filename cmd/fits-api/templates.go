@@ -20,14 +20,17 @@ var (
 
 	chartsTemplate           = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/charts.html"))
 	apidocsTemplate          = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/index.html"))
+	inventoryTemplate        = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/inventory.html"))
 	mapTemplate              = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/map.html"))
 	methodTemplate           = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/method.html"))
 	observationTemplate      = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/observation.html"))
 	observationStatsTemplate = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/observation_stats.html"))
 	plotTemplate             = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/plot.html"))
+	sampleTemplate           = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/sample.html"))
 	siteTemplate             = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/site.html"))
 	sparkTemplate            = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/spark.html"))
 	typeTemplate             = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/type.html"))
+	visualTemplate           = template.Must(template.New("t").Funcs(funcMap).ParseFiles("assets/border.html", "assets/api-docs/endpoint/visual_observation.html"))
 )
 
 //go:embed assets/assets/images/logo.svg
@@ -94,6 +97,9 @@ func apidocsHandler(r *http.Request, h http.Header, b *bytes.Buffer, nonce strin
 	switch r.URL.String() {
 	case "", "index.html":
 		t = apidocsTemplate
+	case "endpoint/inventory":
+		t = inventoryTemplate
+		p.Title = p.Title + " - Inventory"
 	case "endpoint/map":
 		t = mapTemplate
 		p.Title = p.Title + " - Map"
@@ -109,6 +115,9 @@ func apidocsHandler(r *http.Request, h http.Header, b *bytes.Buffer, nonce strin
 	case "endpoint/plot":
 		t = plotTemplate
 		p.Title = p.Title + " - Plot"
+	case "endpoint/sample":
+		t = sampleTemplate
+		p.Title = p.Title + " - Sample"
 	case "endpoint/site":
 		t = siteTemplate
 		p.Title = p.Title + " - Site"
@@ -118,6 +127,9 @@ func apidocsHandler(r *http.Request, h http.Header, b *bytes.Buffer, nonce strin
 	case "endpoint/type":
 		t = typeTemplate
 		p.Title = p.Title + " - Type"
+	case "endpoint/visual_observation":
+		t = visualTemplate
+		p.Title = p.Title + " - Visual Observation"
 	default:
 		return weft.StatusError{Code: http.StatusNotFound}
 	}
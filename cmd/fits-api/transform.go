@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/GeoNet/fits/internal/transform"
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// parseTransform returns the transform from the optional transform and epoch query parameters.
+func parseTransform(q url.Values) (transform.Transform, error) {
+	var t transform.Transform
+	var err error
+
+	t.Name = q.Get("transform")
+
+	t.Epoch, err = valid.ParseEpoch(q.Get("epoch"))
+	if err != nil {
+		return t, err
+	}
+
+	if !t.Epoch.IsZero() && t.Name != transform.Relative {
+		return t, weft.StatusError{Code: http.StatusBadRequest, Err: errors.New("epoch requires transform=relative")}
+	}
+
+	return t, nil
+}
+
+// transformValues returns values transformed with t.  values must be in time order.
+// For transforms between successive values the method, system, and sample are
+// for the later value.
+func transformValues(values []value, t transform.Transform) ([]value, error) {
+	if !t.Enabled() {
+		return values, nil
+	}
+
+	in := make([]transform.Point, len(values))
+	for i, v := range values {
+		in[i] = transform.Point{DateTime: v.T, Value: v.V, Error: v.E}
+	}
+
+	out, index, err := t.Apply(in)
+	if err != nil {
+		return nil, err
+	}
+
+	tv := make([]value, len(out))
+	for i, p := range out {
+		tv[i] = values[index[i]]
+		tv[i].T, tv[i].V, tv[i].E = p.DateTime, p.Value, p.Error
+		// the detection limit is for the observation not the transformed value.
+		tv[i].DL = nil
+	}
+
+	return tv, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/GeoNet/fits/internal/stats"
+	"github.com/GeoNet/fits/internal/ts"
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// observationTrend returns a weighted least squares fit of a line (and optionally
+// seasonal terms and steps) to the observations at a site.
+func observationTrend(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID", "typeID"}, []string{"days", "start", "end", "methodID", "annual", "semiAnnual", "steps", "quality"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	h.Set("Content-Type", v1JSON)
+
+	m, err := parseTrendModel(q)
+	if err != nil {
+		return err
+	}
+
+	t, err := getType(q.Get("typeID"))
+	if err != nil {
+		return err
+	}
+
+	f := obsFilter{siteID: q.Get("siteID"), typeID: t.typeID}
+
+	err = validSite(f.siteID)
+	if err != nil {
+		return err
+	}
+
+	f.start, f.end, err = parseWindow(q)
+	if err != nil {
+		return err
+	}
+
+	f.quality, err = valid.ParseQuality(q.Get("quality"))
+	if err != nil {
+		return err
+	}
+
+	if q.Get("methodID") != "" {
+		f.methodID = q.Get("methodID")
+		err = validTypeMethod(f.typeID, f.methodID)
+		if err != nil {
+			return err
+		}
+	}
+
+	values, err := loadObs(f, resample{})
+	if err != nil {
+		return err
+	}
+
+	// censored values are not used for the fit.
+	values, censored := uncensored(values)
+
+	tr, err := fitTrend(values, m)
+	if err != nil {
+		return err
+	}
+
+	by, err := json.Marshal(struct {
+		stats.Trend
+		Censored int
+		Unit     string
+	}{Trend: tr, Censored: censored, Unit: t.unit})
+	if err != nil {
+		return err
+	}
+
+	b.Write(by)
+
+	return nil
+}
+
+// parseTrendModel returns the model from the optional annual, semiAnnual, and steps query parameters.
+func parseTrendModel(q url.Values) (m stats.TrendModel, err error) {
+	m.Annual, err = valid.ParseAnnual(q.Get("annual"))
+	if err != nil {
+		return
+	}
+
+	m.SemiAnnual, err = valid.ParseSemiAnnual(q.Get("semiAnnual"))
+	if err != nil {
+		return
+	}
+
+	m.Steps, err = valid.ParseSteps(q.Get("steps"))
+
+	return
+}
+
+// fitTrend fits m to values.  Returns a 404 if there are not enough values and a 400 if
+// the model can't be fitted e.g., a step is outside the values.
+func fitTrend(values []value, m stats.TrendModel) (stats.Trend, error) {
+	t := make([]time.Time, len(values))
+	v := make([]float64, len(values))
+	e := make([]float64, len(values))
+
+	for i := range values {
+		t[i] = values[i].T
+		v[i] = values[i].V
+		e[i] = values[i].E
+	}
+
+	tr, err := stats.FitTrend(t, v, e, m)
+	switch err {
+	case nil:
+		return tr, nil
+	case stats.ErrTooFewPoints:
+		return tr, weft.StatusError{Code: http.StatusNotFound, Err: err}
+	case stats.ErrSingular:
+		return tr, weft.StatusError{Code: http.StatusBadRequest, Err: err}
+	default:
+		return tr, err
+	}
+}
+
+// setTrend draws the trend for the observations selected by f on the plot.
+// Censored observations are not used.  Nothing is drawn if there are not enough
+// observations to fit the model.
+func (plt *plt) setTrend(f obsFilter, m stats.TrendModel, unit string) error {
+	values, err := loadObs(f, resample{})
+	if err != nil {
+		return err
+	}
+
+	values, _ = uncensored(values)
+
+	tr, err := fitTrend(values, m)
+	if err != nil {
+		if e, ok := err.(weft.StatusError); ok && e.Code == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	s := ts.Series{Label: fmt.Sprintf("rate: %.3f %s/yr", tr.Slope, unit)}
+
+	for _, v := range values {
+		s.Points = append(s.Points, ts.Point{DateTime: v.T, Value: tr.Value(v.T)})
+	}
+
+	plt.SetTrend(s)
+
+	return nil
+}
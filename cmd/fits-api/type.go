@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"net/http"
 
 	"github.com/GeoNet/fits/internal/valid"
@@ -32,33 +31,25 @@ func types(r *http.Request, h http.Header, b *bytes.Buffer) error {
 	return nil
 }
 
-// validType checks that the typeID exists in the DB.
+// validType checks that the typeID exists.
 func validType(typeID string) error {
-	var d string
-
-	if err := db.QueryRow("select typeID FROM fits.type where typeID = $1", typeID).Scan(&d); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
-		}
-		return err
-	}
-
-	return nil
+	_, err := getType(typeID)
+	return err
 }
 
-// validTypeMethod checks that the typeID and methodID exists in the DB
+// validTypeMethod checks that the typeID and methodID exist
 // and are a valid combination.
 func validTypeMethod(typeID, methodID string) error {
-	var d string
+	m, err := meta.get()
+	if err != nil {
+		return err
+	}
 
-	if err := db.QueryRow("SELECT typepk FROM fits.type join fits.type_method using (typepk) join fits.method using (methodpk)  WHERE typeid = $1 and methodid = $2",
-		typeID, methodID).Scan(&d); err != nil {
-		if err == sql.ErrNoRows {
-			return weft.StatusError{Code: http.StatusNotFound}
+	for _, v := range m.typeMethods[typeID] {
+		if v == methodID {
+			return nil
 		}
-
-		return err
 	}
 
-	return nil
+	return weft.StatusError{Code: http.StatusNotFound}
 }
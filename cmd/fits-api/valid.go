@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"errors"
 	"net/http"
 	"strings"
@@ -11,6 +10,7 @@ import (
 
 type siteQ struct {
 	siteID, name string
+	siteLocation
 }
 
 type typeQ struct {
@@ -18,34 +18,31 @@ type typeQ struct {
 	name, description, unit string
 }
 
+// getType returns the type for typeID from the metadata cache.
 func getType(typeID string) (typeQ, error) {
-	t := typeQ{
-		typeID: typeID,
+	m, err := meta.get()
+	if err != nil {
+		return typeQ{typeID: typeID}, err
 	}
 
-	err := db.QueryRow("select type.name, type.description, unit.symbol FROM fits.type join fits.unit using (unitpk) where typeID = $1",
-		t.typeID).Scan(&t.name, &t.description, &t.unit)
-	if err == sql.ErrNoRows {
-		return t, weft.StatusError{Code: http.StatusNotFound}
-	}
-	if err != nil {
-		return t, err
+	t, ok := m.types[typeID]
+	if !ok {
+		return typeQ{typeID: typeID}, weft.StatusError{Code: http.StatusNotFound}
 	}
 
 	return t, nil
 }
 
+// getSite returns the site for siteID from the metadata cache.
 func getSite(siteID string) (siteQ, error) {
-	s := siteQ{
-		siteID: siteID,
+	m, err := meta.get()
+	if err != nil {
+		return siteQ{siteID: siteID}, err
 	}
 
-	err := db.QueryRow("select name FROM fits.site where siteid = $1", s.siteID).Scan(&s.name)
-	if err == sql.ErrNoRows {
-		return s, weft.StatusError{Code: http.StatusNotFound}
-	}
-	if err != nil {
-		return s, err
+	s, ok := m.sites[siteID]
+	if !ok {
+		return siteQ{siteID: siteID}, weft.StatusError{Code: http.StatusNotFound}
 	}
 
 	return s, nil
@@ -71,14 +68,12 @@ func getSites(s string) ([]siteQ, error) {
 		}
 	}
 
-	for _, s := range sites {
-		err := db.QueryRow("select name FROM fits.site where siteid = $1", s.siteID).Scan(&s.name) //nolint G601
-		if err == sql.ErrNoRows {
-			return sites, weft.StatusError{Code: http.StatusNotFound}
-		}
+	for i := range sites {
+		st, err := getSite(sites[i].siteID)
 		if err != nil {
 			return sites, err
 		}
+		sites[i] = st
 	}
 
 	return sites, nil
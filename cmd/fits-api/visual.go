@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/GeoNet/fits/internal/ts"
+	"github.com/GeoNet/fits/internal/valid"
+	"github.com/GeoNet/kit/weft"
+)
+
+// visualObservation returns the visual observations (e.g., field photos) for a site as JSON or GeoJSON.
+func visualObservation(r *http.Request, h http.Header, b *bytes.Buffer) error {
+	q, err := weft.CheckQueryValid(r, []string{"GET"}, []string{"siteID"}, []string{"days", "start", "end"}, valid.Query)
+	if err != nil {
+		return err
+	}
+
+	f := obsFilter{siteID: q.Get("siteID")}
+
+	err = validSite(f.siteID)
+	if err != nil {
+		return err
+	}
+
+	f.start, f.end, err = parseWindow(q)
+	if err != nil {
+		return err
+	}
+
+	where, args := f.where()
+
+	var d string
+
+	switch r.Header.Get("Accept") {
+	case v1GeoJSON:
+		h.Set("Content-Type", v1GeoJSON)
+
+		err = db.QueryRow(`SELECT row_to_json(fc)
+			FROM (SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
+			FROM (SELECT 'Feature' as type,
+			ST_AsGeoJSON(location)::json as geometry,
+			row_to_json((SELECT l FROM
+				(
+					SELECT
+					siteid AS "siteID",
+					to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') AS "DateTime",
+					image_url AS "imageURL",
+					notes
+				) as l
+			)) as properties FROM fits.visual_observation JOIN fits.site USING (sitepk)`+where+
+			`ORDER BY time ASC) As f ) as fc`, args...).Scan(&d)
+	default:
+		h.Set("Content-Type", v1JSON)
+
+		err = db.QueryRow(`SELECT row_to_json(fc) FROM (SELECT COALESCE(array_to_json(array_agg(v)), '[]') as "visualObservation"
+			FROM (SELECT to_char(time, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') AS "DateTime",
+				image_url AS "imageURL",
+				notes
+			FROM fits.visual_observation`+where+`ORDER BY time ASC) as v) as fc`, args...).Scan(&d)
+	}
+	if err != nil {
+		return err
+	}
+
+	b.WriteString(d)
+
+	return nil
+}
+
+// addVisual adds a marker for each visual observation at the site in f.
+// The notes are the marker label.
+func (plt *plt) addVisual(f obsFilter) error {
+	where, args := obsFilter{siteID: f.siteID, start: f.start, end: f.end}.where()
+
+	rows, err := db.Query(`SELECT time, notes FROM fits.visual_observation`+where+`ORDER BY time ASC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m ts.Marker
+
+		err = rows.Scan(&m.DateTime, &m.Label)
+		if err != nil {
+			return err
+		}
+
+		plt.AddMarker(m)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Wide tables have a row for each site and time and a value, error, qualifier, and detection limit column
+// for each type (and optionally method).
+
+// wideColumn is a type, and optionally a method, in a wide table.
+type wideColumn struct {
+	TypeID   string `json:"typeID"`
+	MethodID string `json:"methodID,omitempty"`
+	Unit     string `json:"unit"`
+}
+
+func (c wideColumn) label() string {
+	if c.MethodID == "" {
+		return c.TypeID
+	}
+
+	return c.TypeID + " " + c.MethodID
+}
+
+// wideRow is the observations at a site and time.  Values and Errors
+// are in the order of the columns and are nil if there is no observation for the column.
+// Qualifiers and DetectionLimits are only set if an observation in the row has a
+// qualifier or detection limit.
+type wideRow struct {
+	SiteID          string     `json:"siteID,omitempty"`
+	T               time.Time  `json:"DateTime"`
+	Values          []*float64 `json:"Value"`
+	Errors          []*float64 `json:"Error"`
+	Qualifiers      []string   `json:"Qualifier,omitempty"`
+	DetectionLimits []*float64 `json:"DetectionLimit,omitempty"`
+}
+
+// wideObs is an observation to be added to a wide table.
+type wideObs struct {
+	siteID string
+	t      time.Time
+	c      wideColumn
+	v, e   float64
+	qual   string
+	dl     *float64
+}
+
+// wideRows joins obs on site and time into rows with the values in the order of columns.
+// If there is more than one observation for a column at the same site and time then
+// there are extra rows for that site and time.  Observations for columns that are not
+// in columns are dropped.  obs is sorted by site and time.
+func wideRows(obs []wideObs, columns []wideColumn) []wideRow {
+	index := make(map[wideColumn]int)
+	for i, c := range columns {
+		index[c] = i
+	}
+
+	sort.SliceStable(obs, func(i, j int) bool {
+		if obs[i].siteID != obs[j].siteID {
+			return obs[i].siteID < obs[j].siteID
+		}
+		return obs[i].t.Before(obs[j].t)
+	})
+
+	var rows []wideRow
+
+	for i := range obs {
+		c, ok := index[obs[i].c]
+		if !ok {
+			continue
+		}
+
+		// find a row for this site and time with the column empty.  Rows for
+		// the same site and time are together at the end of rows.
+		r := -1
+		for j := len(rows) - 1; j >= 0 && rows[j].SiteID == obs[i].siteID && rows[j].T.Equal(obs[i].t); j-- {
+			if rows[j].Values[c] == nil {
+				r = j
+			}
+		}
+
+		if r == -1 {
+			rows = append(rows, wideRow{
+				SiteID: obs[i].siteID,
+				T:      obs[i].t,
+				Values: make([]*float64, len(columns)),
+				Errors: make([]*float64, len(columns)),
+			})
+			r = len(rows) - 1
+		}
+
+		rows[r].Values[c] = &obs[i].v
+		rows[r].Errors[c] = &obs[i].e
+
+		if obs[i].qual != "" || obs[i].dl != nil {
+			if rows[r].Qualifiers == nil {
+				rows[r].Qualifiers = make([]string, len(columns))
+				rows[r].DetectionLimits = make([]*float64, len(columns))
+			}
+			rows[r].Qualifiers[c] = obs[i].qual
+			rows[r].DetectionLimits[c] = obs[i].dl
+		}
+	}
+
+	return rows
+}
+
+// writeWideCSV writes the wide table to b as CSV.  The site column is only
+// included if site is true.
+func writeWideCSV(b *bytes.Buffer, columns []wideColumn, rows []wideRow, site bool) {
+	if site {
+		b.WriteString("siteID, ")
+	}
+	b.WriteString("date-time")
+	for _, c := range columns {
+		b.WriteString(", " + c.label() + " (" + c.Unit + "), " + c.label() + " error (" + c.Unit + "), " +
+			c.label() + " qualifier, " + c.label() + " detection limit (" + c.Unit + ")")
+	}
+	b.Write(eol)
+
+	for _, r := range rows {
+		if site {
+			b.WriteString(r.SiteID + ",")
+		}
+		b.WriteString(r.T.UTC().Format("2006-01-02T15:04:05.000Z"))
+		for i := range columns {
+			b.WriteString("," + formatNullable(r.Values[i]) + "," + formatNullable(r.Errors[i]))
+			if r.Qualifiers != nil {
+				b.WriteString("," + r.Qualifiers[i] + "," + formatNullable(r.DetectionLimits[i]))
+			} else {
+				b.WriteString(",,")
+			}
+		}
+		b.Write(eol)
+	}
+}
+
+// formatNullable formats f or returns an empty string if it is nil.
+func formatNullable(f *float64) string {
+	if f == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+// stringNull returns s or an empty string if it is nil.
+func stringNull(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWideCSV(t *testing.T) {
+	tm := time.Date(2001, 1, 8, 12, 0, 0, 0, time.UTC)
+	dl := 0.01
+
+	columns := []wideColumn{
+		{TypeID: "t1", MethodID: "m1", Unit: "m"},
+		{TypeID: "t2", MethodID: "m1", Unit: "K"},
+	}
+
+	obs := []wideObs{
+		{siteID: "TEST3", t: tm, c: columns[1], v: 0.01, qual: "<", dl: &dl},
+		{siteID: "TEST2", t: tm, c: columns[0], v: 9.12, e: 0.01},
+		{siteID: "TEST2", t: tm, c: columns[1], v: 9.12, e: 0.01},
+		{siteID: "TEST2", t: tm, c: columns[0], v: 9.02, e: 0.1},
+		{siteID: "TEST2", t: tm, c: wideColumn{TypeID: "t3", Unit: "m"}, v: 1},
+	}
+
+	var b bytes.Buffer
+	writeWideCSV(&b, columns, wideRows(obs, columns), true)
+
+	expected := `siteID, date-time, t1 m1 (m), t1 m1 error (m), t1 m1 qualifier, t1 m1 detection limit (m), t2 m1 (K), t2 m1 error (K), t2 m1 qualifier, t2 m1 detection limit (K)
+TEST2,2001-01-08T12:00:00.000Z,9.12,0.01,,,9.12,0.01,,
+TEST2,2001-01-08T12:00:00.000Z,9.02,0.1,,,,,,
+TEST3,2001-01-08T12:00:00.000Z,,,,,0.01,0,<,0.01
+`
+
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/GeoNet/fits/internal/ingest"
+	"github.com/lib/pq"
+)
+
+// loader adds sites and observations.
+type loader struct {
+	db     *sql.DB
+	dryRun bool
+	// out is where the changes are printed for a dry run.
+	out io.Writer
+	// tx is used for everything in a dry run.
+	tx *sql.Tx
+	// submitter and reason are recorded with the revisions to observations.
+	submitter, reason string
+}
+
+// counts are the changes made by a load.
+type counts struct {
+	inserts, updates, unchanged, repeated int
+}
+
+func (c *counts) add(o counts) {
+	c.inserts += o.inserts
+	c.updates += o.updates
+	c.unchanged += o.unchanged
+	c.repeated += o.repeated
+}
+
+const createStage = `CREATE TEMP TABLE IF NOT EXISTS ingest_observation (line INT, siteid TEXT, typeid TEXT, methodid TEXT,
+	systemid TEXT, sampleid TEXT, time TIMESTAMP(6) WITH TIME ZONE, value NUMERIC, error NUMERIC, factor NUMERIC, shift NUMERIC,
+	quality TEXT, qualifier TEXT, detection_limit NUMERIC)`
+
+// staged is the converted observations in ingest_observation.  For repeated observations the last line is used.
+// quality is NULL if it was not given.
+const staged = `WITH s AS (SELECT DISTINCT ON (sitepk, typepk, methodpk, samplepk, time) line, sitepk, typepk, methodpk, samplepk,
+		siteid, typeid, methodid, systemid, sampleid, time, value * factor + shift AS value, error * factor AS error,
+		NULLIF(quality, '') AS quality, qualifier, detection_limit * factor + shift AS detection_limit
+	FROM ingest_observation JOIN fits.site USING (siteid) JOIN fits.type USING (typeid) JOIN fits.method USING (methodid)
+	JOIN fits.system USING (systemid) JOIN fits.sample USING (systempk, sampleid)
+	ORDER BY sitepk, typepk, methodpk, samplepk, time, line DESC) `
+
+// stagedQuality is the quality for an observation in s joined to the existing observation o.  Observations
+// without a quality keep the existing quality or are raw.
+const stagedQuality = `COALESCE(s.quality, o.quality, 'raw')`
+
+// changed is true if the observation in s is different to the existing observation o.
+const changed = `(o.value, o.error, o.quality, o.qualifier, o.detection_limit) IS DISTINCT FROM
+	(s.value, s.error, ` + stagedQuality + `, s.qualifier, s.detection_limit)`
+
+/*
+run adds or updates the sites in one transaction and then the observations in transactions of batch
+observations.  conv is the conversion for each observation.  It returns the changes to the sites and observations.
+
+For a dry run the changes are printed and everything is done in one transaction that is rolled back.
+*/
+func (l *loader) run(sites []ingest.Site, obs []ingest.Observation, conv []conversion, batch int) (s, total counts, err error) {
+	if l.dryRun {
+		l.tx, err = l.begin()
+		if err != nil {
+			return
+		}
+		defer l.tx.Rollback()
+	}
+
+	if len(sites) > 0 {
+		err = l.inTx(func(tx *sql.Tx) (err error) {
+			s, err = l.addSites(tx, sites)
+			return
+		})
+		if err != nil {
+			err = fmt.Errorf("adding sites: %w", err)
+			return
+		}
+
+		log.Printf("sites: %d inserts, %d updates, %d unchanged", s.inserts, s.updates, s.unchanged)
+	}
+
+	start := time.Now()
+
+	for i := 0; i < len(obs); i += batch {
+		j := min(i+batch, len(obs))
+
+		var c counts
+
+		err = l.inTx(func(tx *sql.Tx) (err error) {
+			c, err = l.addObservations(tx, obs[i:j], conv[i:j])
+			return
+		})
+		if err != nil {
+			err = fmt.Errorf("adding observations from line %d: %w", obs[i].Line, err)
+			return
+		}
+
+		total.add(c)
+	}
+
+	if len(obs) > 0 {
+		log.Printf("observations: %d inserts, %d updates, %d unchanged, %d repeated in %s",
+			total.inserts, total.updates, total.unchanged, total.repeated, time.Since(start).Round(time.Millisecond))
+	}
+
+	if l.dryRun {
+		log.Print("dry run, nothing was written")
+	}
+
+	return
+}
+
+// inTx runs f in a transaction that is committed if f succeeds.  For a dry run f uses l.tx.
+func (l *loader) inTx(f func(*sql.Tx) error) error {
+	if l.dryRun {
+		return f(l.tx)
+	}
+
+	tx, err := l.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = f(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// begin starts a transaction with the submitter and reason for the revisions.
+func (l *loader) begin() (*sql.Tx, error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.Exec(ingest.RevisionQuery, l.submitter, l.reason); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// addSites adds or updates sites with fits.add_site.  Sites that have not changed are not updated.
+func (l *loader) addSites(tx *sql.Tx, sites []ingest.Site) (counts, error) {
+	var c counts
+
+	for _, s := range sites {
+		var old string
+		var same bool
+
+		err := tx.QueryRow(`SELECT concat_ws(', ', name, ST_X(location::geometry), ST_Y(location::geometry), height, ground_relationship),
+			name = $2 AND ST_X(location::geometry) = $3::float8 AND ST_Y(location::geometry) = $4::float8
+			AND height = $5::numeric AND ground_relationship = $6::numeric
+			FROM fits.site WHERE siteid = $1`,
+			s.SiteID, s.Name, s.Longitude.String(), s.Latitude.String(), s.Height.String(), s.GroundRelationship.String()).Scan(&old, &same)
+
+		change := fmt.Sprintf("site %s: %s, %s, %s, %s, %s", s.SiteID, s.Name, s.Longitude, s.Latitude, s.Height, s.GroundRelationship)
+
+		switch {
+		case err == sql.ErrNoRows:
+			c.inserts++
+			l.print("+ " + change)
+		case err != nil:
+			return c, err
+		case same:
+			c.unchanged++
+			continue
+		default:
+			c.updates++
+			l.print("~ " + change + " was " + old)
+		}
+
+		_, err = tx.Exec(`SELECT fits.add_site($1, $2, $3, $4, $5, $6)`,
+			s.SiteID, s.Name, s.Longitude.String(), s.Latitude.String(), s.Height.String(), s.GroundRelationship.String())
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// addObservations copies obs into a staging table and then adds or updates them with a single statement.
+func (l *loader) addObservations(tx *sql.Tx, obs []ingest.Observation, conv []conversion) (counts, error) {
+	var c counts
+
+	if _, err := tx.Exec(createStage); err != nil {
+		return c, err
+	}
+
+	if _, err := tx.Exec(`TRUNCATE ingest_observation`); err != nil {
+		return c, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("ingest_observation", "line", "siteid", "typeid", "methodid", "systemid", "sampleid",
+		"time", "value", "error", "factor", "shift", "quality", "qualifier", "detection_limit"))
+	if err != nil {
+		return c, err
+	}
+
+	for i, o := range obs {
+		var limit any
+		if o.DetectionLimit != "" {
+			limit = o.DetectionLimit.String()
+		}
+
+		_, err = stmt.Exec(o.Line, o.SiteID, o.TypeID, o.MethodID, o.SystemID, o.SampleID, o.Time.Format(time.RFC3339Nano),
+			o.Value.String(), o.Error.String(), conv[i].factor, conv[i].shift, o.Quality, o.Qualifier, limit)
+		if err != nil {
+			stmt.Close()
+			return c, err
+		}
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return c, err
+	}
+
+	if err = stmt.Close(); err != nil {
+		return c, err
+	}
+
+	var n int
+
+	if l.dryRun {
+		n, err = l.printChanges(tx, &c)
+	} else {
+		err = tx.QueryRow(staged+`SELECT count(*),
+			count(*) FILTER (WHERE o.value IS NULL),
+			count(*) FILTER (WHERE o.value IS NOT NULL AND `+changed+`)
+			FROM s LEFT JOIN fits.observation AS o USING (sitepk, typepk, methodpk, samplepk, time)`).Scan(&n, &c.inserts, &c.updates)
+	}
+	if err != nil {
+		return c, err
+	}
+
+	c.unchanged = n - c.inserts - c.updates
+	c.repeated = len(obs) - n
+
+	_, err = tx.Exec(staged + `INSERT INTO fits.observation (sitepk, typepk, methodpk, samplepk, time, value, error, quality,
+			qualifier, detection_limit)
+		SELECT sitepk, typepk, methodpk, samplepk, time, s.value, s.error, ` + stagedQuality + `, s.qualifier, s.detection_limit
+		FROM s LEFT JOIN fits.observation AS o USING (sitepk, typepk, methodpk, samplepk, time)
+		ON CONFLICT (sitepk, typepk, methodpk, samplepk, time) DO UPDATE SET value = EXCLUDED.value, error = EXCLUDED.error,
+		quality = EXCLUDED.quality, qualifier = EXCLUDED.qualifier, detection_limit = EXCLUDED.detection_limit
+		WHERE (observation.value, observation.error, observation.quality, observation.qualifier, observation.detection_limit)
+		IS DISTINCT FROM (EXCLUDED.value, EXCLUDED.error, EXCLUDED.quality, EXCLUDED.qualifier, EXCLUDED.detection_limit)`)
+
+	return c, err
+}
+
+// printChanges prints the inserts and updates for the staged observations and counts them.
+// It returns the number of staged observations.
+func (l *loader) printChanges(tx *sql.Tx, c *counts) (int, error) {
+	var n int
+
+	if err := tx.QueryRow(staged + `SELECT count(*) FROM s`).Scan(&n); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(staged + `SELECT siteid, typeid, methodid, systemid, sampleid, time,
+		s.qualifier || s.value::text || COALESCE(' limit ' || s.detection_limit::text, ''), s.error::text, ` + stagedQuality + `,
+		COALESCE(o.qualifier || o.value::text || COALESCE(' limit ' || o.detection_limit::text, ''), ''),
+		COALESCE(o.error::text, ''), COALESCE(o.quality, '')
+		FROM s LEFT JOIN fits.observation AS o USING (sitepk, typepk, methodpk, samplepk, time)
+		WHERE o.value IS NULL OR ` + changed + `
+		ORDER BY line`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var siteID, typeID, methodID, systemID, sampleID, value, e, quality, oldValue, oldError, oldQuality string
+		var t time.Time
+
+		err = rows.Scan(&siteID, &typeID, &methodID, &systemID, &sampleID, &t, &value, &e, &quality, &oldValue, &oldError, &oldQuality)
+		if err != nil {
+			return 0, err
+		}
+
+		change := fmt.Sprintf("%s %s %s %s %s %s %s %s %s", siteID, typeID, methodID, systemID, sampleID,
+			t.UTC().Format(time.RFC3339Nano), value, e, quality)
+
+		switch oldValue {
+		case "":
+			c.inserts++
+			l.print("+ " + change)
+		default:
+			c.updates++
+			l.print("~ " + change + " was " + oldValue + " " + oldError + " " + oldQuality)
+		}
+	}
+
+	return n, rows.Err()
+}
+
+func (l *loader) print(s string) {
+	if l.dryRun {
+		fmt.Fprintln(l.out, s)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/GeoNet/fits/internal/ingest"
+	_ "github.com/lib/pq"
+)
+
+// Test loading into the test DB.  Observations are added at TEST8, which no other test
+// uses, in 1998 and removed after the test.
+func TestLoad(t *testing.T) {
+	db, err := sql.Open("postgres", "host=localhost connect_timeout=5 user=fits_w password=test dbname=fits sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	defer func() {
+		_, err := db.Exec(`DELETE FROM fits.observation WHERE sitepk = (SELECT sitepk FROM fits.site WHERE siteid = 'TEST8')
+			AND time >= '1998-01-01T00:00:00Z' AND time < '1999-01-01T00:00:00Z'`)
+		if err != nil {
+			t.Error(err)
+		}
+		_, err = db.Exec(`DELETE FROM fits.observation_revision WHERE sitepk = (SELECT sitepk FROM fits.site WHERE siteid = 'TEST8')
+			AND time >= '1998-01-01T00:00:00Z' AND time < '1999-01-01T00:00:00Z'`)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	reg, err := loadRegistry(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sites, err := ingest.ReadSitesCSV(strings.NewReader(`siteID, name, longitude, latitude, height, groundRelationship
+TEST8, Test site 8, 168.0, -46.9, 10.0, 0.0
+TEST9, Test site 9, 175.1, -41.2, 10, 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obs, err := ingest.ReadObservationsCSV(strings.NewReader(`siteID, typeID, methodID, date-time, value, error, systemID, sampleID, unit, quality, detectionLimit
+TEST8, t1, m1, 1998-01-01T00:00:00Z, 1.25, 0.1, none, none, , ,
+TEST8, t1, m1, 1998-01-02T00:00:00Z, 1250, 100, none, none, mm, ,
+TEST8, t1, m1, 1998-01-04T00:00:00Z, 2, 0, lab, 0001, , verified,
+TEST8, t1, m1, 1998-01-01T00:00:00Z, 1.5, 0.1, none, none, , ,
+TEST8, t1, m1, 2000-01-07T12:00:00.000000Z, 2.52, 0, none, none, , ,
+TEST8, t1, m1, 1998-01-03T00:00:00Z, <500, 0, lab, 0001, mm, , 500
+TEST9, t1, m1, 1998-01-01T00:00:00Z, 1, 0, none, none, , ,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conv, errs := reg.check(sites, "sites", obs, "observations")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	// a dry run prints the changes and writes nothing.
+	var b bytes.Buffer
+
+	l := loader{db: db, dryRun: true, out: &b}
+
+	s, c, err := l.run(sites, obs, conv, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.inserts != 1 || s.unchanged != 1 {
+		t.Errorf("expected 1 site insert and 1 unchanged got %+v", s)
+	}
+
+	// the repeated observation in the second batch updates the one in the first.
+	if c.inserts != 5 || c.updates != 1 || c.unchanged != 1 {
+		t.Errorf("expected 5 inserts, 1 update, and 1 unchanged got %+v", c)
+	}
+
+	expected := `+ site TEST9: Test site 9, 175.1, -41.2, 10, 0
++ TEST8 t1 m1 none none 1998-01-01T00:00:00Z 1.25 0.1 raw
++ TEST8 t1 m1 none none 1998-01-02T00:00:00Z 1.250 0.100 raw
++ TEST8 t1 m1 lab 0001 1998-01-04T00:00:00Z 2 0 verified
+~ TEST8 t1 m1 none none 1998-01-01T00:00:00Z 1.5 0.1 raw was 1.25 0.1 raw
++ TEST8 t1 m1 lab 0001 1998-01-03T00:00:00Z <0.500 limit 0.500 0.000 raw
++ TEST9 t1 m1 none none 1998-01-01T00:00:00Z 1 0 raw
+`
+	if b.String() != expected {
+		t.Errorf("expected dry run output:\n%s\ngot:\n%s", expected, b.String())
+	}
+
+	var n int
+	if err = db.QueryRow(`SELECT count(*) FROM fits.site WHERE siteid = 'TEST9'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("expected no site to be added for a dry run")
+	}
+
+	// load the observations for the existing sites.
+	obs = obs[:len(obs)-1]
+
+	l = loader{db: db, submitter: "ingest test", reason: "test load"}
+
+	_, c, err = l.run(nil, obs, conv[:len(obs)], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// in one batch the repeated observation uses the last line.
+	if c.inserts != 4 || c.updates != 0 || c.unchanged != 1 || c.repeated != 1 {
+		t.Errorf("expected 4 inserts, 1 unchanged, and 1 repeated got %+v", c)
+	}
+
+	var v, e float64
+	err = db.QueryRow(`SELECT value, error FROM fits.observation JOIN fits.site USING (sitepk)
+		WHERE siteid = 'TEST8' AND time = '1998-01-01T00:00:00Z'`).Scan(&v, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 1.5 || e != 0.1 {
+		t.Errorf("expected 1.5 and 0.1 got %f and %f", v, e)
+	}
+
+	err = db.QueryRow(`SELECT value, error FROM fits.observation JOIN fits.site USING (sitepk)
+		WHERE siteid = 'TEST8' AND time = '1998-01-02T00:00:00Z'`).Scan(&v, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 1.25 || e != 0.1 {
+		t.Errorf("expected the mm observation to be converted to 1.25 and 0.1 got %f and %f", v, e)
+	}
+
+	// the qualifier is read from the value and the detection limit is converted.
+	var q string
+	var dl float64
+	err = db.QueryRow(`SELECT value, qualifier, detection_limit FROM fits.observation JOIN fits.site USING (sitepk)
+		WHERE siteid = 'TEST8' AND time = '1998-01-03T00:00:00Z'`).Scan(&v, &q, &dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 0.5 || q != "<" || dl != 0.5 {
+		t.Errorf("expected <0.5 with a detection limit of 0.5 got %s%f and %f", q, v, dl)
+	}
+
+	// observations without a quality keep the existing quality.
+	for _, v := range []struct{ siteID, time, quality string }{
+		{siteID: "TEST8", time: "1998-01-04T00:00:00Z", quality: "verified"},
+		{siteID: "TEST8", time: "2000-01-07T12:00:00Z", quality: "suspect"},
+	} {
+		var q string
+		err = db.QueryRow(`SELECT quality FROM fits.observation JOIN fits.site USING (sitepk)
+			WHERE siteid = $1 AND time = $2`, v.siteID, v.time).Scan(&q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if q != v.quality {
+			t.Errorf("expected %s %s quality %s got %s", v.siteID, v.time, v.quality, q)
+		}
+	}
+
+	// the inserts are recorded with the submitter and reason.
+	err = db.QueryRow(`SELECT count(*) FROM fits.observation_revision WHERE sitepk = (SELECT sitepk FROM fits.site WHERE siteid = 'TEST8')
+		AND time >= '1998-01-01T00:00:00Z' AND time < '1999-01-01T00:00:00Z' AND old_value IS NULL AND submitter = 'ingest test' AND reason = 'test load'`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Errorf("expected 4 revisions got %d", n)
+	}
+
+	// loading again changes nothing.
+	_, c, err = l.run(nil, obs, conv[:len(obs)], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.inserts != 0 || c.updates != 0 || c.unchanged != 5 {
+		t.Errorf("expected 5 unchanged got %+v", c)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+fits-ingest adds or updates sites and observations in FITS from CSV or JSON files.
+
+	fits-ingest [-dry-run] [-batch n] [-submitter s] [-reason r] [-sites file] [-observations file]
+
+The file formats are described in internal/ingest.  Files ending in .json are read as JSON
+and anything else as CSV.  Use - to read from stdin.
+
+Every site and observation is checked against the sites, types, methods, samples, and units
+before anything is written.  Deprecated types, methods, units, and systems can't be used.  If any
+are not valid they are all reported and nothing is written.
+
+Sites are added or updated in one transaction.  Observations are then added or updated in
+transactions of up to batch observations.  Observations that are repeated in a batch use the
+last one.  Observations that have not changed are not updated.  Observations without a quality
+keep the quality they have.  The qualifier and detection limit are always replaced.
+
+Changes to observations are recorded in fits.observation_revision with the submitter, the DB user
+by default, and the reason.
+
+With -dry-run the inserts (+) and updates (~) are printed and then rolled back.  Unchanged
+sites and observations are not printed.  Observation values are printed with their qualifier
+and detection limit, e.g., <0.01 limit 0.01.
+
+The DB connection is configured from the environment in the same way as fits-api.  The user
+must be able to write to the fits schema.
+*/
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GeoNet/fits/internal/ingest"
+	"github.com/GeoNet/fits/internal/valid"
+	_ "github.com/lib/pq"
+)
+
+var (
+	dryRun       = flag.Bool("dry-run", false, "print the inserts and updates without making them")
+	batchSize    = flag.Int("batch", 10000, "the number of observations to add in each transaction")
+	sitesFile    = flag.String("sites", "", "a CSV or JSON file of sites to add or update")
+	observations = flag.String("observations", "", "a CSV or JSON file of observations to add or update")
+	submitter    = flag.String("submitter", "", "who the changes to observations are recorded for, the DB user by default")
+	reason       = flag.String("reason", "", "why the observations were changed")
+)
+
+func main() {
+	flag.Parse()
+
+	if *sitesFile == "" && *observations == "" {
+		fmt.Fprintln(os.Stderr, "at least one of -sites or -observations must be specified")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *batchSize < 1 {
+		log.Fatal("batch must be at least 1")
+	}
+
+	if *reason != "" {
+		if err := valid.Parameter("reason", *reason); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var sites []ingest.Site
+	var obs []ingest.Observation
+
+	if *sitesFile != "" {
+		err := read(*sitesFile, func(r io.Reader, json bool) (err error) {
+			if json {
+				sites, err = ingest.ReadSitesJSON(r)
+			} else {
+				sites, err = ingest.ReadSitesCSV(r)
+			}
+			return
+		})
+		if err != nil {
+			log.Fatalf("reading %s: %s", *sitesFile, err)
+		}
+	}
+
+	if *observations != "" {
+		err := read(*observations, func(r io.Reader, json bool) (err error) {
+			if json {
+				obs, err = ingest.ReadObservationsJSON(r)
+			} else {
+				obs, err = ingest.ReadObservationsCSV(r)
+			}
+			return
+		})
+		if err != nil {
+			log.Fatalf("reading %s: %s", *observations, err)
+		}
+	}
+
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s connect_timeout=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_CONN_TIMEOUT"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWD"),
+		os.Getenv("DB_NAME"),
+		os.Getenv("DB_SSLMODE")))
+	if err != nil {
+		log.Fatalf("ERROR: problem with DB config: %s", err)
+	}
+	defer db.Close()
+
+	reg, err := loadRegistry(db)
+	if err != nil {
+		log.Fatalf("loading the registry: %s", err)
+	}
+
+	conv, errs := reg.check(sites, *sitesFile, obs, *observations)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		log.Fatalf("found %d invalid sites or observations, nothing was written", len(errs))
+	}
+
+	l := loader{db: db, dryRun: *dryRun, out: os.Stdout, submitter: *submitter, reason: *reason}
+
+	if _, _, err = l.run(sites, obs, conv, *batchSize); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// read opens the file name, or stdin for -, and calls f to read it.
+func read(name string, f func(r io.Reader, json bool) error) error {
+	if name == "-" {
+		return f(os.Stdin, false)
+	}
+
+	r, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return f(r, strings.EqualFold(filepath.Ext(name), ".json"))
+}
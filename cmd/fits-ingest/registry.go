@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/GeoNet/fits/internal/ingest"
+)
+
+// registry is what sites and observations are checked against.
+type registry struct {
+	sites map[string]bool
+	// units are the unit symbols for each typeID.
+	units map[string]string
+	// methods are keyed by typeID and methodID.
+	methods map[[2]string]bool
+	// samples are keyed by systemID and sampleID.
+	samples map[[2]string]bool
+	// conversions are keyed by the from and to unit symbols.
+	conversions map[[2]string]conversion
+	// deprecated is keyed by the rows from ingest.DeprecatedQuery.
+	deprecated map[string]bool
+}
+
+// conversion converts a value to another unit as value * factor + shift.  Errors are
+// scaled by factor.  The factor and shift are kept as text and used as NUMERIC in the DB.
+type conversion struct {
+	factor, shift string
+}
+
+var noConversion = conversion{factor: "1", shift: "0"}
+
+func loadRegistry(db *sql.DB) (registry, error) {
+	r := registry{
+		sites:       make(map[string]bool),
+		units:       make(map[string]string),
+		methods:     make(map[[2]string]bool),
+		samples:     make(map[[2]string]bool),
+		conversions: make(map[[2]string]conversion),
+		deprecated:  make(map[string]bool),
+	}
+
+	err := ingest.Query(db, `SELECT siteid FROM fits.site`, func(rows *sql.Rows) error {
+		var s string
+		err := rows.Scan(&s)
+		r.sites[s] = true
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT typeid, symbol FROM fits.type JOIN fits.unit USING (unitpk)`, func(rows *sql.Rows) error {
+		var t, u string
+		err := rows.Scan(&t, &u)
+		r.units[t] = u
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT typeid, methodid FROM fits.type_method JOIN fits.type USING (typepk)
+		JOIN fits.method USING (methodpk)`, func(rows *sql.Rows) error {
+		var k [2]string
+		err := rows.Scan(&k[0], &k[1])
+		r.methods[k] = true
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, ingest.DeprecatedQuery, func(rows *sql.Rows) error {
+		var k string
+		err := rows.Scan(&k)
+		r.deprecated[k] = true
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	err = ingest.Query(db, `SELECT systemid, sampleid FROM fits.sample JOIN fits.system USING (systempk)`, func(rows *sql.Rows) error {
+		var k [2]string
+		err := rows.Scan(&k[0], &k[1])
+		r.samples[k] = true
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	// an inverse is only used if the conversion has not been added in both directions.  Inverses are
+	// rounded to double precision so that 1 / 1000 is 0.001 and not 0.00100000000000000000.
+	err = ingest.Query(db, `SELECT f.symbol, t.symbol, factor::text, shift::text FROM fits.unit_conversion
+		JOIN fits.unit AS f ON (fromunitpk = f.unitpk) JOIN fits.unit AS t ON (tounitpk = t.unitpk)
+		UNION ALL
+		SELECT t.symbol, f.symbol, (1 / factor)::float8::text, (-shift / factor)::float8::text FROM fits.unit_conversion AS c
+		JOIN fits.unit AS f ON (fromunitpk = f.unitpk) JOIN fits.unit AS t ON (tounitpk = t.unitpk)
+		WHERE NOT EXISTS (SELECT 1 FROM fits.unit_conversion AS i WHERE i.fromunitpk = c.tounitpk AND i.tounitpk = c.fromunitpk)`,
+		func(rows *sql.Rows) error {
+			var k [2]string
+			var c conversion
+			err := rows.Scan(&k[0], &k[1], &c.factor, &c.shift)
+			r.conversions[k] = c
+			return err
+		})
+
+	return r, err
+}
+
+/*
+check checks sites and observations against r.  Sites that are being added can be used by the
+observations.  It returns the conversion for each observation, or an error for each site and
+observation that is not valid.  The errors start with the file name and line.
+*/
+func (r registry) check(sites []ingest.Site, sitesFile string, obs []ingest.Observation, obsFile string) ([]conversion, []error) {
+	var errs []error
+
+	known := make(map[string]bool)
+	for k := range r.sites {
+		known[k] = true
+	}
+
+	for i := range sites {
+		if err := sites[i].Check(); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", sitesFile, sites[i].Line, err))
+			continue
+		}
+		known[sites[i].SiteID] = true
+	}
+
+	conv := make([]conversion, len(obs))
+
+	for i := range obs {
+		c, err := r.checkObservation(&obs[i], known)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", obsFile, obs[i].Line, err))
+			continue
+		}
+		conv[i] = c
+	}
+
+	return conv, errs
+}
+
+func (r registry) checkObservation(o *ingest.Observation, sites map[string]bool) (conversion, error) {
+	if err := o.Check(); err != nil {
+		return conversion{}, err
+	}
+
+	if !sites[o.SiteID] {
+		return conversion{}, fmt.Errorf("unknown siteID: %q", o.SiteID)
+	}
+
+	unit, ok := r.units[o.TypeID]
+	if !ok {
+		return conversion{}, fmt.Errorf("unknown typeID: %q", o.TypeID)
+	}
+
+	if !r.methods[[2]string{o.TypeID, o.MethodID}] {
+		return conversion{}, fmt.Errorf("invalid methodID %q for typeID %s", o.MethodID, o.TypeID)
+	}
+
+	if !r.samples[[2]string{o.SystemID, o.SampleID}] {
+		return conversion{}, fmt.Errorf("unknown sample: systemID %q sampleID %q", o.SystemID, o.SampleID)
+	}
+
+	if err := o.CheckDeprecated(r.deprecated); err != nil {
+		return conversion{}, err
+	}
+
+	if o.Unit == "" || o.Unit == unit {
+		return noConversion, nil
+	}
+
+	c, ok := r.conversions[[2]string{o.Unit, unit}]
+	if !ok {
+		return conversion{}, fmt.Errorf("can't convert %s to %s for typeID %s", o.Unit, unit, o.TypeID)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/GeoNet/fits/internal/ingest"
+)
+
+func TestCheck(t *testing.T) {
+	r := registry{
+		sites:       map[string]bool{"TEST1": true},
+		units:       map[string]string{"t1": "m"},
+		methods:     map[[2]string]bool{{"t1", "m1"}: true, {"t1", "m3"}: true},
+		samples:     map[[2]string]bool{{"none", "none"}: true, {"lab", "0001"}: true},
+		conversions: map[[2]string]conversion{{"mm", "m"}: {factor: "0.001", shift: "0"}},
+		deprecated:  map[string]bool{"methodID m3": true},
+	}
+
+	sites := []ingest.Site{
+		{SiteID: "TEST4", Name: "Test site 4", Longitude: "172.79019", Latitude: "-42.21496", Height: "-999.9", GroundRelationship: "0", Line: 2},
+		{SiteID: "TEST 5", Name: "Test site 5", Longitude: "172.79019", Latitude: "-42.21496", Height: "-999.9", GroundRelationship: "0", Line: 3},
+	}
+
+	o := func(siteID, methodID, systemID, sampleID, unit string) ingest.Observation {
+		return ingest.Observation{SiteID: siteID, TypeID: "t1", MethodID: methodID, SystemID: systemID, SampleID: sampleID,
+			DateTime: "2000-01-06T12:00:00Z", Value: json.Number("1.52"), Unit: unit}
+	}
+
+	in := []struct {
+		o   ingest.Observation
+		c   conversion
+		err bool
+		id  string
+	}{
+		{o: o("TEST1", "m1", "", "", ""), c: noConversion, id: loc()},
+		{o: o("TEST1", "m1", "lab", "0001", "m"), c: noConversion, id: loc()},
+		{o: o("TEST1", "m1", "", "", "mm"), c: conversion{factor: "0.001", shift: "0"}, id: loc()},
+		// TEST4 is in the sites being added.
+		{o: o("TEST4", "m1", "", "", ""), c: noConversion, id: loc()},
+		{o: o("TEST5", "m1", "", "", ""), err: true, id: loc()},
+		{o: o("TEST1", "m2", "", "", ""), err: true, id: loc()},
+		{o: o("TEST1", "m1", "lab", "0002", ""), err: true, id: loc()},
+		{o: o("TEST1", "m1", "", "", "K"), err: true, id: loc()},
+		{o: o("TEST1", "m3", "", "", ""), err: true, id: loc()},
+	}
+
+	for _, v := range in {
+		c, errs := r.check(sites, "sites.csv", []ingest.Observation{v.o}, "observations.csv")
+
+		// the site on line 3 is not valid.
+		if len(errs) == 0 || errs[0].Error() != `sites.csv:3: invalid siteID: "TEST 5"` {
+			t.Errorf("%s expected an error for the site got %v", v.id, errs)
+			continue
+		}
+
+		if (len(errs) == 2) != v.err {
+			t.Errorf("%s unexpected errors: %v", v.id, errs)
+			continue
+		}
+
+		if !v.err && c[0] != v.c {
+			t.Errorf("%s expected conversion %+v got %+v", v.id, v.c, c[0])
+		}
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
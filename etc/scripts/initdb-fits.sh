@@ -30,10 +30,12 @@ dropdb --username=$db_user fits
 psql -d postgres --username=$db_user --file=${ddl_dir}/drop-create-users.ddl
 psql -d postgres --username=$db_user --file=${ddl_dir}/create-db.ddl
 
-# Function security means adding postgis has to be done as a superuser - here that is the postgres user.
+# Function security means adding postgis (and pg_trgm and unaccent for site search) has to be done as a superuser - here that is the postgres user.
 # On AWS RDS the created functions have to be transfered to the rds_superuser.
 # http://docs.aws.amazon.com/AmazonRDS/latest/UserGuide/Appendix.PostgreSQL.CommonDBATasks.html#Appendix.PostgreSQL.CommonDBATasks.PostGIS
 psql -d fits --username=$db_user -c 'CREATE EXTENSION IF NOT EXISTS postgis;'
+psql -d fits --username=$db_user -c 'CREATE EXTENSION IF NOT EXISTS pg_trgm;'
+psql -d fits --username=$db_user -c 'CREATE EXTENSION IF NOT EXISTS unaccent;'
 
 psql --quiet --username=$db_user --dbname=fits --file=${ddl_dir}/fits-create.ddl
 psql --quiet --username=$db_user --dbname=fits --file=${ddl_dir}/fits-functions.ddl
@@ -32,10 +32,12 @@ dropdb --host=127.0.0.1 --username=$db_user fits
 psql --host=127.0.0.1 -d postgres --username=$db_user --file=${ddl_dir}/drop-create-users.ddl
 psql --host=127.0.0.1 -d postgres --username=$db_user --file=${ddl_dir}/create-db.ddl
 
-# Function security means adding postgis has to be done as a superuser - here that is the postgres user.
+# Function security means adding postgis (and pg_trgm and unaccent for site search) has to be done as a superuser - here that is the postgres user.
 # On AWS RDS the created functions have to be transfered to the rds_superuser.
 # http://docs.aws.amazon.com/AmazonRDS/latest/UserGuide/Appendix.PostgreSQL.CommonDBATasks.html#Appendix.PostgreSQL.CommonDBATasks.PostGIS
 psql --host=127.0.0.1 -d fits --username=$db_user -c 'create extension postgis;'
+psql --host=127.0.0.1 -d fits --username=$db_user -c 'create extension pg_trgm;'
+psql --host=127.0.0.1 -d fits --username=$db_user -c 'create extension unaccent;'
 
 psql --host=127.0.0.1 --quiet --username=$db_user --dbname=fits --file=${ddl_dir}/fits-create.ddl
 psql --host=127.0.0.1 --quiet --username=$db_user --dbname=fits --file=${ddl_dir}/fits-functions.ddl
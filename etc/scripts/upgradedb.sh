@@ -0,0 +1,24 @@
+#!/bin/bash
+
+ddl_dir=$(dirname $0)/../ddl
+
+user=postgres
+db_user=${1:-$user}
+export PGPASSWORD=$2
+
+# A script to upgrade an existing database to the current schema.  The observations are kept.
+#
+# usage: upgradedb.sh 'db_super_user_name' 'db_super_user_password'
+#
+# Stops at the first error.  fits-upgrade.ddl is run in a transaction so the database is unchanged
+# if it fails.  Take a backup first anyway.
+#
+set -e
+
+# Function security means adding pg_trgm and unaccent has to be done as a superuser - here that is the postgres user.
+psql --host=127.0.0.1 -d fits --username=$db_user -c 'CREATE EXTENSION IF NOT EXISTS pg_trgm;'
+psql --host=127.0.0.1 -d fits --username=$db_user -c 'CREATE EXTENSION IF NOT EXISTS unaccent;'
+
+psql --host=127.0.0.1 --quiet -v ON_ERROR_STOP=1 --username=$db_user --dbname=fits --file=${ddl_dir}/fits-upgrade.ddl
+psql --host=127.0.0.1 --quiet -v ON_ERROR_STOP=1 --username=$db_user --dbname=fits --file=${ddl_dir}/fits-functions.ddl
+psql --host=127.0.0.1 --quiet -v ON_ERROR_STOP=1 --username=$db_user --dbname=fits --file=${ddl_dir}/user-permissions.ddl
@@ -5,6 +5,7 @@ go 1.23
 require (
 	github.com/GeoNet/kit v0.0.0-20241129025613-745247c4fb1c
 	github.com/lib/pq v1.10.9
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
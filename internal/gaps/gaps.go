@@ -0,0 +1,152 @@
+/*
+Package gaps finds missing observations in a time series with a regular sampling cadence
+and measures how complete the series is.
+*/
+package gaps
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Tolerance is the number of cadences between observations (or the window and an
+// observation) that is a gap.  This allows for jitter in the sampling times.
+const Tolerance = 1.5
+
+var ErrNoCadence = errors.New("not enough observations to infer the cadence")
+
+// Gap is a period with missing observations.  Start and End are the observations (or
+// the window limits) either side of the gap.
+type Gap struct {
+	Start, End time.Time
+}
+
+// Duration returns the length of the gap.
+func (g Gap) Duration() time.Duration {
+	return g.End.Sub(g.Start)
+}
+
+// Report is the completeness of observations in a time window.
+type Report struct {
+	Start, End   time.Time
+	Cadence      time.Duration // the expected time between observations.
+	Expected     int           // the number of observations expected in the window at the cadence.
+	Observed     int           // the number of cadence intervals in the window that have an observation.
+	Completeness float64       // Observed as a percentage of Expected.
+	Gaps         []Gap
+}
+
+/*
+Cadence returns the median interval between the observations at times t.  Repeated times
+(e.g., observations with different methods) are not counted.  t does not need to be ordered.
+Returns ErrNoCadence if there are less than two distinct times.
+*/
+func Cadence(t []time.Time) (time.Duration, error) {
+	s := sorted(t)
+
+	var d []time.Duration
+
+	for i := 1; i < len(s); i++ {
+		if dt := s[i].Sub(s[i-1]); dt > 0 {
+			d = append(d, dt)
+		}
+	}
+
+	if len(d) == 0 {
+		return 0, ErrNoCadence
+	}
+
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+
+	m := len(d) / 2
+	if len(d)%2 == 1 {
+		return d[m], nil
+	}
+
+	return (d[m-1] + d[m]) / 2, nil
+}
+
+/*
+Find reports the completeness of the observations at times t in the window start to end at
+the cadence.  Observations outside the window are ignored.  A gap is when the time between
+observations, or between the window limits and the nearest observation, is more than Tolerance
+cadences.  If there are no observations the window is a gap.  The window is divided into
+intervals of cadence length from start and Observed is the number of intervals with at least
+one observation.
+*/
+func Find(t []time.Time, start, end time.Time, cadence time.Duration) (Report, error) {
+	r := Report{Start: start, End: end, Cadence: cadence}
+
+	if cadence <= 0 {
+		return r, ErrNoCadence
+	}
+
+	if end.Before(start) {
+		return r, errors.New("start must be before end")
+	}
+
+	r.Expected = int(end.Sub(start)/cadence) + 1
+
+	var in []time.Time
+	for _, x := range sorted(t) {
+		if x.Before(start) || x.After(end) {
+			continue
+		}
+		in = append(in, x)
+	}
+
+	slots := make(map[int64]bool)
+	for _, x := range in {
+		slots[int64(x.Sub(start)/cadence)] = true
+	}
+	r.Observed = len(slots)
+
+	r.Completeness = 100 * float64(r.Observed) / float64(r.Expected)
+	if r.Completeness > 100 {
+		r.Completeness = 100
+	}
+
+	if len(in) == 0 {
+		if end.After(start) {
+			r.Gaps = []Gap{{Start: start, End: end}}
+		}
+		return r, nil
+	}
+
+	limit := time.Duration(Tolerance * float64(cadence))
+
+	prev := start
+	for _, x := range in {
+		if x.Sub(prev) > limit {
+			r.Gaps = append(r.Gaps, Gap{Start: prev, End: x})
+		}
+		prev = x
+	}
+	if end.Sub(prev) > limit {
+		r.Gaps = append(r.Gaps, Gap{Start: prev, End: end})
+	}
+
+	return r, nil
+}
+
+// Longest returns the longest gap in r.  The zero Gap is returned if there are no gaps.
+func (r Report) Longest() Gap {
+	var g Gap
+
+	for _, x := range r.Gaps {
+		if x.Duration() > g.Duration() {
+			g = x
+		}
+	}
+
+	return g
+}
+
+func sorted(t []time.Time) []time.Time {
+	s := make([]time.Time, len(t))
+	copy(s, t)
+	sort.Slice(s, func(i, j int) bool { return s[i].Before(s[j]) })
+
+	return s
+}
@@ -0,0 +1,141 @@
+package gaps_test
+
+import (
+	"math"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GeoNet/fits/internal/gaps"
+)
+
+var t0 = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func day(d float64) time.Time {
+	return t0.Add(time.Duration(d * 24 * float64(time.Hour)))
+}
+
+func days(d ...float64) []time.Time {
+	var t []time.Time
+	for _, x := range d {
+		t = append(t, day(x))
+	}
+	return t
+}
+
+const oneDay = 24 * time.Hour
+
+func TestCadence(t *testing.T) {
+	in := []struct {
+		t        []time.Time
+		expected time.Duration
+		err      error
+		id       string
+	}{
+		{t: days(0, 1, 2, 3), expected: oneDay, id: loc()},
+		{t: days(3, 0, 2, 1), expected: oneDay, id: loc()},
+		{t: days(0, 1, 1, 2, 10), expected: oneDay, id: loc()}, // repeated times and a gap
+		{t: days(0, 1, 3, 6), expected: 2 * oneDay, id: loc()},
+		{t: days(0, 1), expected: oneDay, id: loc()},
+		{t: days(0, 0), err: gaps.ErrNoCadence, id: loc()},
+		{t: days(0), err: gaps.ErrNoCadence, id: loc()},
+		{t: nil, err: gaps.ErrNoCadence, id: loc()},
+	}
+
+	for _, v := range in {
+		c, err := gaps.Cadence(v.t)
+		if err != v.err {
+			t.Errorf("%s expected error %v got %v", v.id, v.err, err)
+			continue
+		}
+		if c != v.expected {
+			t.Errorf("%s expected cadence %s got %s", v.id, v.expected, c)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	in := []struct {
+		t                  []time.Time
+		start, end         time.Time
+		expected, observed int
+		completeness       float64
+		gaps               []gaps.Gap
+		id                 string
+	}{
+		{t: days(0, 1, 2, 3), start: day(0), end: day(3), expected: 4, observed: 4, completeness: 100, id: loc()},
+		{t: days(0.5, 1.5, 2.5), start: day(0), end: day(3), expected: 4, observed: 3, completeness: 75, id: loc()},
+		{t: days(0, 1, 4, 5), start: day(0), end: day(5), expected: 6, observed: 4, completeness: 400 / 6.0,
+			gaps: []gaps.Gap{{Start: day(1), End: day(4)}}, id: loc()},
+		// stopped before the end of the window.
+		{t: days(0, 1, 2), start: day(0), end: day(9), expected: 10, observed: 3, completeness: 30,
+			gaps: []gaps.Gap{{Start: day(2), End: day(9)}}, id: loc()},
+		// started after the start of the window, observations outside the window are ignored.
+		{t: days(-5, 3, 4), start: day(0), end: day(4), expected: 5, observed: 2, completeness: 40,
+			gaps: []gaps.Gap{{Start: day(0), End: day(3)}}, id: loc()},
+		// repeated times are counted once.
+		{t: days(0, 0, 1, 1), start: day(0), end: day(1), expected: 2, observed: 2, completeness: 100, id: loc()},
+		{t: nil, start: day(0), end: day(1), expected: 2, observed: 0, completeness: 0,
+			gaps: []gaps.Gap{{Start: day(0), End: day(1)}}, id: loc()},
+	}
+
+	for _, v := range in {
+		r, err := gaps.Find(v.t, v.start, v.end, oneDay)
+		if err != nil {
+			t.Errorf("%s unexpected error %s", v.id, err)
+			continue
+		}
+
+		if r.Expected != v.expected {
+			t.Errorf("%s expected %d expected got %d", v.id, v.expected, r.Expected)
+		}
+		if r.Observed != v.observed {
+			t.Errorf("%s expected %d observed got %d", v.id, v.observed, r.Observed)
+		}
+		if math.Abs(r.Completeness-v.completeness) > 1e-9 {
+			t.Errorf("%s expected completeness %f got %f", v.id, v.completeness, r.Completeness)
+		}
+		if len(r.Gaps) != len(v.gaps) {
+			t.Errorf("%s expected %d gaps got %d", v.id, len(v.gaps), len(r.Gaps))
+			continue
+		}
+		for i := range r.Gaps {
+			if !r.Gaps[i].Start.Equal(v.gaps[i].Start) || !r.Gaps[i].End.Equal(v.gaps[i].End) {
+				t.Errorf("%s gap %d expected %v got %v", v.id, i, v.gaps[i], r.Gaps[i])
+			}
+		}
+	}
+
+	if _, err := gaps.Find(days(0, 1), day(0), day(1), 0); err != gaps.ErrNoCadence {
+		t.Errorf("expected ErrNoCadence for zero cadence got %v", err)
+	}
+
+	if _, err := gaps.Find(days(0, 1), day(1), day(0), oneDay); err == nil {
+		t.Error("expected error for end before start")
+	}
+}
+
+func TestLongest(t *testing.T) {
+	r, err := gaps.Find(days(0, 3, 4, 9), day(0), day(9), oneDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Gaps) != 2 {
+		t.Fatalf("expected 2 gaps got %d", len(r.Gaps))
+	}
+
+	if l := r.Longest(); l.Duration() != 5*oneDay {
+		t.Errorf("expected longest gap 5 days got %s", l.Duration())
+	}
+
+	if l := (gaps.Report{}).Longest(); l.Duration() != 0 {
+		t.Errorf("expected zero gap got %s", l.Duration())
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
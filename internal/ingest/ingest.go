@@ -0,0 +1,417 @@
+/*
+Package ingest reads sites and observations to add to FITS from CSV or JSON.
+
+CSV starts with a header line naming the columns, which can be in any order.  The columns
+for observations are:
+
+	siteID, typeID, methodID, date-time, value                             - required.
+	error, systemID, sampleID, unit, quality, qualifier, detectionLimit   - optional.
+
+The error defaults to 0 (unknown).  systemID and sampleID must be specified together and default
+to none.  The unit defaults to the unit for the type.  The quality is one of raw, verified, suspect,
+or rejected.  If it is empty new observations are raw and existing observations keep their quality.
+
+The qualifier is < for a value below the detection limit, > for a value above the range of the method,
+~ for an approximate value, or empty for a measured value.  In CSV the qualifier can also be the first
+character of the value e.g., <0.01.  The detectionLimit is optional and is in the same unit as the value.
+
+The columns for sites are:
+
+	siteID, name, longitude, latitude, height, groundRelationship - all required.
+
+JSON is an array of objects with a property for each column.  The properties are capitalised and
+date-time is DateTime e.g., SiteID, TypeID, MethodID, DateTime, Value, Error, SystemID, SampleID, Unit, Quality,
+Qualifier, and DetectionLimit.
+Numbers can be JSON numbers or strings.
+
+Values are kept as the decimal strings that were read so they can be stored exactly.
+*/
+package ingest
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// None is the system and sample for observations that are not from a sample.
+const None = "none"
+
+var (
+	// idRE matches identifiers that can be used in the API query parameters.
+	idRE = regexp.MustCompile(`^[0-9a-zA-Z\-\_\.]+$`)
+	// decimalRE matches values that can be stored exactly as NUMERIC.
+	decimalRE = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+)
+
+// Observation is an observation to add or update.
+type Observation struct {
+	SiteID, TypeID, MethodID, SystemID, SampleID string
+	DateTime                                     string
+	Value, Error                                 json.Number
+	Unit                                         string
+	Quality                                      string
+	Qualifier                                    string
+	DetectionLimit                               json.Number
+	// Line is the CSV line or the position in the JSON array starting at 1.
+	Line int `json:"-"`
+	// Err is set if the observation could not be read.
+	Err error `json:"-"`
+	// Time is DateTime.  Set by Check.
+	Time time.Time `json:"-"`
+}
+
+// Site is a site to add or update.
+type Site struct {
+	SiteID, Name                                    string
+	Longitude, Latitude, Height, GroundRelationship json.Number
+	Line                                            int   `json:"-"`
+	Err                                             error `json:"-"`
+}
+
+// ReadObservationsCSV reads observations from CSV with a header line.
+func ReadObservationsCSV(r io.Reader) ([]Observation, error) {
+	var obs []Observation
+
+	err := readCSV(r,
+		[]string{"siteID", "typeID", "methodID", "date-time", "value", "error", "systemID", "sampleID", "unit", "quality", "qualifier", "detectionLimit"},
+		[]string{"siteID", "typeID", "methodID", "date-time", "value"},
+		func(line int, get func(string) string, err error) {
+			if err != nil {
+				obs = append(obs, Observation{Line: line, Err: err})
+				return
+			}
+
+			obs = append(obs, Observation{
+				SiteID:         get("siteID"),
+				TypeID:         get("typeID"),
+				MethodID:       get("methodID"),
+				SystemID:       get("systemID"),
+				SampleID:       get("sampleID"),
+				DateTime:       get("date-time"),
+				Value:          json.Number(get("value")),
+				Error:          json.Number(get("error")),
+				Unit:           get("unit"),
+				Quality:        get("quality"),
+				Qualifier:      get("qualifier"),
+				DetectionLimit: json.Number(get("detectionLimit")),
+				Line:           line,
+			})
+		})
+
+	return obs, err
+}
+
+// ReadObservationsJSON reads observations from a JSON array of objects.
+func ReadObservationsJSON(r io.Reader) ([]Observation, error) {
+	var obs []Observation
+
+	if err := readJSON(r, &obs); err != nil {
+		return nil, err
+	}
+
+	for i := range obs {
+		obs[i].Line = i + 1
+	}
+
+	return obs, nil
+}
+
+// ReadSitesCSV reads sites from CSV with a header line.
+func ReadSitesCSV(r io.Reader) ([]Site, error) {
+	var sites []Site
+
+	cols := []string{"siteID", "name", "longitude", "latitude", "height", "groundRelationship"}
+
+	err := readCSV(r, cols, cols,
+		func(line int, get func(string) string, err error) {
+			if err != nil {
+				sites = append(sites, Site{Line: line, Err: err})
+				return
+			}
+
+			sites = append(sites, Site{
+				SiteID:             get("siteID"),
+				Name:               get("name"),
+				Longitude:          json.Number(get("longitude")),
+				Latitude:           json.Number(get("latitude")),
+				Height:             json.Number(get("height")),
+				GroundRelationship: json.Number(get("groundRelationship")),
+				Line:               line,
+			})
+		})
+
+	return sites, err
+}
+
+// ReadSitesJSON reads sites from a JSON array of objects.
+func ReadSitesJSON(r io.Reader) ([]Site, error) {
+	var sites []Site
+
+	if err := readJSON(r, &sites); err != nil {
+		return nil, err
+	}
+
+	for i := range sites {
+		sites[i].Line = i + 1
+	}
+
+	return sites, nil
+}
+
+// Check checks the syntax of o and sets the defaults and Time.  It doesn't check that
+// the site, type, method, sample, or unit exist.
+func (o *Observation) Check() error {
+	if o.Err != nil {
+		return o.Err
+	}
+
+	switch "" {
+	case o.SiteID:
+		return errors.New("missing siteID")
+	case o.TypeID:
+		return errors.New("missing typeID")
+	case o.MethodID:
+		return errors.New("missing methodID")
+	}
+
+	switch {
+	case o.SystemID == "" && o.SampleID == "":
+		o.SystemID, o.SampleID = None, None
+	case o.SystemID == "" || o.SampleID == "":
+		return errors.New("systemID and sampleID must both be specified")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, o.DateTime)
+	if err != nil {
+		return fmt.Errorf("invalid date-time: %q", o.DateTime)
+	}
+	o.Time = t
+
+	if v := o.Value.String(); v != "" && strings.ContainsAny(v[:1], "<>~") {
+		if o.Qualifier != "" && o.Qualifier != v[:1] {
+			return fmt.Errorf("qualifier %q doesn't match value: %q", o.Qualifier, o.Value)
+		}
+		o.Qualifier, o.Value = v[:1], json.Number(v[1:])
+	}
+
+	if !decimalRE.MatchString(o.Value.String()) {
+		return fmt.Errorf("invalid value: %q", o.Value)
+	}
+
+	if o.Error == "" {
+		o.Error = "0"
+	}
+
+	if !decimalRE.MatchString(o.Error.String()) || strings.HasPrefix(o.Error.String(), "-") {
+		return fmt.Errorf("invalid error: %q", o.Error)
+	}
+
+	switch o.Quality {
+	case "", "raw", "verified", "suspect", "rejected":
+	default:
+		return fmt.Errorf("invalid quality: %q", o.Quality)
+	}
+
+	switch o.Qualifier {
+	case "", "<", ">", "~":
+	default:
+		return fmt.Errorf("invalid qualifier: %q", o.Qualifier)
+	}
+
+	if o.DetectionLimit != "" && !decimalRE.MatchString(o.DetectionLimit.String()) {
+		return fmt.Errorf("invalid detectionLimit: %q", o.DetectionLimit)
+	}
+
+	return nil
+}
+
+// RevisionQuery sets the submitter ($1) and reason ($2) for the revisions recorded in fits.observation_revision
+// by the rest of the transaction.  An empty submitter uses the DB user.
+const RevisionQuery = `SELECT set_config('fits.submitter', $1, true), set_config('fits.reason', $2, true)`
+
+// DeprecatedQuery selects a key for each deprecated type, method, type method link, unit, and system.
+// Use the keys with CheckDeprecated.
+const DeprecatedQuery = `SELECT 'typeID ' || typeid FROM fits.type WHERE deprecated
+	UNION ALL SELECT 'methodID ' || methodid FROM fits.method WHERE deprecated
+	UNION ALL SELECT 'link ' || typeid || ' ' || methodid FROM fits.type_method AS tm JOIN fits.type USING (typepk)
+		JOIN fits.method USING (methodpk) WHERE tm.deprecated
+	UNION ALL SELECT 'unit ' || symbol FROM fits.unit WHERE deprecated
+	UNION ALL SELECT 'systemID ' || systemid FROM fits.system WHERE deprecated`
+
+// Querier is a *sql.DB or *sql.Tx.
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Query calls f for each row from q.
+func Query(db Querier, q string, f func(*sql.Rows) error) error {
+	rows, err := db.Query(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = f(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Decimal returns true if s is a decimal number that can be stored exactly as NUMERIC.
+func Decimal(s string) bool {
+	return decimalRE.MatchString(s)
+}
+
+// CheckDeprecated returns an error if o uses a type, method, type method link, unit, or system
+// that has been deprecated.  deprecated is keyed by the rows from DeprecatedQuery.  Call it after Check.
+func (o *Observation) CheckDeprecated(deprecated map[string]bool) error {
+	switch {
+	case deprecated["typeID "+o.TypeID]:
+		return fmt.Errorf("deprecated typeID: %q", o.TypeID)
+	case deprecated["methodID "+o.MethodID]:
+		return fmt.Errorf("deprecated methodID: %q", o.MethodID)
+	case deprecated["link "+o.TypeID+" "+o.MethodID]:
+		return fmt.Errorf("deprecated methodID %q for typeID %s", o.MethodID, o.TypeID)
+	case o.Unit != "" && deprecated["unit "+o.Unit]:
+		return fmt.Errorf("deprecated unit: %q", o.Unit)
+	case deprecated["systemID "+o.SystemID]:
+		return fmt.Errorf("deprecated systemID: %q", o.SystemID)
+	}
+
+	return nil
+}
+
+// Check checks s.
+func (s *Site) Check() error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	if !idRE.MatchString(s.SiteID) {
+		return fmt.Errorf("invalid siteID: %q", s.SiteID)
+	}
+
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("missing name")
+	}
+
+	lon, err := decimal("longitude", s.Longitude)
+	if err != nil {
+		return err
+	}
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude out of range: %s", s.Longitude)
+	}
+
+	lat, err := decimal("latitude", s.Latitude)
+	if err != nil {
+		return err
+	}
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude out of range: %s", s.Latitude)
+	}
+
+	if _, err = decimal("height", s.Height); err != nil {
+		return err
+	}
+
+	if _, err = decimal("groundRelationship", s.GroundRelationship); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// decimal parses n as a decimal number.
+func decimal(name string, n json.Number) (float64, error) {
+	if !decimalRE.MatchString(n.String()) {
+		return 0, fmt.Errorf("invalid %s: %q", name, n)
+	}
+
+	return strconv.ParseFloat(n.String(), 64)
+}
+
+// readCSV reads CSV with a header line.  Only columns are allowed and required must be present.
+// f is called for each line after the header with a function to get the field for a column, or
+// with an error if the line has the wrong number of fields.
+func readCSV(r io.Reader, columns, required []string, f func(line int, get func(string) string, err error)) error {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	c.TrimLeadingSpace = true
+
+	header, err := c.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	allowed := make(map[string]bool)
+	for _, v := range columns {
+		allowed[v] = true
+	}
+
+	col := make(map[string]int)
+
+	for i, v := range header {
+		v = strings.TrimSpace(v)
+		if !allowed[v] {
+			return fmt.Errorf("unknown column: %q", v)
+		}
+		if _, ok := col[v]; ok {
+			return fmt.Errorf("duplicate column: %s", v)
+		}
+		col[v] = i
+	}
+
+	for _, v := range required {
+		if _, ok := col[v]; !ok {
+			return fmt.Errorf("missing column: %s", v)
+		}
+	}
+
+	for {
+		rec, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := c.FieldPos(0)
+
+		if len(rec) != len(header) {
+			f(line, nil, fmt.Errorf("expected %d fields got %d", len(header), len(rec)))
+			continue
+		}
+
+		f(line, func(k string) string {
+			if i, ok := col[k]; ok {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}, nil)
+	}
+}
+
+// readJSON decodes a JSON array from r into v.  Unknown properties are an error.
+func readJSON(r io.Reader, v interface{}) error {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	d.UseNumber()
+
+	return d.Decode(v)
+}
@@ -0,0 +1,251 @@
+package ingest_test
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GeoNet/fits/internal/ingest"
+)
+
+func TestReadObservationsCSV(t *testing.T) {
+	in := []struct {
+		csv   string
+		lines []int
+		ok    bool
+		id    string
+	}{
+		{csv: "siteID, typeID, methodID, date-time, value\nTEST1, t1, m1, 2000-01-06T12:00:00Z, 1.52\n", lines: []int{2}, ok: true, id: loc()},
+		{csv: "value,date-time,methodID,typeID,siteID,unit\n1.52,2000-01-06T12:00:00Z,m1,t1,TEST1,mm\n\nA,B\n", lines: []int{2, 4}, ok: true, id: loc()},
+		{csv: "", ok: true, id: loc()},
+		{csv: "siteID, typeID, methodID, date-time\n", id: loc()},
+		{csv: "siteID, typeID, methodID, date-time, value, colour\n", id: loc()},
+		{csv: "siteID, siteID, typeID, methodID, date-time, value\n", id: loc()},
+		{csv: "siteID, typeID, methodID, date-time, value\nTEST1, t1, m1, \"2000, 1.52\n", id: loc()},
+	}
+
+	for _, v := range in {
+		obs, err := ingest.ReadObservationsCSV(strings.NewReader(v.csv))
+		if (err == nil) != v.ok {
+			t.Errorf("%s unexpected error: %v", v.id, err)
+			continue
+		}
+
+		if len(obs) != len(v.lines) {
+			t.Errorf("%s expected %d observations got %d", v.id, len(v.lines), len(obs))
+			continue
+		}
+
+		for i := range obs {
+			if obs[i].Line != v.lines[i] {
+				t.Errorf("%s expected line %d got %d", v.id, v.lines[i], obs[i].Line)
+			}
+		}
+	}
+
+	obs, err := ingest.ReadObservationsCSV(strings.NewReader("value,date-time,methodID,typeID,siteID,unit,quality,qualifier,detectionLimit\n" +
+		"1.52,2000-01-06T12:00:00Z,m1,t1,TEST1,mm,suspect,<,0.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := obs[0]
+	if o.SiteID != "TEST1" || o.TypeID != "t1" || o.MethodID != "m1" || o.DateTime != "2000-01-06T12:00:00Z" || o.Value != "1.52" ||
+		o.Unit != "mm" || o.Quality != "suspect" || o.Qualifier != "<" || o.DetectionLimit != "0.5" {
+		t.Errorf("unexpected observation %+v", o)
+	}
+}
+
+func TestReadObservationsJSON(t *testing.T) {
+	obs, err := ingest.ReadObservationsJSON(strings.NewReader(`[{"SiteID": "TEST1", "TypeID": "t1", "MethodID": "m1",
+		"DateTime": "2000-01-06T12:00:00Z", "Value": 1.520, "Error": "0.1"}, {"SiteID": "TEST2"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(obs) != 2 {
+		t.Fatalf("expected 2 observations got %d", len(obs))
+	}
+
+	// the decimal is kept as it was read.
+	if obs[0].Value != "1.520" || obs[0].Error != "0.1" || obs[0].Line != 1 || obs[1].Line != 2 {
+		t.Errorf("unexpected observations %+v", obs)
+	}
+
+	for _, s := range []string{`[{"SiteID": "TEST1", "Colour": "red"}]`, `{"SiteID": "TEST1"}`, `[{"Value": "a"}]`} {
+		if _, err := ingest.ReadObservationsJSON(strings.NewReader(s)); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+}
+
+func TestObservationCheck(t *testing.T) {
+	ok := func() ingest.Observation {
+		return ingest.Observation{SiteID: "TEST1", TypeID: "t1", MethodID: "m1", DateTime: "2000-01-06T12:00:00.5Z", Value: "1.52"}
+	}
+
+	in := []struct {
+		o   func(o *ingest.Observation)
+		err bool
+		id  string
+	}{
+		{o: func(o *ingest.Observation) {}, id: loc()},
+		{o: func(o *ingest.Observation) { o.SystemID, o.SampleID = "lab", "0001" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value, o.Error = "-1e3", "0.01" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = ".5" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.SiteID = "" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.MethodID = "" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.SystemID = "lab" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.DateTime = "2000-01-06" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = "" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = "0x1p-2" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = "NaN" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Error = "-0.1" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Quality = "suspect" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Quality = "good" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Qualifier = "<" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Qualifier, o.DetectionLimit = "~", "0.01" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = "<0.01" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value, o.Qualifier = ">100", ">" }, id: loc()},
+		{o: func(o *ingest.Observation) { o.Qualifier = "<=" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value, o.Qualifier = "<0.01", ">" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = "<" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.Value = "<<0.01" }, err: true, id: loc()},
+		{o: func(o *ingest.Observation) { o.DetectionLimit = "low" }, err: true, id: loc()},
+	}
+
+	for _, v := range in {
+		o := ok()
+		v.o(&o)
+
+		err := o.Check()
+		if (err != nil) != v.err {
+			t.Errorf("%s unexpected error: %v", v.id, err)
+		}
+	}
+
+	o := ok()
+	if err := o.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.SystemID != ingest.None || o.SampleID != ingest.None || o.Error != "0" {
+		t.Errorf("expected the default sample and error got %+v", o)
+	}
+
+	if !o.Time.Equal(time.Date(2000, 1, 6, 12, 0, 0, 500000000, time.UTC)) {
+		t.Errorf("unexpected time %s", o.Time)
+	}
+
+	// the qualifier is read from the start of the value.
+	o = ok()
+	o.Value = "<0.01"
+	if err := o.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if o.Qualifier != "<" || o.Value != "0.01" {
+		t.Errorf("expected qualifier < and value 0.01 got %q and %q", o.Qualifier, o.Value)
+	}
+}
+
+func TestObservationCheckDeprecated(t *testing.T) {
+	deprecated := map[string]bool{"typeID t2": true, "methodID m2": true, "link t1 m3": true, "unit mm": true, "systemID lab": true}
+
+	in := []struct {
+		o   ingest.Observation
+		err bool
+		id  string
+	}{
+		{o: ingest.Observation{TypeID: "t1", MethodID: "m1", SystemID: ingest.None}, id: loc()},
+		{o: ingest.Observation{TypeID: "t1", MethodID: "m1", SystemID: ingest.None, Unit: "m"}, id: loc()},
+		{o: ingest.Observation{TypeID: "t2", MethodID: "m1", SystemID: ingest.None}, err: true, id: loc()},
+		{o: ingest.Observation{TypeID: "t1", MethodID: "m2", SystemID: ingest.None}, err: true, id: loc()},
+		{o: ingest.Observation{TypeID: "t1", MethodID: "m3", SystemID: ingest.None}, err: true, id: loc()},
+		{o: ingest.Observation{TypeID: "t1", MethodID: "m1", SystemID: ingest.None, Unit: "mm"}, err: true, id: loc()},
+		{o: ingest.Observation{TypeID: "t1", MethodID: "m1", SystemID: "lab"}, err: true, id: loc()},
+	}
+
+	for _, v := range in {
+		err := v.o.CheckDeprecated(deprecated)
+		if (err != nil) != v.err {
+			t.Errorf("%s unexpected error: %v", v.id, err)
+		}
+	}
+}
+
+func TestSites(t *testing.T) {
+	sites, err := ingest.ReadSitesCSV(strings.NewReader(`siteID, name, longitude, latitude, height, groundRelationship
+TEST1, "Test site 1, north", 172.79019, -42.21496, -999.9, 0.0
+TEST 2, Test site 2, 172.79019, -42.21496, -999.9, 0.0
+TEST3, , 172.79019, -42.21496, -999.9, 0.0
+TEST4, Test site 4, 182.1, -42.21496, -999.9, 0.0
+TEST5, Test site 5, 172.79019, -42.21496, high, 0.0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := []bool{false, true, true, true, true}
+
+	if len(sites) != len(errs) {
+		t.Fatalf("expected %d sites got %d", len(errs), len(sites))
+	}
+
+	for i := range sites {
+		err := sites[i].Check()
+		if (err != nil) != errs[i] {
+			t.Errorf("line %d unexpected error: %v", sites[i].Line, err)
+		}
+	}
+
+	if sites[0].Name != "Test site 1, north" {
+		t.Errorf("unexpected name %s", sites[0].Name)
+	}
+
+	if _, err := ingest.ReadSitesCSV(strings.NewReader("siteID, name, longitude, latitude\n")); err == nil {
+		t.Error("expected an error for missing columns")
+	}
+
+	sites, err = ingest.ReadSitesJSON(strings.NewReader(`[{"SiteID": "TEST1", "Name": "Test site 1", "Longitude": 172.79019,
+		"Latitude": -42.21496, "Height": -999.9, "GroundRelationship": 0}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sites) != 1 || sites[0].Check() != nil {
+		t.Errorf("unexpected sites %+v", sites)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	in := []struct {
+		s  string
+		ok bool
+		id string
+	}{
+		{s: "1.52", ok: true, id: loc()},
+		{s: "-0.5", ok: true, id: loc()},
+		{s: ".5", ok: true, id: loc()},
+		{s: "1.", ok: true, id: loc()},
+		{s: "1e-3", ok: true, id: loc()},
+		{s: "", id: loc()},
+		{s: "0x1p-2", id: loc()},
+		{s: "NaN", id: loc()},
+		{s: "Inf", id: loc()},
+		{s: "1,5", id: loc()},
+	}
+
+	for _, v := range in {
+		if ingest.Decimal(v.s) != v.ok {
+			t.Errorf("%s expected %t for %q", v.id, v.ok, v.s)
+		}
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
@@ -0,0 +1,104 @@
+/*
+Package outlier finds outliers (e.g., blunders) in observation values using robust
+statistics so the outliers do not affect the detection.
+*/
+package outlier
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/GeoNet/fits/internal/stats"
+)
+
+const (
+	MAD = "mad" // median absolute deviation.
+	IQR = "iqr" // interquartile range.
+)
+
+// The default thresholds for each method.
+const (
+	DefaultMAD = 3.5
+	DefaultIQR = 1.5
+)
+
+// madScale scales the MAD to estimate the standard deviation for normally distributed values.
+const madScale = 1.4826
+
+/*
+Flag returns true for each value in v that is an outlier using method (MAD or IQR).
+If threshold is zero the default for the method is used.
+*/
+func Flag(method string, v []float64, threshold float64) ([]bool, error) {
+	switch method {
+	case MAD:
+		if threshold == 0 {
+			threshold = DefaultMAD
+		}
+		return FlagMAD(v, threshold), nil
+	case IQR:
+		if threshold == 0 {
+			threshold = DefaultIQR
+		}
+		return FlagIQR(v, threshold), nil
+	default:
+		return nil, fmt.Errorf("unknown outlier method: %s", method)
+	}
+}
+
+/*
+FlagMAD returns true for each value in v that is more than threshold scaled
+median absolute deviations from the median.  The MAD is scaled to estimate the
+standard deviation so threshold is similar to a number of standard deviations.
+No values are flagged if the MAD is zero (more than half the values are the same).
+*/
+func FlagMAD(v []float64, threshold float64) []bool {
+	flags := make([]bool, len(v))
+
+	if len(v) == 0 {
+		return flags
+	}
+
+	m := stats.Median(stats.Sorted(v))
+
+	d := make([]float64, len(v))
+	for i := range v {
+		d[i] = math.Abs(v[i] - m)
+	}
+
+	mad := stats.Median(stats.Sorted(d)) * madScale
+	if mad == 0 {
+		return flags
+	}
+
+	for i := range d {
+		flags[i] = d[i]/mad > threshold
+	}
+
+	return flags
+}
+
+/*
+FlagIQR returns true for each value in v that is more than threshold interquartile
+ranges below the first quartile or above the third quartile (Tukey's fences).
+*/
+func FlagIQR(v []float64, threshold float64) []bool {
+	flags := make([]bool, len(v))
+
+	if len(v) == 0 {
+		return flags
+	}
+
+	sorted := stats.Sorted(v)
+	q1 := stats.Percentile(sorted, 25)
+	q3 := stats.Percentile(sorted, 75)
+
+	lower := q1 - threshold*(q3-q1)
+	upper := q3 + threshold*(q3-q1)
+
+	for i := range v {
+		flags[i] = v[i] < lower || v[i] > upper
+	}
+
+	return flags
+}
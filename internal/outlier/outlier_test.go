@@ -0,0 +1,61 @@
+package outlier_test
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/GeoNet/fits/internal/outlier"
+)
+
+func TestFlag(t *testing.T) {
+	in := []struct {
+		method    string
+		v         []float64
+		threshold float64
+		expected  []bool
+		id        string
+	}{
+		{method: outlier.MAD, v: []float64{}, expected: []bool{}, id: loc()},
+		{method: outlier.MAD, v: []float64{1, 2, 3, 2, 1, 2, 100}, expected: []bool{false, false, false, false, false, false, true}, id: loc()},
+		{method: outlier.MAD, v: []float64{1, 2, 3, 2, 1, 2, -100}, expected: []bool{false, false, false, false, false, false, true}, id: loc()},
+		{method: outlier.MAD, v: []float64{1, 2, 3, 2, 1, 2, 5}, expected: []bool{false, false, false, false, false, false, false}, id: loc()},
+		{method: outlier.MAD, v: []float64{1, 2, 3, 2, 1, 2, 5}, threshold: 1, expected: []bool{false, false, false, false, false, false, true}, id: loc()},
+		// more than half the values are the same so the MAD is zero.
+		{method: outlier.MAD, v: []float64{2, 2, 2, 2, 100}, expected: []bool{false, false, false, false, false}, id: loc()},
+		{method: outlier.IQR, v: []float64{}, expected: []bool{}, id: loc()},
+		{method: outlier.IQR, v: []float64{1, 2, 3, 4, 5, 6, 7, 8, 100}, expected: []bool{false, false, false, false, false, false, false, false, true}, id: loc()},
+		{method: outlier.IQR, v: []float64{-100, 2, 3, 4, 5, 6, 7, 8, 9}, expected: []bool{true, false, false, false, false, false, false, false, false}, id: loc()},
+		{method: outlier.IQR, v: []float64{1, 2, 3, 4, 5, 6, 7, 8, 12}, expected: []bool{false, false, false, false, false, false, false, false, false}, id: loc()},
+		{method: outlier.IQR, v: []float64{1, 2, 3, 4, 5, 6, 7, 8, 12}, threshold: 1, expected: []bool{false, false, false, false, false, false, false, false, true}, id: loc()},
+	}
+
+	for _, v := range in {
+		f, err := outlier.Flag(v.method, v.v, v.threshold)
+		if err != nil {
+			t.Errorf("%s unexpected error: %s", v.id, err)
+			continue
+		}
+
+		if len(f) != len(v.expected) {
+			t.Errorf("%s expected %d flags got %d", v.id, len(v.expected), len(f))
+			continue
+		}
+
+		for i := range f {
+			if f[i] != v.expected[i] {
+				t.Errorf("%s expected %v got %v", v.id, v.expected, f)
+				break
+			}
+		}
+	}
+
+	if _, err := outlier.Flag("zscore", []float64{1}, 0); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
@@ -0,0 +1,88 @@
+/*
+Package stats has descriptive statistics for observation values.
+*/
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// Sorted returns a sorted copy of v.
+func Sorted(v []float64) []float64 {
+	s := make([]float64, len(v))
+	copy(s, v)
+	sort.Float64s(s)
+
+	return s
+}
+
+// MeanStddevPop returns the mean and population standard deviation of v.
+// Returns zeros for no values.
+func MeanStddevPop(v []float64) (mean, stddev float64) {
+	if len(v) == 0 {
+		return 0, 0
+	}
+
+	for _, x := range v {
+		mean += x
+	}
+	mean = mean / float64(len(v))
+
+	for _, x := range v {
+		stddev += (x - mean) * (x - mean)
+	}
+	stddev = math.Sqrt(stddev / float64(len(v)))
+
+	return mean, stddev
+}
+
+// Percentile returns the pth percentile (0 to 100) of sorted using linear interpolation
+// between the closest ranks.  This is the same as percentile_cont in Postgres.
+// sorted must be sorted in ascending order.  Returns NaN for no values.
+func Percentile(sorted []float64, p float64) float64 {
+	switch len(sorted) {
+	case 0:
+		return math.NaN()
+	case 1:
+		return sorted[0]
+	}
+
+	r := p / 100 * float64(len(sorted)-1)
+	i := int(math.Floor(r))
+
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	return sorted[i] + (r-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// Median returns the median of sorted.  sorted must be sorted in ascending order.
+func Median(sorted []float64) float64 {
+	return Percentile(sorted, 50)
+}
+
+/*
+WeightedMean returns the mean of values weighted by 1/error^2 and the error in the mean.
+Values with a zero error (unknown) are not used.  ok is false if no values have an error.
+*/
+func WeightedMean(values, errors []float64) (mean, err float64, ok bool) {
+	var sw, swv float64
+
+	for i := range values {
+		if errors[i] == 0 {
+			continue
+		}
+
+		w := 1 / (errors[i] * errors[i])
+		sw += w
+		swv += w * values[i]
+	}
+
+	if sw == 0 {
+		return 0, 0, false
+	}
+
+	return swv / sw, math.Sqrt(1 / sw), true
+}
@@ -0,0 +1,98 @@
+package stats_test
+
+import (
+	"math"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/GeoNet/fits/internal/stats"
+)
+
+func TestMeanStddevPop(t *testing.T) {
+	m, d := stats.MeanStddevPop([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	if m != 5 {
+		t.Errorf("expected mean 5 got %f", m)
+	}
+	if d != 2 {
+		t.Errorf("expected stddev 2 got %f", d)
+	}
+
+	m, d = stats.MeanStddevPop(nil)
+	if m != 0 || d != 0 {
+		t.Errorf("expected zeros for no values got %f %f", m, d)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	in := []struct {
+		v        []float64
+		p        float64
+		expected float64
+		id       string
+	}{
+		{v: []float64{1}, p: 50, expected: 1, id: loc()},
+		{v: []float64{1, 2}, p: 50, expected: 1.5, id: loc()},
+		{v: []float64{1, 2, 3}, p: 50, expected: 2, id: loc()},
+		{v: []float64{1, 2, 3, 4}, p: 25, expected: 1.75, id: loc()},
+		{v: []float64{1, 2, 3, 4}, p: 0, expected: 1, id: loc()},
+		{v: []float64{1, 2, 3, 4}, p: 100, expected: 4, id: loc()},
+		{v: []float64{4, 1, 3, 2}, p: 75, expected: 3.25, id: loc()},
+	}
+
+	for _, v := range in {
+		p := stats.Percentile(stats.Sorted(v.v), v.p)
+		if math.Abs(p-v.expected) > 1e-9 {
+			t.Errorf("%s expected %f got %f", v.id, v.expected, p)
+		}
+	}
+
+	if !math.IsNaN(stats.Percentile([]float64{}, 50)) {
+		t.Error("expected NaN for no values")
+	}
+}
+
+func TestSorted(t *testing.T) {
+	v := []float64{3, 1, 2}
+	s := stats.Sorted(v)
+
+	if s[0] != 1 || s[1] != 2 || s[2] != 3 {
+		t.Errorf("not sorted: %v", s)
+	}
+
+	if v[0] != 3 {
+		t.Error("input was modified")
+	}
+}
+
+func TestWeightedMean(t *testing.T) {
+	m, e, ok := stats.WeightedMean([]float64{1, 3}, []float64{1, 1})
+	if !ok {
+		t.Fatal("expected ok")
+	}
+	if m != 2 {
+		t.Errorf("expected mean 2 got %f", m)
+	}
+	if math.Abs(e-math.Sqrt(0.5)) > 1e-9 {
+		t.Errorf("expected error %f got %f", math.Sqrt(0.5), e)
+	}
+
+	// the more precise value dominates and the zero error value is ignored.
+	m, _, ok = stats.WeightedMean([]float64{1, 3, 100}, []float64{1, 0.1, 0})
+	if !ok {
+		t.Fatal("expected ok")
+	}
+	if math.Abs(m-(1+300)/101.0) > 1e-9 {
+		t.Errorf("expected mean %f got %f", (1+300)/101.0, m)
+	}
+
+	_, _, ok = stats.WeightedMean([]float64{1, 3}, []float64{0, 0})
+	if ok {
+		t.Error("expected not ok for no errors")
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
@@ -0,0 +1,278 @@
+package stats
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const secondsPerYear = 365.25 * 24 * 60 * 60
+
+var (
+	ErrTooFewPoints = errors.New("not enough observations to fit the model")
+	ErrSingular     = errors.New("the model can not be fitted to the observations")
+)
+
+// TrendModel is the terms to fit in addition to the linear trend.
+type TrendModel struct {
+	Annual     bool        // fit an annual sinusoid.
+	SemiAnnual bool        // fit a semi-annual sinusoid.
+	Steps      []time.Time // fit a step offset at each time.
+}
+
+// Harmonic is a sinusoid a.sin(wt) + b.cos(wt) with t in years from the Trend Epoch.
+type Harmonic struct {
+	Sin, Cos, Amplitude float64
+}
+
+// Step is an offset in the observations at a time.
+type Step struct {
+	DateTime      time.Time
+	Offset, Error float64
+}
+
+/*
+Trend is a weighted least squares fit of a line, and optionally annual and semi-annual
+sinusoids and step offsets, to observations.  Rates are per year (365.25 days).
+
+The errors for the fitted parameters are scaled by the reduced chi-squared of the fit.
+*/
+type Trend struct {
+	Epoch                     time.Time // the time of the first observation.  Time is measured from the Epoch.
+	Intercept, InterceptError float64   // the value at Epoch.
+	Slope, SlopeError         float64   // the rate per year.
+	RMS                       float64   // the root mean square of the residuals.
+	Count                     int       // the number of observations used.
+	Weighted                  bool      // false if none of the observations had an error.
+	Annual, SemiAnnual        *Harmonic `json:",omitempty"`
+	Steps                     []Step    `json:",omitempty"`
+}
+
+/*
+FitTrend fits m to the observations with values v at times t.  The observations
+are weighted by 1/e^2.  Observations with a zero (unknown) error are not used
+unless none of the observations have an error in which case they are equally weighted.
+The times do not need to be ordered.
+*/
+func FitTrend(t []time.Time, v, e []float64, m TrendModel) (Trend, error) {
+	var tr Trend
+
+	weighted := false
+	for _, x := range e {
+		if x != 0 {
+			weighted = true
+			break
+		}
+	}
+
+	var tt []time.Time
+	var vv, ww []float64
+
+	for i := range t {
+		switch {
+		case !weighted:
+			ww = append(ww, 1)
+		case e[i] == 0:
+			continue
+		default:
+			ww = append(ww, 1/(e[i]*e[i]))
+		}
+
+		tt = append(tt, t[i])
+		vv = append(vv, v[i])
+	}
+
+	np := 2 + len(m.Steps)
+	if m.Annual {
+		np += 2
+	}
+	if m.SemiAnnual {
+		np += 2
+	}
+
+	if len(tt) <= np {
+		return tr, ErrTooFewPoints
+	}
+
+	tr.Epoch = tt[0]
+	for _, x := range tt {
+		if x.Before(tr.Epoch) {
+			tr.Epoch = x
+		}
+	}
+
+	tr.Count = len(tt)
+	tr.Weighted = weighted
+
+	// normal equations for the weighted least squares fit.
+	n := make([][]float64, np)
+	for i := range n {
+		n[i] = make([]float64, np)
+	}
+	u := make([]float64, np)
+
+	for i := range tt {
+		a := m.row(tt[i], tr.Epoch)
+		for j := 0; j < np; j++ {
+			u[j] += ww[i] * a[j] * vv[i]
+			for k := 0; k < np; k++ {
+				n[j][k] += ww[i] * a[j] * a[k]
+			}
+		}
+	}
+
+	c, err := invert(n)
+	if err != nil {
+		return tr, err
+	}
+
+	x := make([]float64, np)
+	for j := range x {
+		for k := range u {
+			x[j] += c[j][k] * u[k]
+		}
+	}
+
+	// residuals
+	var chi2, ss float64
+	for i := range tt {
+		a := m.row(tt[i], tr.Epoch)
+		var f float64
+		for j := range x {
+			f += a[j] * x[j]
+		}
+		r := vv[i] - f
+		ss += r * r
+		chi2 += ww[i] * r * r
+	}
+
+	tr.RMS = math.Sqrt(ss / float64(len(tt)))
+	scale := chi2 / float64(len(tt)-np)
+
+	sigma := func(j int) float64 {
+		return math.Sqrt(c[j][j] * scale)
+	}
+
+	tr.Intercept, tr.InterceptError = x[0], sigma(0)
+	tr.Slope, tr.SlopeError = x[1], sigma(1)
+
+	j := 2
+	if m.Annual {
+		tr.Annual = &Harmonic{Sin: x[j], Cos: x[j+1], Amplitude: math.Hypot(x[j], x[j+1])}
+		j += 2
+	}
+	if m.SemiAnnual {
+		tr.SemiAnnual = &Harmonic{Sin: x[j], Cos: x[j+1], Amplitude: math.Hypot(x[j], x[j+1])}
+		j += 2
+	}
+	for _, s := range m.Steps {
+		tr.Steps = append(tr.Steps, Step{DateTime: s, Offset: x[j], Error: sigma(j)})
+		j++
+	}
+
+	return tr, nil
+}
+
+// Value returns the value of the fitted model at t.
+func (tr Trend) Value(t time.Time) float64 {
+	y := years(t, tr.Epoch)
+
+	f := tr.Intercept + tr.Slope*y
+
+	if tr.Annual != nil {
+		f += tr.Annual.Sin*math.Sin(2*math.Pi*y) + tr.Annual.Cos*math.Cos(2*math.Pi*y)
+	}
+	if tr.SemiAnnual != nil {
+		f += tr.SemiAnnual.Sin*math.Sin(4*math.Pi*y) + tr.SemiAnnual.Cos*math.Cos(4*math.Pi*y)
+	}
+	for _, s := range tr.Steps {
+		if !t.Before(s.DateTime) {
+			f += s.Offset
+		}
+	}
+
+	return f
+}
+
+// row returns the design matrix row for time t.
+func (m TrendModel) row(t, epoch time.Time) []float64 {
+	y := years(t, epoch)
+
+	a := []float64{1, y}
+
+	if m.Annual {
+		a = append(a, math.Sin(2*math.Pi*y), math.Cos(2*math.Pi*y))
+	}
+	if m.SemiAnnual {
+		a = append(a, math.Sin(4*math.Pi*y), math.Cos(4*math.Pi*y))
+	}
+	for _, s := range m.Steps {
+		var h float64
+		if !t.Before(s) {
+			h = 1
+		}
+		a = append(a, h)
+	}
+
+	return a
+}
+
+func years(t, epoch time.Time) float64 {
+	return t.Sub(epoch).Seconds() / secondsPerYear
+}
+
+// invert returns the inverse of the square matrix a using Gauss-Jordan elimination
+// with partial pivoting.  a is modified.
+func invert(a [][]float64) ([][]float64, error) {
+	n := len(a)
+
+	// the tolerance for a zero pivot is relative to the size of the values in a.
+	var tol float64
+	for i := range a {
+		for _, x := range a[i] {
+			tol = math.Max(tol, math.Abs(x))
+		}
+	}
+	tol = tol * 1e-12
+
+	inv := make([][]float64, n)
+	for i := range inv {
+		inv[i] = make([]float64, n)
+		inv[i][i] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		p := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[p][c]) {
+				p = r
+			}
+		}
+
+		if math.Abs(a[p][c]) <= tol {
+			return nil, ErrSingular
+		}
+
+		a[c], a[p] = a[p], a[c]
+		inv[c], inv[p] = inv[p], inv[c]
+
+		d := a[c][c]
+		for k := 0; k < n; k++ {
+			a[c][k] /= d
+			inv[c][k] /= d
+		}
+
+		for r := 0; r < n; r++ {
+			if r == c || a[r][c] == 0 {
+				continue
+			}
+			f := a[r][c]
+			for k := 0; k < n; k++ {
+				a[r][k] -= f * a[c][k]
+				inv[r][k] -= f * inv[c][k]
+			}
+		}
+	}
+
+	return inv, nil
+}
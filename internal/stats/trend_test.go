@@ -0,0 +1,134 @@
+package stats_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/GeoNet/fits/internal/stats"
+)
+
+const year = 365.25 * 24 * time.Hour
+
+// obs returns daily observations for n days from 2010-01-01 using f for the value.
+func obs(n int, f func(y float64) float64) (t []time.Time, v, e []float64) {
+	t0 := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < n; i++ {
+		ti := t0.Add(time.Duration(i) * 24 * time.Hour)
+		t = append(t, ti)
+		v = append(v, f(ti.Sub(t0).Seconds()/year.Seconds()))
+		e = append(e, 1.0)
+	}
+
+	return
+}
+
+func TestFitTrendLine(t *testing.T) {
+	tt, v, e := obs(1000, func(y float64) float64 { return 12.5 + 3.2*y })
+
+	tr, err := stats.FitTrend(tt, v, e, stats.TrendModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(tr.Slope-3.2) > 1e-9 {
+		t.Errorf("expected slope 3.2 got %f", tr.Slope)
+	}
+	if math.Abs(tr.Intercept-12.5) > 1e-9 {
+		t.Errorf("expected intercept 12.5 got %f", tr.Intercept)
+	}
+	if tr.RMS > 1e-9 {
+		t.Errorf("expected zero RMS got %f", tr.RMS)
+	}
+	if tr.Count != 1000 {
+		t.Errorf("expected count 1000 got %d", tr.Count)
+	}
+	if !tr.Weighted {
+		t.Error("expected weighted fit")
+	}
+	if !tr.Epoch.Equal(tt[0]) {
+		t.Errorf("expected epoch %s got %s", tt[0], tr.Epoch)
+	}
+	if math.Abs(tr.Value(tt[500])-v[500]) > 1e-9 {
+		t.Errorf("expected value %f got %f", v[500], tr.Value(tt[500]))
+	}
+}
+
+func TestFitTrendSeasonalStep(t *testing.T) {
+	step := time.Date(2011, 6, 1, 0, 0, 0, 0, time.UTC)
+	t0 := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	sy := step.Sub(t0).Seconds() / year.Seconds()
+
+	tt, v, e := obs(1500, func(y float64) float64 {
+		f := 1.0 - 2.0*y + 0.5*math.Sin(2*math.Pi*y) + 0.25*math.Cos(2*math.Pi*y) + 0.1*math.Cos(4*math.Pi*y)
+		if y >= sy {
+			f += 4.0
+		}
+		return f
+	})
+
+	tr, err := stats.FitTrend(tt, v, e, stats.TrendModel{Annual: true, SemiAnnual: true, Steps: []time.Time{step}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, expected, actual float64) {
+		if math.Abs(expected-actual) > 1e-6 {
+			t.Errorf("%s expected %f got %f", name, expected, actual)
+		}
+	}
+
+	check("slope", -2.0, tr.Slope)
+	check("intercept", 1.0, tr.Intercept)
+	check("annual sin", 0.5, tr.Annual.Sin)
+	check("annual cos", 0.25, tr.Annual.Cos)
+	check("semi-annual sin", 0, tr.SemiAnnual.Sin)
+	check("semi-annual cos", 0.1, tr.SemiAnnual.Cos)
+	check("step", 4.0, tr.Steps[0].Offset)
+}
+
+func TestFitTrendWeights(t *testing.T) {
+	tt, v, e := obs(100, func(y float64) float64 { return y })
+
+	// an outlier with a large error has little effect on the fit.
+	v[50] = 100
+	e[50] = 1e6
+
+	tr, err := stats.FitTrend(tt, v, e, stats.TrendModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(tr.Slope-1) > 1e-6 {
+		t.Errorf("expected slope 1 got %f", tr.Slope)
+	}
+
+	// unknown errors are not used when other observations have errors.
+	e[50] = 0
+
+	tr, err = stats.FitTrend(tt, v, e, stats.TrendModel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tr.Count != 99 {
+		t.Errorf("expected 99 observations used got %d", tr.Count)
+	}
+}
+
+func TestFitTrendErrors(t *testing.T) {
+	tt, v, e := obs(2, func(y float64) float64 { return y })
+
+	if _, err := stats.FitTrend(tt, v, e, stats.TrendModel{}); err != stats.ErrTooFewPoints {
+		t.Errorf("expected ErrTooFewPoints got %v", err)
+	}
+
+	tt, v, e = obs(100, func(y float64) float64 { return y })
+
+	// a step before the data can't be separated from the intercept.
+	_, err := stats.FitTrend(tt, v, e, stats.TrendModel{Steps: []time.Time{tt[0].Add(-time.Hour)}})
+	if err != stats.ErrSingular {
+		t.Errorf("expected ErrSingular got %v", err)
+	}
+}
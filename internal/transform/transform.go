@@ -0,0 +1,134 @@
+/*
+Package transform has transforms for observation time series e.g., the rate of change.
+Measurement errors are propagated assuming they are independent.
+*/
+package transform
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	Rate       = "rate"       // the change per day between successive points.
+	Diff       = "diff"       // the change between successive points.
+	Cumulative = "cumulative" // the running sum of the values.
+	Relative   = "relative"   // the value less the value at a reference epoch.
+)
+
+// Transform is a transform for a time series.  The zero value is no transform.
+type Transform struct {
+	Name  string    // Rate, Diff, Cumulative, or Relative.
+	Epoch time.Time // the reference epoch for Relative.  If zero the first point is used.
+}
+
+// Point is a value at a point in time.
+type Point struct {
+	DateTime time.Time
+	Value    float64
+	Error    float64
+}
+
+// Enabled returns true if t changes the series.
+func (t Transform) Enabled() bool {
+	return t.Name != ""
+}
+
+// Unit returns the unit for the transformed values for observations in unit.
+func (t Transform) Unit(unit string) string {
+	if t.Name == Rate {
+		return unit + "/day"
+	}
+
+	return unit
+}
+
+/*
+Apply returns the transformed points for in.  in must be in time order.  index is
+the index of the point in in that each transformed point is for.  For Rate and Diff
+this is the later of the two points and there is no transformed point for the first
+point.  Rate skips points at the same time as the previous point.
+*/
+func (t Transform) Apply(in []Point) (out []Point, index []int, err error) {
+	switch t.Name {
+	case "":
+		out = make([]Point, len(in))
+		index = make([]int, len(in))
+		for i := range in {
+			out[i] = in[i]
+			index[i] = i
+		}
+	case Rate:
+		for i := 1; i < len(in); i++ {
+			days := in[i].DateTime.Sub(in[i-1].DateTime).Hours() / 24
+			if days == 0 {
+				continue
+			}
+
+			out = append(out, Point{
+				DateTime: in[i].DateTime,
+				Value:    (in[i].Value - in[i-1].Value) / days,
+				Error:    math.Hypot(in[i].Error, in[i-1].Error) / math.Abs(days),
+			})
+			index = append(index, i)
+		}
+	case Diff:
+		for i := 1; i < len(in); i++ {
+			out = append(out, Point{
+				DateTime: in[i].DateTime,
+				Value:    in[i].Value - in[i-1].Value,
+				Error:    math.Hypot(in[i].Error, in[i-1].Error),
+			})
+			index = append(index, i)
+		}
+	case Cumulative:
+		var sum, ss float64
+		for i := range in {
+			sum += in[i].Value
+			ss += in[i].Error * in[i].Error
+			out = append(out, Point{DateTime: in[i].DateTime, Value: sum, Error: math.Sqrt(ss)})
+			index = append(index, i)
+		}
+	case Relative:
+		r := t.reference(in)
+		for i := range in {
+			p := Point{DateTime: in[i].DateTime}
+			// the reference point is exactly zero.
+			if i != r {
+				p.Value = in[i].Value - in[r].Value
+				p.Error = math.Hypot(in[i].Error, in[r].Error)
+			}
+			out = append(out, p)
+			index = append(index, i)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown transform: %s", t.Name)
+	}
+
+	return out, index, nil
+}
+
+// reference returns the index of the point in in closest to t.Epoch.  The earliest
+// point is used for ties or a zero Epoch.
+func (t Transform) reference(in []Point) int {
+	if t.Epoch.IsZero() {
+		return 0
+	}
+
+	var r int
+	d := time.Duration(math.MaxInt64)
+
+	for i := range in {
+		di := in[i].DateTime.Sub(t.Epoch)
+		if di < 0 {
+			di = -di
+		}
+		if di < d {
+			d = di
+			r = i
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,146 @@
+package transform_test
+
+import (
+	"math"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GeoNet/fits/internal/transform"
+)
+
+var t0 = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func day(d float64) time.Time {
+	return t0.Add(time.Duration(d * 24 * float64(time.Hour)))
+}
+
+func TestApply(t *testing.T) {
+	in := []transform.Point{
+		{DateTime: day(0), Value: 1, Error: 0.3},
+		{DateTime: day(2), Value: 5, Error: 0.4},
+		{DateTime: day(2), Value: 6, Error: 0},
+		{DateTime: day(2.5), Value: 4, Error: 0},
+	}
+
+	r := []struct {
+		t        transform.Transform
+		expected []transform.Point
+		index    []int
+		id       string
+	}{
+		{t: transform.Transform{}, expected: in, index: []int{0, 1, 2, 3}, id: loc()},
+		{
+			t: transform.Transform{Name: transform.Rate},
+			expected: []transform.Point{
+				{DateTime: day(2), Value: 2, Error: 0.25},
+				{DateTime: day(2.5), Value: -4, Error: 0},
+			},
+			index: []int{1, 3},
+			id:    loc(),
+		},
+		{
+			t: transform.Transform{Name: transform.Diff},
+			expected: []transform.Point{
+				{DateTime: day(2), Value: 4, Error: 0.5},
+				{DateTime: day(2), Value: 1, Error: 0.4},
+				{DateTime: day(2.5), Value: -2, Error: 0},
+			},
+			index: []int{1, 2, 3},
+			id:    loc(),
+		},
+		{
+			t: transform.Transform{Name: transform.Cumulative},
+			expected: []transform.Point{
+				{DateTime: day(0), Value: 1, Error: 0.3},
+				{DateTime: day(2), Value: 6, Error: 0.5},
+				{DateTime: day(2), Value: 12, Error: 0.5},
+				{DateTime: day(2.5), Value: 16, Error: 0.5},
+			},
+			index: []int{0, 1, 2, 3},
+			id:    loc(),
+		},
+		{
+			t: transform.Transform{Name: transform.Relative},
+			expected: []transform.Point{
+				{DateTime: day(0), Value: 0, Error: 0},
+				{DateTime: day(2), Value: 4, Error: 0.5},
+				{DateTime: day(2), Value: 5, Error: 0.3},
+				{DateTime: day(2.5), Value: 3, Error: 0.3},
+			},
+			index: []int{0, 1, 2, 3},
+			id:    loc(),
+		},
+		{
+			// the closest point to the epoch is the reference.  The earliest is used for a tie.
+			t: transform.Transform{Name: transform.Relative, Epoch: day(1.8)},
+			expected: []transform.Point{
+				{DateTime: day(0), Value: -4, Error: 0.5},
+				{DateTime: day(2), Value: 0, Error: 0},
+				{DateTime: day(2), Value: 1, Error: 0.4},
+				{DateTime: day(2.5), Value: -1, Error: 0.4},
+			},
+			index: []int{0, 1, 2, 3},
+			id:    loc(),
+		},
+	}
+
+	for _, v := range r {
+		out, index, err := v.t.Apply(in)
+		if err != nil {
+			t.Errorf("%s unexpected error: %s", v.id, err)
+			continue
+		}
+
+		if len(out) != len(v.expected) || len(index) != len(v.index) {
+			t.Errorf("%s expected %d points got %d", v.id, len(v.expected), len(out))
+			continue
+		}
+
+		for i := range out {
+			if !out[i].DateTime.Equal(v.expected[i].DateTime) {
+				t.Errorf("%s point %d expected time %s got %s", v.id, i, v.expected[i].DateTime, out[i].DateTime)
+			}
+			if math.Abs(out[i].Value-v.expected[i].Value) > 1e-9 {
+				t.Errorf("%s point %d expected value %f got %f", v.id, i, v.expected[i].Value, out[i].Value)
+			}
+			if math.Abs(out[i].Error-v.expected[i].Error) > 1e-9 {
+				t.Errorf("%s point %d expected error %f got %f", v.id, i, v.expected[i].Error, out[i].Error)
+			}
+			if index[i] != v.index[i] {
+				t.Errorf("%s point %d expected index %d got %d", v.id, i, v.index[i], index[i])
+			}
+		}
+	}
+
+	if _, _, err := (transform.Transform{Name: "log"}).Apply(in); err == nil {
+		t.Error("expected error for unknown transform")
+	}
+}
+
+func TestApplyEmpty(t *testing.T) {
+	for _, n := range []string{transform.Rate, transform.Diff, transform.Cumulative, transform.Relative} {
+		out, _, err := transform.Transform{Name: n}.Apply(nil)
+		if err != nil {
+			t.Errorf("%s unexpected error: %s", n, err)
+		}
+		if len(out) != 0 {
+			t.Errorf("%s expected no points got %d", n, len(out))
+		}
+	}
+}
+
+func TestUnit(t *testing.T) {
+	if u := (transform.Transform{Name: transform.Rate}).Unit("mm"); u != "mm/day" {
+		t.Errorf("expected mm/day got %s", u)
+	}
+	if u := (transform.Transform{Name: transform.Diff}).Unit("mm"); u != "mm" {
+		t.Errorf("expected mm got %s", u)
+	}
+}
+
+func loc() string {
+	_, _, l, _ := runtime.Caller(1)
+	return "L" + strconv.Itoa(l)
+}
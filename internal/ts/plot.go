@@ -9,6 +9,8 @@ import (
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/GeoNet/fits/internal/transform"
 )
 
 // mix of public and private types to keep the public API small.
@@ -38,6 +40,12 @@ type plt struct {
 	xShift                        int
 	Scheme                        string
 	Fill                          bool
+	Markers                       []Marker
+	MarkerPts                     []pt // markers on the x axis, labelled with the marker label.
+	Trend                         data // a fitted model drawn over the data.
+	OutlierPts                    []pt // outliers in the Data, drawn separately and not used for auto ranging.
+	SuspectPts                    []pt // suspect points in the Data, marked over the data.
+	QualifiedPts                  []pt // qualified points in the Data, marked over the data.  The label is the qualifier.
 }
 
 type plotKey struct {
@@ -54,6 +62,11 @@ type Point struct {
 	DateTime time.Time
 	Value    float64
 	Error    float64
+	Outlier  bool // outliers are drawn with a different marker and are not used to range the y axis.
+	Suspect  bool // suspect points are drawn with the data and marked.
+	// Qualifier is < for a value below the detection limit, > for a value above the range of the method,
+	// ~ for an approximate value, or empty.  Qualified points are drawn with the data and marked.
+	Qualifier string
 }
 
 /*
@@ -74,11 +87,45 @@ type stddev struct {
 
 type pts []pt
 
+// Marker is an event at a point in time e.g., a visual observation.
+// It is drawn on the time axis with the Label as a tooltip.
+type Marker struct {
+	DateTime time.Time
+	Label    string
+}
+
 type Series struct {
 	Points []Point
 	Label  string
 }
 
+// Transform applies t to the Points in s.  Points must be in time order.
+func (s *Series) Transform(t transform.Transform) error {
+	if !t.Enabled() {
+		return nil
+	}
+
+	in := make([]transform.Point, len(s.Points))
+	for i, p := range s.Points {
+		in[i] = transform.Point{DateTime: p.DateTime, Value: p.Value, Error: p.Error}
+	}
+
+	out, index, err := t.Apply(in)
+	if err != nil {
+		return err
+	}
+
+	points := make([]Point, len(out))
+	for i, p := range out {
+		points[i] = Point{DateTime: p.DateTime, Value: p.Value, Error: p.Error, Outlier: s.Points[index[i]].Outlier, Suspect: s.Points[index[i]].Suspect,
+			Qualifier: s.Points[index[i]].Qualifier}
+	}
+
+	s.Points = points
+
+	return nil
+}
+
 type data struct {
 	Series    Series
 	Colour    string // svg colour name
@@ -125,6 +172,15 @@ func (p *Plot) AddSeries(s Series) {
 	p.plt.Data = append(p.plt.Data, data{Series: s})
 }
 
+// SetTrend sets a fitted model to draw over the data.  The label is added to the key.
+func (p *Plot) SetTrend(s Series) {
+	p.plt.Trend = data{Series: s}
+}
+
+func (p *Plot) AddMarker(m Marker) {
+	p.plt.Markers = append(p.plt.Markers, m)
+}
+
 func (p *Plot) SetScheme(s string) {
 	p.plt.Scheme = s
 }
@@ -207,6 +263,14 @@ func (p *Plot) setKey() {
 		y = y + 5
 	}
 
+	if p.plt.Trend.Series.Label != "" {
+		y = y + 5
+		p.plt.PlotKey = append(p.plt.PlotKey, plotKey{Text: []pt{
+			{X: 0, Y: y, L: p.plt.Trend.Series.Label},
+		}, Fill: p.plt.Fill})
+		y = y + 13
+	}
+
 	if p.plt.Stddev.Show {
 		// no marker for stddev
 		y = y + 5
@@ -222,6 +286,10 @@ func (p *Plot) scaleData() {
 	p.plt.Min.Value = math.MaxFloat64
 	p.plt.First.DateTime = time.Now().UTC()
 
+	// first and last are the first and last points that are not outliers.
+	var first, last Point
+	var measured bool
+
 	for i, d := range p.plt.Data {
 
 		ldp := len(d.Series.Points)
@@ -238,6 +306,16 @@ func (p *Plot) scaleData() {
 			if !p.plt.Data[i].HasErrors && point.Error > 0.0 {
 				p.plt.Data[i].HasErrors = true
 			}
+			if point.Outlier {
+				continue
+			}
+			if !measured || point.DateTime.Before(first.DateTime) {
+				first = point
+			}
+			if !measured || !point.DateTime.Before(last.DateTime) {
+				last = point
+			}
+			measured = true
 			if point.Value > p.plt.Max.Value {
 				p.plt.Max = point
 			}
@@ -247,15 +325,23 @@ func (p *Plot) scaleData() {
 		}
 	}
 
+	// the x axis is for all the points, including outliers.
+	start := p.plt.First.DateTime
+
 	// if the x axis length wasn't explicitly set then autorange on the data
 	if (p.plt.XMin == time.Time{} && p.plt.XMax == time.Time{}) {
-		p.plt.XMin = p.plt.First.DateTime
+		p.plt.XMin = start
 		p.plt.XMax = p.plt.Last.DateTime
 	}
 
 	p.plt.dx = float64(p.plt.width) / p.plt.XMax.Sub(p.plt.XMin).Seconds()
-	if p.plt.XMin.Before(p.plt.First.DateTime) {
-		p.plt.xShift = int((p.plt.First.DateTime.Sub(p.plt.XMin).Seconds() * p.plt.dx) + 0.5)
+	if p.plt.XMin.Before(start) {
+		p.plt.xShift = int((start.Sub(p.plt.XMin).Seconds() * p.plt.dx) + 0.5)
+	}
+
+	// the first and last points, and the latest value, are not outliers unless they all are.
+	if measured {
+		p.plt.First, p.plt.Last = first, last
 	}
 
 	switch {
@@ -281,35 +367,81 @@ func (p *Plot) scaleData() {
 		p.plt.dy = float64(p.plt.height) / math.Abs(p.plt.YMax-p.plt.YMin)
 	}
 
+	p.plt.OutlierPts = nil
+	p.plt.SuspectPts = nil
+	p.plt.QualifiedPts = nil
 	for i := range p.plt.Data {
-		p.plt.Data[i].Pts = make([]pt, len(p.plt.Data[i].Series.Points))
+		p.plt.Data[i].Pts = make([]pt, 0, len(p.plt.Data[i].Series.Points))
 
 		for j := range p.plt.Data[i].Series.Points {
-			p.plt.Data[i].Pts[j] = pt{
-				X: int((p.plt.Data[i].Series.Points[j].DateTime.Sub(p.plt.First.DateTime).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
+			v := pt{
+				X: int((p.plt.Data[i].Series.Points[j].DateTime.Sub(start).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
 				Y: p.plt.height - int(((p.plt.Data[i].Series.Points[j].Value-p.plt.YMin)*p.plt.dy)+0.5),
 				E: int(p.plt.Data[i].Series.Points[j].Error * p.plt.dy),
 			}
+
+			if !p.plt.Data[i].Series.Points[j].Outlier {
+				p.plt.Data[i].Pts = append(p.plt.Data[i].Pts, v)
+				if p.plt.Data[i].Series.Points[j].Suspect {
+					p.plt.SuspectPts = append(p.plt.SuspectPts, v)
+				}
+				if q := p.plt.Data[i].Series.Points[j].Qualifier; q != "" {
+					p.plt.QualifiedPts = append(p.plt.QualifiedPts, pt{X: v.X, Y: v.Y, L: q})
+				}
+				continue
+			}
+
+			// outliers are usually outside the y range.  Draw them at the edge of the plot.
+			v.E = 0
+			switch {
+			case v.Y < 0:
+				v.Y = 0
+			case v.Y > p.plt.height:
+				v.Y = p.plt.height
+			}
+			p.plt.OutlierPts = append(p.plt.OutlierPts, v)
 		}
 	}
 
 	p.plt.MinPt = pt{
-		X: int((p.plt.Min.DateTime.Sub(p.plt.First.DateTime).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
+		X: int((p.plt.Min.DateTime.Sub(start).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
 		Y: p.plt.height - int(((p.plt.Min.Value-p.plt.YMin)*p.plt.dy)+0.5),
 	}
 	p.plt.MaxPt = pt{
-		X: int((p.plt.Max.DateTime.Sub(p.plt.First.DateTime).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
+		X: int((p.plt.Max.DateTime.Sub(start).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
 		Y: p.plt.height - int(((p.plt.Max.Value-p.plt.YMin)*p.plt.dy)+0.5),
 	}
 	p.plt.FirstPt = pt{
-		X: int((p.plt.First.DateTime.Sub(p.plt.First.DateTime).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
+		X: int((p.plt.First.DateTime.Sub(start).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
 		Y: p.plt.height - int(((p.plt.First.Value-p.plt.YMin)*p.plt.dy)+0.5),
 	}
 	p.plt.LastPt = pt{
-		X: int((p.plt.Last.DateTime.Sub(p.plt.First.DateTime).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
+		X: int((p.plt.Last.DateTime.Sub(start).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
 		Y: p.plt.height - int(((p.plt.Last.Value-p.plt.YMin)*p.plt.dy)+0.5),
 	}
 
+	p.plt.Trend.Pts = make([]pt, len(p.plt.Trend.Series.Points))
+	for j, v := range p.plt.Trend.Series.Points {
+		p.plt.Trend.Pts[j] = pt{
+			X: int((v.DateTime.Sub(start).Seconds()*p.plt.dx)+0.5) + p.plt.xShift,
+			Y: p.plt.height - int(((v.Value-p.plt.YMin)*p.plt.dy)+0.5),
+		}
+	}
+
+	// markers are drawn against the x axis so any outside it are dropped.
+	p.plt.MarkerPts = nil
+	for _, m := range p.plt.Markers {
+		if m.DateTime.Before(p.plt.XMin) || m.DateTime.After(p.plt.XMax) {
+			continue
+		}
+
+		p.plt.MarkerPts = append(p.plt.MarkerPts, pt{
+			X: int((m.DateTime.Sub(p.plt.XMin).Seconds() * p.plt.dx) + 0.5),
+			Y: p.plt.height,
+			L: m.Label,
+		})
+	}
+
 	if p.plt.MinPt.Y > p.plt.height {
 		p.plt.RangeAlert = true
 	}
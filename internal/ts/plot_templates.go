@@ -2,6 +2,7 @@ package ts
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
 	"strings"
 	"text/template"
@@ -12,6 +13,11 @@ var funcMap = template.FuncMap{
 	"date": func(t time.Time) string {
 		return strings.Split(t.Format(time.RFC3339), "T")[0]
 	},
+	"escape": func(s string) string {
+		var b bytes.Buffer
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
 }
 
 type SVGPlot struct {
@@ -56,6 +62,38 @@ func (p pt) ErrorBar() string {
 	return fmt.Sprintf("%d,%d %d,%d", p.X, p.Y+p.E, p.X, p.Y-p.E)
 }
 
+// MarkerPoly is a triangle pointing up at the time axis.
+func (p pt) MarkerPoly() string {
+	return fmt.Sprintf("%d,%d %d,%d %d,%d", p.X, p.Y-6, p.X-5, p.Y+4, p.X+5, p.Y+4)
+}
+
+// Cross is an svg path for an x centred on the point.
+func (p pt) Cross(size int) string {
+	return fmt.Sprintf("M%d,%d L%d,%d M%d,%d L%d,%d", p.X-size, p.Y-size, p.X+size, p.Y+size, p.X-size, p.Y+size, p.X+size, p.Y-size)
+}
+
+// Diamond is an svg path for a diamond centred on the point.
+func (p pt) Diamond(size int) string {
+	return fmt.Sprintf("M%d,%d L%d,%d L%d,%d L%d,%d Z", p.X, p.Y-size, p.X+size, p.Y, p.X, p.Y+size, p.X-size, p.Y)
+}
+
+/*
+Qualified is an svg path for the qualifier in the point label.  It is an arrow pointing down from the
+point for < (the value is at most the point), an arrow pointing up for >, and a circle around the point for ~.
+*/
+func (p pt) Qualified(size int) string {
+	switch p.L {
+	case "<":
+		return fmt.Sprintf("M%d,%d L%d,%d M%d,%d L%d,%d L%d,%d", p.X, p.Y, p.X, p.Y+3*size,
+			p.X-size, p.Y+2*size, p.X, p.Y+3*size, p.X+size, p.Y+2*size)
+	case ">":
+		return fmt.Sprintf("M%d,%d L%d,%d M%d,%d L%d,%d L%d,%d", p.X, p.Y, p.X, p.Y-3*size,
+			p.X-size, p.Y-2*size, p.X, p.Y-3*size, p.X+size, p.Y-2*size)
+	default:
+		return fmt.Sprintf("M%d,%d a%d,%d 0 1,0 %d,0 a%d,%d 0 1,0 %d,0", p.X-size, p.Y, size, size, 2*size, size, size, -2*size)
+	}
+}
+
 func (p pts) ErrorPoly() string {
 	var b bytes.Buffer
 
@@ -132,6 +170,21 @@ const plotBaseTemplate = `<?xml version="1.0"?>
 <polyline fill="none" stroke="gainsboro" stroke-width="1.0" points="0,{{.Stddev.M}} {{600}},{{.Stddev.M}}"/>
 {{end}}
 {{template "data" .}}
+{{if .Trend.Pts}}
+<polyline fill="none" stroke="black" stroke-width="1.5" stroke-dasharray="6,3" points="{{range .Trend.Pts}}{{.X}},{{.Y}} {{end}}" />
+{{end}}
+{{range .OutlierPts}}
+<path fill="none" stroke="red" stroke-width="1.5" d="{{.Cross 4}}"/>
+{{end}}
+{{range .SuspectPts}}
+<path fill="none" stroke="darkorange" stroke-width="1.5" d="{{.Diamond 5}}"/>
+{{end}}
+{{range .QualifiedPts}}
+<path fill="none" stroke="purple" stroke-width="1.5" d="{{.Qualified 4}}"><title>{{escape .L}}</title></path>
+{{end}}
+{{range .MarkerPts}}
+<polygon fill="orange" fill-opacity="0.75" stroke="darkorange" stroke-width="1" points="{{.MarkerPoly}}"><title>{{escape .L}}</title></polygon>
+{{end}}
 <circle cx="{{.LastPt.X}}" cy="{{.LastPt.Y}}" r="4" stroke="red" fill="{{if .Fill}}red{{else}}none{{end}}" />
 <circle cx="{{.MinPt.X}}" cy="{{.MinPt.Y}}" r="4" stroke="blue" fill="{{if .Fill}}blue{{else}}none{{end}}" />
 <circle cx="{{.MaxPt.X}}" cy="{{.MaxPt.Y}}" r="4" stroke="blue" fill="{{if .Fill}}blue{{else}}none{{end}}" />
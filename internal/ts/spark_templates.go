@@ -69,6 +69,7 @@ const sparkAllBaseTemplate = `<?xml version="1.0"?>
 {{if .RangeAlert}}<rect x="0" y="0" width="100" height="20" fill="mistyrose"/>{{end}}
 {{template "stddev" .Stddev}}
 {{template "data" .Data}}
+{{range .OutlierPts}}<path fill="none" stroke="red" stroke-width="1.0" d="{{.Cross 2}}"/>{{end}}{{range .SuspectPts}}<path fill="none" stroke="darkorange" stroke-width="1.0" d="{{.Diamond 2}}"/>{{end}}{{range .QualifiedPts}}<path fill="none" stroke="purple" stroke-width="1.0" d="{{.Qualified 2}}"/>{{end}}
 <circle cx="{{.LastPt.X}}" cy="{{.LastPt.Y}}" r="3" stroke="red" fill="none" />
 <circle cx="{{.MinPt.X}}" cy="{{.MinPt.Y}}" r="3" stroke="blue" fill="none" />
 <circle cx="{{.MaxPt.X}}" cy="{{.MaxPt.Y}}" r="3" stroke="blue" fill="none" />
@@ -87,7 +88,8 @@ const sparkLatestBaseTemplate = `<?xml version="1.0"?>
 <g transform="translate(3,4)"> 
 {{if .RangeAlert}}<rect x="0" y="0" width="100" height="20" fill="mistyrose"/>{{end}}
 {{template "stddev" .Stddev}}
-{{template "data" .Data}}<circle cx="{{.LastPt.X}}" cy="{{.LastPt.Y}}" r="3" stroke="red" fill="none" />
+{{template "data" .Data}}
+{{range .OutlierPts}}<path fill="none" stroke="red" stroke-width="1.0" d="{{.Cross 2}}"/>{{end}}{{range .SuspectPts}}<path fill="none" stroke="darkorange" stroke-width="1.0" d="{{.Diamond 2}}"/>{{end}}{{range .QualifiedPts}}<path fill="none" stroke="purple" stroke-width="1.0" d="{{.Qualified 2}}"/>{{end}}<circle cx="{{.LastPt.X}}" cy="{{.LastPt.Y}}" r="3" stroke="red" fill="none" />
 </g>
 <text font-style="italic" fill="black" x="110" y="19" text-anchor="start"><tspan fill="red">{{ printf "%.2f" .Last.Value}} {{.Unit}}</tspan> ({{date .Last.DateTime}})</text>
 </svg>	
@@ -100,6 +102,7 @@ const sparkNoneBaseTemplate = `<?xml version="1.0"?>
 {{if .RangeAlert}}<rect x="0" y="0" width="100" height="20" fill="mistyrose"/>{{end}}
 {{template "stddev" .Stddev}}
 {{template "data" .Data}}
+{{range .OutlierPts}}<path fill="none" stroke="red" stroke-width="1.0" d="{{.Cross 2}}"/>{{end}}{{range .SuspectPts}}<path fill="none" stroke="darkorange" stroke-width="1.0" d="{{.Diamond 2}}"/>{{end}}{{range .QualifiedPts}}<path fill="none" stroke="purple" stroke-width="1.0" d="{{.Qualified 2}}"/>{{end}}
 </g>
 </svg>	
 `
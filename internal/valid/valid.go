@@ -3,6 +3,7 @@ package valid
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -19,6 +20,9 @@ var (
 	srsRE, srsErr       = regexp.Compile(`^EPSG:[0-9]+$`)
 	withinRE, withinErr = regexp.Compile(`^POLYGON\(\([0-9\-\, \.\+]+\)\)$`)
 	bboxRE, bboxErr     = regexp.Compile(`^[0-9\-\, \.\+]+$`)
+	searchRE, searchErr = regexp.Compile(`^[\p{L}\p{M}\p{N} '\-\.\,\(\)]{1,100}$`)
+	unitRE, unitErr     = regexp.Compile(`^[\p{L}\p{M}\p{N}°%/\.\-\^\*·]{1,20}$`)
+	reasonRE, reasonErr = regexp.Compile(`^[\p{L}\p{M}\p{N}\p{P}\p{S} ]{1,200}$`)
 )
 
 type validator func(string) error
@@ -41,40 +45,90 @@ func (s Error) Status() int {
 }
 
 var valid = map[string]validator{
-	"days":       days,
-	"start":      start,
-	"siteID":     text,
-	"networkID":  text, // networkID has been dropped from the API but is still allowed in the query for backward compatibility.
-	"typeID":     text,
-	"methodID":   text,
-	"sites":      text,
-	"srsName":    srsName,
-	"within":     within,
-	"width":      width,
-	"type":       validType,
-	"stddev":     stddev,
-	"showMethod": showMethod,
-	"scheme":     scheme,
-	"label":      label,
-	"yrange":     yRange,
-	"bbox":       bbox,
-	"insetBbox":  bbox,
+	"days":        days,
+	"start":       start,
+	"end":         end,
+	"siteID":      text,
+	"networkID":   text, // networkID has been dropped from the API but is still allowed in the query for backward compatibility.
+	"typeID":      text,
+	"methodID":    text,
+	"systemID":    text,
+	"sampleID":    text,
+	"sites":       text,
+	"srsName":     srsName,
+	"within":      within,
+	"width":       width,
+	"type":        validType,
+	"stddev":      stddev,
+	"showMethod":  showMethod,
+	"showVisual":  showVisual,
+	"scheme":      scheme,
+	"label":       label,
+	"yrange":      yRange,
+	"bbox":        bbox,
+	"insetBbox":   bbox,
+	"interval":    interval,
+	"aggregate":   aggregate,
+	"percentiles": percentiles,
+	"trend":       trend,
+	"annual":      annual,
+	"semiAnnual":  semiAnnual,
+	"steps":       steps,
+	"outliers":    outliers,
+	"threshold":   threshold,
+	"transform":   transform,
+	"epoch":       epoch,
+	"near":        near,
+	"radius":      radius,
+	"limit":       limit,
+	"inventory":   inventory,
+	"q":           search,
+	"unit":        unit,
+	"asOf":        asOf,
+	"time":        obsTime,
+	"reason":      reason,
+	"quality":     quality,
 }
 
+// aggregate
+// annual
+// asOf
 // bbox
 // days
+// end
+// epoch
 // insetBbox
+// interval
+// inventory
 // label
+// limit
 // methodID
+// near
 // networkID
+// outliers
+// percentiles
+// q
+// quality
+// radius
+// reason
+// sampleID
 // scheme
+// semiAnnual
 // showMethod
+// showVisual
 // siteID
 // sites
 // start
 // stddev
+// steps
 // srsName
+// systemID
+// threshold
+// time
+// transform
+// trend
 // typeID
+// unit
 // width
 // within
 // yrange
@@ -157,6 +211,194 @@ func scheme(s string) error {
 	}
 }
 
+func interval(s string) error {
+	switch s {
+	case `day`, `week`, `month`, `year`:
+		return nil
+	default:
+		return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid interval: %s", s)}
+	}
+}
+
+func aggregate(s string) error {
+	switch s {
+	case `mean`, `median`, `min`, `max`:
+		return nil
+	default:
+		return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid aggregate: %s", s)}
+	}
+}
+
+func transform(s string) error {
+	switch s {
+	case `rate`, `diff`, `cumulative`, `relative`:
+		return nil
+	default:
+		return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid transform: %s", s)}
+	}
+}
+
+func outliers(s string) error {
+	switch s {
+	case `mad`, `iqr`:
+		return nil
+	default:
+		return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid outliers: %s", s)}
+	}
+}
+
+// ParseThreshold parses the threshold for outlier detection.  Must be greater than zero.
+func ParseThreshold(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(f > 0) || math.IsInf(f, 1) {
+		return 0, Error{Code: http.StatusBadRequest, Err: errors.New("invalid threshold query param")}
+	}
+
+	return f, nil
+}
+
+func threshold(s string) error {
+	_, err := ParseThreshold(s)
+	return err
+}
+
+// ParseNear parses a lon,lat point e.g., 176.2,-38.7.  The longitude must be in the
+// range -180 to 180 and the latitude -90 to 90.
+func ParseNear(s string) (lon, lat float64, err error) {
+	if s == "" {
+		return
+	}
+
+	p := strings.Split(s, ",")
+	if len(p) != 2 {
+		return 0, 0, Error{Code: http.StatusBadRequest, Err: errors.New("invalid near query param")}
+	}
+
+	lon, err = strconv.ParseFloat(p[0], 64)
+	if err != nil || !(lon >= -180 && lon <= 180) {
+		return 0, 0, Error{Code: http.StatusBadRequest, Err: errors.New("invalid near query param")}
+	}
+
+	lat, err = strconv.ParseFloat(p[1], 64)
+	if err != nil || !(lat >= -90 && lat <= 90) {
+		return 0, 0, Error{Code: http.StatusBadRequest, Err: errors.New("invalid near query param")}
+	}
+
+	return lon, lat, nil
+}
+
+func near(s string) error {
+	_, _, err := ParseNear(s)
+	return err
+}
+
+// ParseRadius parses a search radius in km.  Must be greater than zero.
+func ParseRadius(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(f > 0) || math.IsInf(f, 1) {
+		return 0, Error{Code: http.StatusBadRequest, Err: errors.New("invalid radius query param")}
+	}
+
+	return f, nil
+}
+
+func radius(s string) error {
+	_, err := ParseRadius(s)
+	return err
+}
+
+// ParseLimit parses the number of nearest sites to return.  Range is 1-1000.
+func ParseLimit(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 1000 {
+		return 0, Error{Code: http.StatusBadRequest, Err: errors.New("invalid limit query param")}
+	}
+
+	return n, nil
+}
+
+func limit(s string) error {
+	_, err := ParseLimit(s)
+	return err
+}
+
+// search is text to search for e.g., a site name.  Letters can have accents.
+func search(s string) error {
+	if searchErr != nil {
+		return searchErr
+	}
+
+	if strings.TrimSpace(s) != "" && searchRE.MatchString(s) {
+		return nil
+	}
+
+	return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid search: %s", s)}
+}
+
+// reason is why observations were changed e.g., "corrected lab result".
+func reason(s string) error {
+	if reasonErr != nil {
+		return reasonErr
+	}
+
+	if strings.TrimSpace(s) != "" && reasonRE.MatchString(s) {
+		return nil
+	}
+
+	return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid reason: %s", s)}
+}
+
+// ParseQuality parses a comma separated list of quality states e.g., raw,verified.
+// An empty list selects observations of any quality.
+func ParseQuality(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var q []string
+
+	for _, v := range strings.Split(s, ",") {
+		switch v {
+		case `raw`, `verified`, `suspect`, `rejected`:
+			q = append(q, v)
+		default:
+			return nil, Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid quality: %s", v)}
+		}
+	}
+
+	return q, nil
+}
+
+func quality(s string) error {
+	_, err := ParseQuality(s)
+	return err
+}
+
+// unit is a unit symbol e.g., °C or t/d.
+func unit(s string) error {
+	if unitErr != nil {
+		return unitErr
+	}
+
+	if unitRE.MatchString(s) {
+		return nil
+	}
+
+	return Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid unit: %s", s)}
+}
+
 func within(s string) error {
 	if withinErr != nil {
 		return withinErr
@@ -221,6 +463,32 @@ func days(s string) error {
 	return err
 }
 
+// ParsePercentiles parses a comma separated list of percentiles e.g., 5,95.
+// Each must be from 0 to 100.
+func ParsePercentiles(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var p []float64
+
+	for _, v := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f >= 0 && f <= 100) {
+			return nil, Error{Code: http.StatusBadRequest, Err: errors.New("invalid percentiles query param")}
+		}
+
+		p = append(p, f)
+	}
+
+	return p, nil
+}
+
+func percentiles(s string) error {
+	_, err := ParsePercentiles(s)
+	return err
+}
+
 func ParseStart(s string) (time.Time, error) {
 	if s == "" {
 		return time.Time{}, nil
@@ -239,6 +507,70 @@ func start(s string) error {
 	return err
 }
 
+// ParseSteps parses a comma separated list of RFC3339 date times.
+func ParseSteps(s string) ([]time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var t []time.Time
+
+	for _, v := range strings.Split(s, ",") {
+		d, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid steps date: %s", v)}
+		}
+
+		t = append(t, d)
+	}
+
+	return t, nil
+}
+
+func steps(s string) error {
+	_, err := ParseSteps(s)
+	return err
+}
+
+func ParseEnd(s string) (time.Time, error) {
+	return ParseStart(s)
+}
+
+func end(s string) error {
+	_, err := ParseEnd(s)
+	return err
+}
+
+// ParseAsOf parses the time to show the observations as they were at.
+func ParseAsOf(s string) (time.Time, error) {
+	return ParseStart(s)
+}
+
+func asOf(s string) error {
+	_, err := ParseAsOf(s)
+	return err
+}
+
+// ParseTime parses the time of an observation.
+func ParseTime(s string) (time.Time, error) {
+	return ParseStart(s)
+}
+
+func obsTime(s string) error {
+	_, err := ParseTime(s)
+	return err
+}
+
+// ParseEpoch parses the reference epoch for a relative transform.
+func ParseEpoch(s string) (time.Time, error) {
+	return ParseStart(s)
+}
+
+func epoch(s string) error {
+	_, err := ParseEpoch(s)
+	return err
+}
+
 func srsName(s string) error {
 	if srsErr != nil {
 		return srsErr
@@ -270,6 +602,61 @@ func stddev(s string) error {
 }
 
 func ParseShowMethod(s string) (bool, error) {
+	return parseBool("showMethod", s)
+}
+
+func showMethod(s string) error {
+	_, err := ParseShowMethod(s)
+	return err
+}
+
+func ParseShowVisual(s string) (bool, error) {
+	return parseBool("showVisual", s)
+}
+
+func showVisual(s string) error {
+	_, err := ParseShowVisual(s)
+	return err
+}
+
+func ParseInventory(s string) (bool, error) {
+	return parseBool("inventory", s)
+}
+
+func inventory(s string) error {
+	_, err := ParseInventory(s)
+	return err
+}
+
+func ParseTrend(s string) (bool, error) {
+	return parseBool("trend", s)
+}
+
+func trend(s string) error {
+	_, err := ParseTrend(s)
+	return err
+}
+
+func ParseAnnual(s string) (bool, error) {
+	return parseBool("annual", s)
+}
+
+func annual(s string) error {
+	_, err := ParseAnnual(s)
+	return err
+}
+
+func ParseSemiAnnual(s string) (bool, error) {
+	return parseBool("semiAnnual", s)
+}
+
+func semiAnnual(s string) error {
+	_, err := ParseSemiAnnual(s)
+	return err
+}
+
+// parseBool parses the value s for the query parameter k.  Empty is false.
+func parseBool(k, s string) (bool, error) {
 	switch s {
 	case ``:
 		return false, nil
@@ -278,15 +665,10 @@ func ParseShowMethod(s string) (bool, error) {
 	case `false`:
 		return false, nil
 	default:
-		return false, Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid showMethod value: %s", s)}
+		return false, Error{Code: http.StatusBadRequest, Err: fmt.Errorf("invalid %s value: %s", k, s)}
 	}
 }
 
-func showMethod(s string) error {
-	_, err := ParseShowMethod(s)
-	return err
-}
-
 func yRange(s string) error {
 	_, _, err := ParseYrange(s)
 	return err
@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/GeoNet/fits/internal/valid"
@@ -25,6 +26,9 @@ func TestQuery(t *testing.T) {
 		{k: "start", v: "2017-01-11T12:12:12Z", id: loc()},
 		{k: "start", v: "2017", err: bad, id: loc()},
 
+		{k: "end", v: "2017-06-30T00:00:00Z", id: loc()},
+		{k: "end", v: "2017-06-30", err: bad, id: loc()},
+
 		{k: "siteID", v: "TEST"},
 
 		{k: "methodID", v: "m1"},
@@ -32,6 +36,10 @@ func TestQuery(t *testing.T) {
 
 		{k: "typeID", v: "t1"},
 
+		{k: "systemID", v: "lab"},
+		{k: "sampleID", v: "0001"},
+		{k: "sampleID", v: "0001;", err: bad, id: loc()},
+
 		{k: "networkID", v: "VO"}, // networkID is unused but allowed in the api query parameters
 
 		{k: "srsName", v: "EPSG:4326"},
@@ -51,6 +59,11 @@ func TestQuery(t *testing.T) {
 
 		{k: "showMethod", v: "true"},
 		{k: "showMethod", v: "false"},
+		{k: "showMethod", v: "yes", err: bad, id: loc()},
+
+		{k: "showVisual", v: "true"},
+		{k: "showVisual", v: "false"},
+		{k: "showVisual", v: "1", err: bad, id: loc()},
 
 		{k: "scheme", v: "web"},
 		{k: "scheme", v: "projector"},
@@ -66,6 +79,113 @@ func TestQuery(t *testing.T) {
 		{k: "bbox", v: "WhiteIsland"},
 
 		{k: "insetBbox", v: "NewZealand"},
+
+		{k: "interval", v: "day"},
+		{k: "interval", v: "week"},
+		{k: "interval", v: "month"},
+		{k: "interval", v: "year"},
+		{k: "interval", v: "fortnight", err: bad, id: loc()},
+
+		{k: "aggregate", v: "mean"},
+		{k: "aggregate", v: "median"},
+		{k: "aggregate", v: "min"},
+		{k: "aggregate", v: "max"},
+		{k: "aggregate", v: "mode", err: bad, id: loc()},
+
+		{k: "percentiles", v: "5,95"},
+		{k: "percentiles", v: "0,2.5,100"},
+		{k: "percentiles", v: "101", err: bad, id: loc()},
+		{k: "percentiles", v: "-1", err: bad, id: loc()},
+		{k: "percentiles", v: "5,,95", err: bad, id: loc()},
+		{k: "percentiles", v: "NaN", err: bad, id: loc()},
+
+		{k: "trend", v: "true"},
+		{k: "trend", v: "on", err: bad, id: loc()},
+		{k: "annual", v: "true"},
+		{k: "annual", v: "1", err: bad, id: loc()},
+		{k: "semiAnnual", v: "false"},
+		{k: "semiAnnual", v: "no", err: bad, id: loc()},
+
+		{k: "steps", v: "2016-11-13T11:02:56Z"},
+		{k: "steps", v: "2016-11-13T11:02:56Z,2019-01-01T00:00:00Z"},
+		{k: "steps", v: "2016-11-13", err: bad, id: loc()},
+		{k: "steps", v: "2016-11-13T11:02:56Z,", err: bad, id: loc()},
+
+		{k: "outliers", v: "mad"},
+		{k: "outliers", v: "iqr"},
+		{k: "outliers", v: "zscore", err: bad, id: loc()},
+		{k: "threshold", v: "3"},
+		{k: "threshold", v: "2.5"},
+		{k: "threshold", v: "0", err: bad, id: loc()},
+		{k: "threshold", v: "-1", err: bad, id: loc()},
+		{k: "threshold", v: "NaN", err: bad, id: loc()},
+		{k: "threshold", v: "Inf", err: bad, id: loc()},
+
+		{k: "transform", v: "rate"},
+		{k: "transform", v: "diff"},
+		{k: "transform", v: "cumulative"},
+		{k: "transform", v: "relative"},
+		{k: "transform", v: "log", err: bad, id: loc()},
+		{k: "epoch", v: "2016-11-13T11:02:56Z"},
+		{k: "epoch", v: "2016-11-13", err: bad, id: loc()},
+
+		{k: "near", v: "176.2,-38.7"},
+		{k: "near", v: "-176.5,-44"},
+		{k: "near", v: "176.2", err: bad, id: loc()},
+		{k: "near", v: "176.2,-38.7,1", err: bad, id: loc()},
+		{k: "near", v: "181,-38.7", err: bad, id: loc()},
+		{k: "near", v: "176.2,-91", err: bad, id: loc()},
+		{k: "near", v: "NaN,-38.7", err: bad, id: loc()},
+		{k: "radius", v: "10"},
+		{k: "radius", v: "0.5"},
+		{k: "radius", v: "0", err: bad, id: loc()},
+		{k: "radius", v: "-10", err: bad, id: loc()},
+		{k: "radius", v: "Inf", err: bad, id: loc()},
+		{k: "limit", v: "1"},
+		{k: "limit", v: "1000"},
+		{k: "limit", v: "0", err: bad, id: loc()},
+		{k: "limit", v: "1001", err: bad, id: loc()},
+		{k: "limit", v: "2.5", err: bad, id: loc()},
+
+		{k: "inventory", v: "true"},
+		{k: "inventory", v: "false"},
+		{k: "inventory", v: "yes", err: bad, id: loc()},
+
+		{k: "q", v: "Ruapehu"},
+		{k: "q", v: "ru001"},
+		{k: "q", v: "Te Maari (Tongariro)"},
+		{k: "q", v: "Taupō"},
+		{k: "q", v: "O'Neill-Ridge"},
+		{k: "q", v: " ", err: bad, id: loc()},
+		{k: "q", v: "", err: bad, id: loc()},
+		{k: "q", v: "a%", err: bad, id: loc()},
+		{k: "q", v: "a_b", err: bad, id: loc()},
+		{k: "q", v: "a;drop", err: bad, id: loc()},
+
+		{k: "unit", v: "°C"},
+		{k: "unit", v: "mm"},
+		{k: "unit", v: "t/d"},
+		{k: "unit", v: "µm"},
+		{k: "unit", v: "m^3"},
+		{k: "unit", v: "", err: bad, id: loc()},
+		{k: "unit", v: "m m", err: bad, id: loc()},
+		{k: "unit", v: "m;", err: bad, id: loc()},
+		{k: "unit", v: "aaaaaaaaaaaaaaaaaaaaa", err: bad, id: loc()},
+
+		{k: "asOf", v: "2017-01-11T12:12:12Z"},
+		{k: "asOf", v: "2017-01-11", err: bad, id: loc()},
+		{k: "time", v: "2017-01-11T12:12:12.123456Z"},
+		{k: "time", v: "now", err: bad, id: loc()},
+		{k: "reason", v: "corrected lab result (batch 12), see #34"},
+		{k: "reason", v: "Taupō"},
+		{k: "reason", v: " ", err: bad, id: loc()},
+		{k: "reason", v: "a\nb", err: bad, id: loc()},
+		{k: "reason", v: strings.Repeat("a", 201), err: bad, id: loc()},
+		{k: "quality", v: "raw"},
+		{k: "quality", v: "raw,verified,suspect,rejected"},
+		{k: "quality", v: "good", err: bad, id: loc()},
+		{k: "quality", v: "raw,", err: bad, id: loc()},
+		{k: "quality", v: "Raw", err: bad, id: loc()},
 	}
 
 	for _, v := range in {